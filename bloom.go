@@ -0,0 +1,71 @@
+package bmemcache
+
+import (
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+)
+
+// bloomFilter records the serialized keys written to the cache so that lookups of keys never
+// written can be answered without taking the lock. Bits are set and tested atomically, so it
+// is safe for concurrent use, but it never forgets a key: the cache replaces it as a whole
+// when its content is.
+type bloomFilter struct {
+	bits   []atomic.Uint64
+	hashes uint64
+}
+
+// newBloomFilter returns a filter sized for n keys with the given false positive rate.
+func newBloomFilter(n uint, fpRate float64) *bloomFilter {
+	if n == 0 {
+		n = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+	m := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/float64(n)*math.Ln2))
+	return &bloomFilter{
+		bits:   make([]atomic.Uint64, (uint64(m)+63)/64),
+		hashes: uint64(k),
+	}
+}
+
+// locations calls fn with the bit position of each hash of key, derived from two halves of a
+// single FNV-1a hash by double hashing.
+func (f *bloomFilter) locations(key string, fn func(word int, mask uint64) bool) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	size := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % size
+		if !fn(int(bit/64), 1<<(bit%64)) {
+			return
+		}
+	}
+}
+
+// add records key.
+func (f *bloomFilter) add(key string) {
+	f.locations(key, func(word int, mask uint64) bool {
+		f.bits[word].Or(mask)
+		return true
+	})
+}
+
+// mayContain reports whether key may have been recorded. False means it definitely was not.
+func (f *bloomFilter) mayContain(key string) bool {
+	found := true
+	f.locations(key, func(word int, mask uint64) bool {
+		found = f.bits[word].Load()&mask != 0
+		return found
+	})
+	return found
+}
+
+// empty returns a filter of the same size as f, with no key recorded.
+func (f *bloomFilter) empty() *bloomFilter {
+	return &bloomFilter{bits: make([]atomic.Uint64, len(f.bits)), hashes: f.hashes}
+}
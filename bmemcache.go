@@ -1,35 +1,360 @@
 package bmemcache
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // BMemCache defines the behavior for in-memory cache.
 type BMemCache[T any] interface {
-	// Set stores the given data in the cache.
+	// Set stores the given data in the cache, without expiration unless WithValueTTLFunc is
+	// configured.
+	//
+	// If the data cannot be stored (for example because the cache is full), it is silently
+	// discarded. Use TrySet to detect this.
 	//
 	// Parameters:
 	//   - data: The data to cache.
 	//   - keys: A variadic list of strings used to generate the cache key.
 	Set(data T, keys ...string)
 
+	// TrySet stores the given data in the cache, reporting whether it could be stored. Like Set,
+	// it uses the expiration derived by WithValueTTLFunc, if configured.
+	//
+	// Parameters:
+	//   - data: The data to cache.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - An error if the data could not be stored: ErrFull if the cache has reached its maximum
+	//     number of entries, ErrTooLarge if the data exceeds WithMaxValueSize, an error if the
+	//     keys are rejected by the configured key validation, or the error returned by the value
+	//     encoder if WithValueCodec is configured.
+	TrySet(data T, keys ...string) error
+
 	// Get retrieves the cached data associated with the provided keys.
 	//
+	// An expired entry encountered by Get is removed from the cache right away, so caches
+	// relying on lazy expiration do not need auto-cleanup to reclaim its memory. This can be
+	// disabled with WithLazyDeleteOnGet.
+	//
 	// Parameters:
 	//   - keys: A variadic list of strings used to generate the cache key.
 	//
 	// Returns:
 	//   - The cached data of type T.
-	//   - An error if the key is not found or if the cached entry has expired.
+	//   - An error if the key is not found, if the cached entry has expired, or if the cached
+	//     data cannot be decoded when WithValueCodec is configured.
 	Get(keys ...string) (T, error)
 
+	// GetTimeout retrieves the cached data associated with the provided keys, giving up if the
+	// read lock cannot be acquired within the given timeout, e.g. while a long write holds it.
+	//
+	// It gives callers a bound on the time spent waiting for the cache, at the cost of polling
+	// for the lock. Since it never waits for the write lock, it leaves expired entries in place,
+	// as Peek does, and does not trigger WithRefreshAhead. Hits and misses are otherwise
+	// reported as with Get: in Stats, to WithOnMiss and to WithObserver.
+	//
+	// Parameters:
+	//   - timeout: The maximum duration to wait for the read lock.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - The cached data of type T.
+	//   - An error wrapping context.DeadlineExceeded if the lock was not acquired in time, or
+	//     any error Get returns.
+	GetTimeout(timeout time.Duration, keys ...string) (T, error)
+
+	// GetOrDefault retrieves the cached data associated with the provided keys, or def if there
+	// is none. It behaves like Get, but reports every failure by returning def, which suits
+	// lookups with a natural fallback, such as configuration values.
+	//
+	// Parameters:
+	//   - def: The data to return if the key is not found, has expired or cannot be read.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - The cached data of type T, or def.
+	GetOrDefault(def T, keys ...string) T
+
+	// TryGet retrieves the cached data associated with the provided keys, reporting whether it
+	// was found like a map lookup does. It behaves like Get, but reports every failure by
+	// returning false, which reads more naturally in conditionals.
+	//
+	// Parameters:
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - The cached data of type T, or the zero value of T.
+	//   - true if the key holds an unexpired item that could be read, false otherwise.
+	TryGet(keys ...string) (T, bool)
+
+	// GetFirst retrieves the cached data of the first of the given keys holding an unexpired
+	// item, e.g. a specific override followed by the general default it falls back to.
+	//
+	// Every key is looked up under a single read lock, so the result reflects one state of the
+	// cache. A hit is counted for the matching key, or a single miss if none matches; misses are
+	// not reported to WithOnMiss, as no single key missed.
+	//
+	// Parameters:
+	//   - keyGroups: The keys to look up, in order of preference, one slice of key parts per item.
+	//
+	// Returns:
+	//   - The cached data of type T.
+	//   - The keys holding the returned data.
+	//   - ErrNotFound wrapped in a *CacheError holding the first of the keys if none of them holds
+	//     an unexpired item, or an error if any of the keys is invalid.
+	GetFirst(keyGroups ...[]string) (T, []string, error)
+
+	// Peek retrieves the cached data associated with the provided keys without modifying the cache.
+	//
+	// Unlike Get, Peek only ever takes the read lock: an expired entry is reported but left in
+	// place for the background auto-cleanup to reclaim. This trades eager reclamation of expired
+	// entries for read scalability.
+	//
+	// Peek does not update the per-entry access metadata either (see WithKeyStats): it counts
+	// in Stats, but leaves the hit count and last access time of the entry untouched. This lets
+	// maintenance reads, such as background scans, inspect entries without making them look hot.
+	//
+	// Parameters:
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - The cached data of type T.
+	//   - ErrNotFound or ErrExpired wrapped in a *CacheError if the key is not found or if the
+	//     cached entry has expired, or an error if the cached data cannot be decoded when
+	//     WithValueCodec is configured.
+	Peek(keys ...string) (T, error)
+
+	// GetStale retrieves the cached data associated with the provided keys, even if it has
+	// expired, to serve stale data when fresh data cannot be obtained.
+	//
+	// Like Peek, GetStale never removes the entry it reads. Expired data remains available until
+	// something else removes it: a Get, the auto-cleanup, eager expiration or a limit-driven
+	// reclamation. WithExpiredRetention guarantees that it remains available for a while.
+	// Reading expired data counts as a miss in Stats.
+	//
+	// Parameters:
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - The cached data of type T.
+	//   - Whether the data has expired.
+	//   - An error if the key is not found, or if the cached data cannot be decoded when
+	//     WithValueCodec is configured.
+	GetStale(keys ...string) (value T, stale bool, err error)
+
+	// GetAndRefresh retrieves the cached data associated with the provided keys and, on a hit,
+	// atomically resets its expiration to the given duration from now. Hits and misses are
+	// reported as with Get, in Stats and to WithOnMiss and WithObserver.
+	//
+	// Parameters:
+	//   - duration: The duration after which the cached data expires.
+	//               If zero, the data will no longer expire.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - The cached data of type T.
+	//   - ErrNotFound or ErrExpired wrapped in a *CacheError if the key is not found or if the
+	//     cached entry had already expired.
+	GetAndRefresh(duration time.Duration, keys ...string) (T, error)
+
+	// GetSet atomically stores the given data in the cache and returns the data it replaced.
+	//
+	// The data is stored like Set does: without expiration unless WithValueTTLFunc is configured,
+	// whatever the expiration of the replaced item was. If the data cannot be stored (for
+	// example because the cache is full), it is silently discarded.
+	//
+	// Parameters:
+	//   - data: The data to cache.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - The data previously cached under the keys, or the zero value if there was none.
+	//   - Whether an unexpired item was replaced. Expired items are reported as not existing.
+	GetSet(data T, keys ...string) (old T, existed bool)
+
+	// SwapWithExp atomically stores the given data in the cache with the given expiration and
+	// returns the data it replaced. It is the GetSet counterpart of SetWithExp.
+	//
+	// If the data cannot be stored (for example because the cache is full), it is silently
+	// discarded.
+	//
+	// Parameters:
+	//   - data: The data to cache.
+	//   - duration: The duration after which the cached data expires. Zero or less means no expiry.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - The data previously cached under the keys, or the zero value if there was none.
+	//   - Whether an unexpired item was replaced. Expired items are reported as not existing.
+	SwapWithExp(data T, duration time.Duration, keys ...string) (old T, existed bool)
+
+	// SetIfNewer atomically stores the given data in the cache along with its version, unless
+	// the item stored under the keys has the same or a greater version. It resolves conflicts
+	// between out-of-order writes, such as replicated updates, so that older data never
+	// replaces newer data.
+	//
+	// The data is stored like Set does: without expiration unless WithValueTTLFunc is configured.
+	// Expired items count as absent. Items stored by the other methods have version zero, and
+	// Update preserves the version of the item it modifies.
+	//
+	// Parameters:
+	//   - data: The data to cache.
+	//   - version: The version of the data.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - Whether the data was stored. It is false if the stored item is not older, or if the
+	//     data could not be stored, as with TrySet.
+	SetIfNewer(data T, version int64, keys ...string) (stored bool)
+
+	// GetOrSet retrieves the cached data associated with the provided keys or, if there is no
+	// unexpired item, loads it with loader and stores it like Set does.
+	//
+	// Concurrent calls for the same keys are coalesced: loader runs once and every caller
+	// receives its result, including its error. See GetOrSetWithExp.
+	//
+	// Parameters:
+	//   - loader: The function loading the data on a miss.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - The cached or loaded data of type T.
+	//   - The error returned by loader, or an error if the keys are invalid.
+	GetOrSet(loader func() (T, error), keys ...string) (T, error)
+
+	// GetOrSetWithExp retrieves the cached data associated with the provided keys or, if there
+	// is no unexpired item, loads it with loader and stores it with an expiration time.
+	//
+	// Concurrent calls for the same keys are coalesced: loader runs once and every caller
+	// receives its result, including its error. Errors are not cached, so the next call after
+	// a failed load tries again. As with SetWithExp, loaded data that cannot be stored (for
+	// example because the cache is full) is still returned. WithLoaderTimeout bounds how long
+	// callers wait for loader.
+	//
+	// Parameters:
+	//   - loader: The function loading the data on a miss.
+	//   - duration: The duration after which the loaded data expires.
+	//               If zero, the data will not expire unless WithMaxTTL is configured.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - The cached or loaded data of type T.
+	//   - The error returned by loader, an error wrapping context.DeadlineExceeded if loader
+	//     timed out, or an error if the keys are invalid.
+	GetOrSetWithExp(loader func() (T, error), duration time.Duration, keys ...string) (T, error)
+
+	// GetOrSetCtx is like GetOrSet, but passes loader a context that is cancelled once
+	// WithLoaderTimeout has elapsed, so that a slow loader can stop its work instead of running
+	// on in the background. Without a loader timeout, the context is never cancelled.
+	//
+	// Parameters:
+	//   - loader: The function loading the data on a miss, given the context of the load.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - The cached or loaded data of type T.
+	//   - The error returned by loader, an error wrapping context.DeadlineExceeded if loader
+	//     timed out, or an error if the keys are invalid.
+	GetOrSetCtx(loader func(ctx context.Context) (T, error), keys ...string) (T, error)
+
+	// WaitGet retrieves the cached data associated with the provided keys, waiting for it to be
+	// stored if there is no unexpired item yet.
+	//
+	// Parameters:
+	//   - ctx: The context bounding the wait.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - The cached data of type T.
+	//   - ctx.Err() if ctx is done before the data is stored, an error if the keys are invalid,
+	//     or an error if the cached data cannot be decoded when WithValueCodec is configured.
+	WaitGet(ctx context.Context, keys ...string) (T, error)
+
 	// Gets retrieves all cached data items currently stored.
 	//
 	// Returns:
 	//   - A slice of cached data of type T.
+	//   - ErrEmpty if the cache holds no unexpired items.
 	Gets() ([]T, error)
 
+	// GetsMap retrieves all cached data items currently stored, keyed by their cache key.
+	//
+	// Map keys are the JSON-serialized form of the key parts (e.g. `["user","42"]`), which is
+	// unambiguous whatever the key parts contain. Decoding a map key with encoding/json yields
+	// the key parts as returned by Keys.
+	//
+	// Returns:
+	//   - A map from serialized cache key to cached data of type T, holding every unexpired item.
+	GetsMap() map[string]T
+
+	// Filter retrieves the cached data items for which pred returns true.
+	//
+	// pred is called once for each unexpired item, on a snapshot taken under the read lock, so
+	// it runs without holding the lock and may safely call back into the cache. Items stored or
+	// removed while Filter runs may or may not be considered.
+	//
+	// Parameters:
+	//   - pred: The function reporting whether the item with the given key parts and data matches.
+	//
+	// Returns:
+	//   - A slice of cached data of type T holding every matching item.
+	Filter(pred func(keys []string, value T) bool) []T
+
+	// FilterKeys returns the cache keys of the cached data items for which pred returns true.
+	// pred is called the same way as with Filter.
+	//
+	// Parameters:
+	//   - pred: The function reporting whether the item with the given key parts and data matches.
+	//
+	// Returns:
+	//   - A slice of cache keys holding every matching item.
+	FilterKeys(pred func(keys []string, value T) bool) [][]string
+
+	// RangeUnlocked calls fn for each unexpired item, without holding the lock for the whole
+	// traversal, so that writers are not blocked while a large cache is scanned.
+	//
+	// Only the set of keys is snapshotted under the read lock. Each item is then looked up on
+	// its own, briefly taking the read lock again, and fn runs without holding the lock, so it
+	// may safely call back into the cache. The view is weakly consistent: items stored after
+	// the snapshot are not visited, items removed or expired before their turn are skipped, and
+	// items replaced in the meantime are visited with their latest value. Lookups are not
+	// counted in Stats.
+	//
+	// Parameters:
+	//   - fn: The function to call with the key parts and data of each item. Returning false
+	//         stops the iteration.
+	RangeUnlocked(fn func(keys []string, value T) bool)
+
+	// All returns an iterator over the unexpired items, for use with range:
+	//
+	//	for keys, value := range cache.All() {
+	//	    ...
+	//	}
+	//
+	// Items are visited as RangeUnlocked does, so the iteration does not hold the lock while
+	// the loop body runs, and offers the same weakly consistent view.
+	//
+	// Returns:
+	//   - An iterator yielding the key parts and data of each unexpired item.
+	All() iter.Seq2[[]string, T]
+
+	// AllKeys returns an iterator over the keys of the unexpired items, for use with range. Like
+	// All, it does not hold the lock while the loop body runs.
+	//
+	// Returns:
+	//   - An iterator yielding the key parts of each unexpired item.
+	AllKeys() iter.Seq[[]string]
+
 	// GetsFromPrefix retrieves all cached data items whose keys match the specified prefix.
 	//
 	// Parameters:
@@ -37,9 +362,90 @@ type BMemCache[T any] interface {
 	//
 	// Returns:
 	//   - A slice of cached data of type T that match the specified prefix.
-	//   - An error if the key prefix criteria is not found.
+	//   - ErrEmpty if the cache holds no unexpired items at all, or ErrNotFound if it does
+	//     but none of them match the prefix.
 	GetsFromPrefix(keys ...string) ([]T, error)
 
+	// GetsFromPrefixPage retrieves up to limit cached data items whose keys match the specified
+	// prefix, along with a cursor to resume from, so that a large prefix can be scanned without
+	// materializing all of its items at once.
+	//
+	// Items are returned in the order of their keys and, like KeysPage, pages are computed on
+	// demand: the cursor only records the position of the last returned key. An item stored for
+	// the whole duration of the paging is returned exactly once, while items added or removed in
+	// the meantime may or may not be returned.
+	//
+	// Parameters:
+	//   - limit: The maximum number of items to return. A value of 0 or less returns all remaining
+	//            items.
+	//   - cursor: The cursor returned by the previous call, or an empty string to start from the
+	//             beginning.
+	//   - keys: A variadic list of strings used to construct the prefix for matching cache keys.
+	//
+	// Returns:
+	//   - A slice of cached data of type T that match the specified prefix.
+	//   - The cursor to pass to the next call, or an empty string when there are no more items.
+	//   - ErrInvalidCursor if the cursor is malformed. On the first page only, ErrEmpty if the
+	//     cache holds no unexpired items at all, or ErrNotFound if none of them match the prefix.
+	GetsFromPrefixPage(limit int, cursor string, keys ...string) (values []T, nextCursor string, err error)
+
+	// EntriesFromPrefix retrieves all cached data items whose keys match the specified prefix,
+	// along with their keys. It behaves like GetsFromPrefix, but tells which key each item
+	// comes from.
+	//
+	// Parameters:
+	//   - keys: A variadic list of strings used to construct the prefix for matching cache keys.
+	//
+	// Returns:
+	//   - A slice holding the key parts and cached data of each item that matches the prefix.
+	//   - ErrEmpty if the cache holds no unexpired items at all, or ErrNotFound if it does
+	//     but none of them match the prefix.
+	EntriesFromPrefix(keys ...string) ([]PrefixEntry[T], error)
+
+	// LookupByIndex retrieves the cached data items whose field extracted by the index registered
+	// with WithIndex under the given name equals fieldValue.
+	//
+	// The matching keys are collected under a single read lock, so the result reflects one state
+	// of the cache. Items are returned in the order of their keys, and expired items are skipped.
+	//
+	// Parameters:
+	//   - name: The name of the index, as given to WithIndex.
+	//   - fieldValue: The field value to look up.
+	//
+	// Returns:
+	//   - A slice of cached data of type T whose indexed field equals fieldValue.
+	//   - ErrUnknownIndex if no index is registered under name, or ErrNotFound if no unexpired
+	//     item matches.
+	LookupByIndex(name, fieldValue string) ([]T, error)
+
+	// Update atomically replaces the cached data with the result of fn applied to the current
+	// data, preserving the existing expiration.
+	//
+	// fn runs while the cache is write-locked, so it must not call back into the cache.
+	//
+	// Parameters:
+	//   - fn: A function receiving the current data and returning the data to store.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - ErrNotFound or ErrExpired wrapped in a *CacheError if the key is not found or if the
+	//     cached entry has expired.
+	Update(fn func(old T) T, keys ...string) error
+
+	// TransformAll replaces the data of every unexpired item with the result of fn applied to
+	// it, e.g. to migrate the cached values to a new schema in place. Like Update, it preserves
+	// the expiration of each item.
+	//
+	// All the items are transformed under a single write lock, so no reader observes a mix of
+	// old and new values, and fn must not call back into the cache. Expired items are left
+	// untouched, as are items whose transformed data cannot be stored, e.g. because it exceeds
+	// WithMaxValueSize. It does nothing on a frozen cache.
+	//
+	// Parameters:
+	//   - fn: A function receiving the key parts and current data of an item and returning the
+	//         data to store.
+	TransformAll(fn func(keys []string, old T) T)
+
 	// Delete removes an item from the cache based on the provided keys.
 	//
 	// Parameters:
@@ -49,87 +455,573 @@ type BMemCache[T any] interface {
 	//   - An error if the key does not exist.
 	Delete(keys ...string) error
 
+	// DeleteMany removes the items stored under each of the given keys, under a single lock.
+	// It is the removal counterpart of SetManyWithExp.
+	//
+	// Keys that are not found or that are rejected by the configured key validation are skipped.
+	// Nothing is removed from a frozen cache.
+	//
+	// Parameters:
+	//   - keyGroups: The keys of the items to remove, one slice of key parts per item.
+	//
+	// Returns:
+	//   - The number of items removed.
+	DeleteMany(keyGroups [][]string) int
+
 	// Keys returns a list of all unique cache keys currently stored.
 	//
 	// Returns:
 	//   - A slice of strings, where each string represents a cache key.
 	Keys() [][]string
 
+	// KeyStrings returns the cache keys currently stored, each as a single string, e.g. for logs
+	// or external systems.
+	//
+	// Key parts are not joined with a separator, which would make keys whose parts contain it
+	// ambiguous. Like the map keys of GetsMap, each string is the JSON-serialized form of the
+	// key parts (e.g. `["user","42"]`), which decodes back to the parts returned by Keys.
+	//
+	// Returns:
+	//   - A slice holding the serialized form of each stored key.
+	KeyStrings() []string
+
+	// KeysSorted returns the cache keys currently stored, in a deterministic order.
+	//
+	// Keys are compared part by part, as strings, and a key sorts before the longer keys it is
+	// a prefix of. For example, ["a"] sorts before ["a", "b"], which sorts before ["b"].
+	//
+	// Returns:
+	//   - A sorted slice of cache keys.
+	KeysSorted() [][]string
+
 	// KeysFromPrefix returns all cache keys that match the given prefix pattern.
 	//
 	// Parameters:
-	//   - keys: A variadic list of strings used to construct the prefix to match against stored cache keys.
-	//           The prefix typically represents the beginning part of a key hierarchy.
+	//   - keys: A variadic list of strings used to construct the prefix to match against stored
+	//           cache keys. The prefix typically represents the beginning part of a key hierarchy.
 	//
 	// Returns:
 	//   - A slice of strings representing cache keys that start with the specified prefix.
 	KeysFromPrefix(keys ...string) [][]string
 
+	// KeysFromSuffix returns all cache keys that match the given suffix pattern.
+	//
+	// A key matches when it has at least as many parts as the suffix and its last parts equal
+	// the suffix parts. An empty suffix matches every key.
+	//
+	// Parameters:
+	//   - keys: A variadic list of strings used to construct the suffix to match against stored
+	//           cache keys. The suffix typically represents the trailing part of a key hierarchy,
+	//           such as a type.
+	//
+	// Returns:
+	//   - A slice of strings representing cache keys that end with the specified suffix.
+	KeysFromSuffix(keys ...string) [][]string
+
+	// KeysPage returns up to limit cache keys, in a stable order, along with a cursor to
+	// resume from.
+	//
+	// Pages are computed on demand rather than from a snapshot: the cursor only records the
+	// position of the last returned key. A key stored for the whole duration of the paging
+	// is returned exactly once, while keys added or removed in the meantime may or may not
+	// be returned.
+	//
+	// Parameters:
+	//   - limit: The maximum number of keys to return. A value of 0 or less returns all remaining
+	//            keys.
+	//   - cursor: The cursor returned by the previous call, or an empty string to start from the
+	//             beginning.
+	//
+	// Returns:
+	//   - A slice of cache keys.
+	//   - The cursor to pass to the next call, or an empty string when there are no more keys.
+	KeysPage(limit int, cursor string) (keys [][]string, nextCursor string)
+
 	// SetWithExp stores the data in the cache with an expiration time.
 	//
+	// If the data cannot be stored (for example because the cache is full), it is silently
+	// discarded. Use TrySetWithExp to detect this.
+	//
 	// Parameters:
 	//   - data: The data to cache.
-	//   - duration: The duration after which the cached data expires.
-	//               If zero, the data will not expire.
+	//   - duration: The duration after which the cached data expires, bounded by WithMinTTL and
+	//               WithMaxTTL. If zero, the data will not expire unless WithMaxTTL is configured.
 	//   - keys: A variadic list of strings used to generate the cache key.
 	SetWithExp(data T, duration time.Duration, keys ...string)
 
-	// IsExist checks if an item exists in the cache for the given keys.
+	// SetAndKey stores the data like Set does and returns the cache key it is stored under, e.g.
+	// for logging or indexing.
 	//
 	// Parameters:
+	//   - data: The data to cache.
 	//   - keys: A variadic list of strings used to generate the cache key.
 	//
 	// Returns:
-	//   - true if the item exists, false otherwise.
-	IsExist(keys ...string) bool
+	//   - The serialized cache key, in the form returned by KeyStrings. It is returned even if
+	//     the data is discarded, e.g. because the cache is full.
+	SetAndKey(data T, keys ...string) string
 
-	// IsExpired checks whether the cached item associated with the given keys is expired.
+	// SetWithExpAndKey stores the data like SetWithExp does and returns the cache key it is
+	// stored under, e.g. for logging or indexing.
 	//
 	// Parameters:
+	//   - data: The data to cache.
+	//   - duration: The duration after which the cached data expires. If zero, the data will not
+	//               expire.
 	//   - keys: A variadic list of strings used to generate the cache key.
 	//
 	// Returns:
-	//   - A boolean indicating if the item is expired.
-	//   - An error if the key is not found.
-	IsExpired(keys ...string) (bool, error)
+	//   - The serialized cache key, in the form returned by KeyStrings. It is returned even if
+	//     the data is discarded, e.g. because the cache is full.
+	SetWithExpAndKey(data T, duration time.Duration, keys ...string) string
 
-	// TTL returns the remaining time before the cached item expires.
+	// SetManyWithExp stores a batch of items in the cache, each with its own expiration. It is
+	// the batched form of SetWithExp: all items are stored under a single acquisition of the
+	// lock, so concurrent readers observe either none or all of them.
+	//
+	// Items that cannot be stored (for example because the cache is full or their keys are
+	// rejected) are discarded, while the rest of the batch is stored. The batch is then reported
+	// as failed to WithMetricsCallback.
+	//
+	// Parameters:
+	//   - entries: The items to cache. A zero Duration means that the item does not expire unless
+	//              WithMaxTTL is configured. For duplicate keys, the last item wins.
+	SetManyWithExp(entries []EntryWithExp[T])
+
+	// LoadFrom stores the items received from ch as they arrive, e.g. to warm the cache from a
+	// stream at startup, until ch is closed or ctx is done.
+	//
+	// Items already waiting in the channel are stored together, as SetManyWithExp would, so
+	// bursts take the lock once per batch rather than once per item. Items that cannot be
+	// stored are silently discarded.
+	//
+	// Parameters:
+	//   - ctx: The context whose cancellation stops the consumption of ch.
+	//   - ch: The channel to receive the items to cache from.
+	//
+	// Returns:
+	//   - The error of ctx if it is done before ch is closed, nil otherwise.
+	LoadFrom(ctx context.Context, ch <-chan EntryWithExp[T]) error
+
+	// TrySetWithExp stores the data in the cache with an expiration time, reporting whether
+	// it could be stored.
 	//
 	// Parameters:
+	//   - data: The data to cache.
+	//   - duration: The duration after which the cached data expires, bounded by WithMinTTL and
+	//               WithMaxTTL. If zero, the data will not expire unless WithMaxTTL is configured.
 	//   - keys: A variadic list of strings used to generate the cache key.
 	//
 	// Returns:
-	//   - A time.Duration representing the remaining time until expiration.
-	//   - An error if the key is not found or if the item has already expired.
-	TTL(keys ...string) (time.Duration, error)
+	//   - An error if the data could not be stored: ErrFull if the cache has reached its maximum
+	//     number of entries, ErrTooLarge if the data exceeds WithMaxValueSize, an error if the
+	//     keys are rejected by the configured key validation, or the error returned by the value
+	//     encoder if WithValueCodec is configured.
+	TrySetWithExp(data T, duration time.Duration, keys ...string) error
 
-	// Clear removes all items from the cache.
-	Clear()
+	// TouchPrefix sets the expiration of every cached item whose keys match the specified prefix.
+	//
+	// All matching items are updated under a single lock, so they share the exact same
+	// expiration time. Items that have already expired are left untouched.
+	//
+	// Parameters:
+	//   - duration: The duration after which the matching items expire.
+	//               If zero, the matching items will not expire.
+	//   - keys: A variadic list of strings used to construct the prefix for matching cache keys.
+	//           An empty prefix matches every item.
+	//
+	// Returns:
+	//   - The number of items updated.
+	TouchPrefix(duration time.Duration, keys ...string) int
 
-	// Close stops the autoCleanup goroutine, releasing any associated resources.
+	// SetWithExpireAt stores the data in the cache with an absolute expiration time.
 	//
-	// This method should be called when the cache is no longer needed.
-	Close()
-}
+	// If the data cannot be stored (for example because the cache is full), it is silently
+	// discarded.
+	//
+	// Parameters:
+	//   - data: The data to cache.
+	//   - t: The time at which the cached data expires. A time in the past stores the data
+	//        already expired. If zero, the data will not expire.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	SetWithExpireAt(data T, t time.Time, keys ...string)
 
-// New initializes a new BMemCache instance with optional configuration options.
-// It sets up the underlying storage and, if enabled, starts the background auto-cleanup.
-//
-// Parameters:
-//   - options: Variadic list of Option values to configure the cache.
-//
-// Returns:
-//   - A BMemCache instance configured as specified.
-//
-// Example usage:
-//
-//	// Import the package and any necessary options.
-//	import (
-//	    "fmt"
-//	    "time"
-//	    "github.com/bearaujus/bmemcache"
-//	)
-//
+	// ExpireAt sets an absolute expiration time on the cached item associated with the given keys.
+	//
+	// Parameters:
+	//   - t: The time at which the cached item expires. A time in the past expires the item
+	//        immediately. If zero, the item will no longer expire.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - ErrNotFound or ErrExpired wrapped in a *CacheError if the key is not found or if the
+	//     cached entry had already expired.
+	ExpireAt(t time.Time, keys ...string) error
+
+	// UpdateExp resets the expiration of the cached item associated with the given keys to the
+	// given duration from now, leaving its data untouched.
+	//
+	// Unlike the Set family, UpdateExp never creates an item: it fails when there is no
+	// unexpired item to update.
+	//
+	// Parameters:
+	//   - duration: The duration after which the cached item expires.
+	//               If zero, the item will no longer expire unless WithMaxTTL is configured.
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - ErrNotFound or ErrExpired wrapped in a *CacheError if the key is not found or if the
+	//     cached entry had already expired.
+	UpdateExp(duration time.Duration, keys ...string) error
+
+	// Export returns all unexpired items currently stored, along with their keys and expiration.
+	//
+	// The result is a transport-neutral snapshot that can be shipped elsewhere and loaded into
+	// another cache with Import. When it is encoded with encoding/gob and T is an interface, the
+	// concrete types of the values must be registered with gob.Register, as for any gob value.
+	//
+	// Returns:
+	//   - A slice of KeyValueExp holding every unexpired item.
+	Export() []KeyValueExp[T]
+
+	// Import stores the given items, keeping their absolute expiration.
+	//
+	// All items are stored under a single lock. Items that have already expired, or that are
+	// rejected by the configured key validation, value codec or entry limit, are skipped.
+	//
+	// Parameters:
+	//   - kvs: The items to store, typically obtained from Export.
+	//   - overwrite: Whether an item replaces an unexpired item already stored under the same keys.
+	//                If false, such items are skipped.
+	Import(kvs []KeyValueExp[T], overwrite bool)
+
+	// Merge copies all unexpired items of another cache into this one, keeping their absolute
+	// expiration. It is equivalent to Import(other.Export(), overwrite).
+	//
+	// The items of other are snapshotted under its read lock, which is released before this
+	// cache is locked to store them. The two locks are never held together, so caches can be
+	// merged into each other concurrently, or a cache into itself, without deadlocking. Writes
+	// made to other during the merge may or may not be copied.
+	//
+	// Parameters:
+	//   - other: The cache to copy the items from. It is left unchanged.
+	//   - overwrite: Whether an item replaces an unexpired item already stored under the same keys.
+	//                If false, such items are skipped.
+	Merge(other BMemCache[T], overwrite bool)
+
+	// ReplaceAll atomically replaces the whole content of the cache with the given items.
+	//
+	// The new storage is built without holding the lock and swapped in at once, so readers
+	// observe either the previous content or the new one, never an empty or partial cache.
+	// Replaced entries are dropped without being reported to WithOnExpire, as with Clear.
+	// Items with invalid keys, items that cannot be encoded and expired items are skipped.
+	// When WithMaxEntriesReject, WithCapacity or WithPrefixLimit is configured, the items are
+	// stored in order and the overflow policy applies to them as it would to Set: RejectNew
+	// drops the items beyond the limits, keeping the first ones, while the eviction policies
+	// make room among the items stored before, so EvictLRU keeps the last ones. Items dropped
+	// or evicted this way were never visible, so they are not reported as evictions.
+	//
+	// Parameters:
+	//   - kvs: The items making up the new content of the cache. For duplicate keys, the last item
+	//          wins.
+	ReplaceAll(kvs []KeyValueExp[T])
+
+	// Replay applies the write-ahead log written by WithWriteThrough, restoring the content the
+	// logged cache had when the log was last flushed.
+	//
+	// Logged writes are applied in order, like SetWithExpireAt, Delete and Clear would be, so
+	// replaying into a non-empty cache merges the log into it. Items that have expired since
+	// they were logged are skipped.
+	//
+	// Parameters:
+	//   - r: The reader to read the log from.
+	//
+	// Returns:
+	//   - An error if the log cannot be read or decoded. The records before it have been applied.
+	Replay(r io.Reader) error
+
+	// IsExist checks if an item exists in the cache for the given keys.
+	//
+	// An expired item that has not been cleaned up yet still exists. Use IsValid to check
+	// whether the item can actually be retrieved with Get.
+	//
+	// Parameters:
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - true if the item exists, false otherwise.
+	IsExist(keys ...string) bool
+
+	// IsValid checks if an unexpired item exists in the cache for the given keys.
+	//
+	// Unlike IsExist, it reports false for expired items that have not been cleaned up yet,
+	// so a true result means Get would return the item.
+	//
+	// Parameters:
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - true if the item exists and has not expired, false otherwise.
+	IsValid(keys ...string) bool
+
+	// IsExpired checks whether the cached item associated with the given keys is expired.
+	//
+	// Parameters:
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - A boolean indicating if the item is expired.
+	//   - An error if the key is not found.
+	IsExpired(keys ...string) (bool, error)
+
+	// TTL returns the remaining time before the cached item expires.
+	//
+	// Parameters:
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - A time.Duration representing the remaining time until expiration.
+	//   - An error if the key is not found or if the item has already expired.
+	TTL(keys ...string) (time.Duration, error)
+
+	// ExpiresAt returns the absolute time at which the cached item expires. Unlike TTL, it
+	// returns the stored expiration as is, rather than a duration relative to the call.
+	//
+	// Parameters:
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - The expiration time of the item, or the zero time if it does not expire.
+	//   - Whether the item has an expiration.
+	//   - An error if the key is not found or if the item has already expired.
+	ExpiresAt(keys ...string) (exp time.Time, ok bool, err error)
+
+	// Age returns how long ago the cached item was stored, regardless of its expiration. Like
+	// the creation time reported by KeyStats, it is preserved by Update and by changes of the
+	// expiration, but reset when the item is overwritten.
+	//
+	// Parameters:
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - The time elapsed since the item was stored.
+	//   - An error if the key is not found or if the item has already expired.
+	Age(keys ...string) (time.Duration, error)
+
+	// Remaining returns the remaining time before the cached item expires, without reporting
+	// why there is none. It is a convenience over TTL for callers that never branch on the error.
+	//
+	// Parameters:
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - A time.Duration representing the remaining time until expiration, -1 if the item does
+	//     not expire, or 0 if the item is not found, has already expired or the keys are invalid.
+	Remaining(keys ...string) time.Duration
+
+	// Len returns the number of items currently stored, including expired items that have not
+	// been removed yet.
+	//
+	// Returns:
+	//   - The number of stored items.
+	Len() int
+
+	// ExpiredCount returns the number of expired items that have not been removed yet.
+	//
+	// Compared with Len, it tells how much of the cache is waiting for the cleanup, e.g. to tune
+	// the auto-cleanup interval. It is a diagnostic: it scans every item under the read lock, so
+	// it should not be called on hot paths.
+	//
+	// Returns:
+	//   - The number of stored items that have expired.
+	ExpiredCount() int
+
+	// TTLHistogram counts the live items by remaining time before expiration, e.g. to tune the
+	// auto-cleanup interval or to anticipate how much memory expirations will release.
+	//
+	// Each bucket is an upper bound: an item is counted under the smallest bucket greater than or
+	// equal to its remaining time. Items that do not expire are counted under -1, as reported by
+	// TTL, and items outside every bucket under math.MaxInt64. Every bucket is present in the
+	// result, even when empty. Expired items are not counted, see ExpiredCount.
+	//
+	// Like ExpiredCount, it is a diagnostic: it scans every item under the read lock, which
+	// costs O(n log b) for n items and b buckets, so it should not be called on hot paths.
+	//
+	// Parameters:
+	//   - buckets: The upper bounds of the buckets, in any order.
+	//
+	// Returns:
+	//   - The number of live items per bucket.
+	TTLHistogram(buckets []time.Duration) map[time.Duration]int
+
+	// Size returns the total size of the stored items, as measured by the function configured
+	// with WithSizeOf. Like Len, it includes expired items that have not been removed yet.
+	//
+	// Returns:
+	//   - The total size of the stored items, or 0 if WithSizeOf is not configured.
+	Size() int64
+
+	// Stats returns a snapshot of the cache usage counters accumulated since creation.
+	//
+	// Returns:
+	//   - A Stats value holding the counters.
+	Stats() Stats
+
+	// ResetStats zeroes the cache usage counters, leaving the stored items untouched, e.g. to
+	// report them per interval rather than since creation.
+	//
+	// Each counter is swapped atomically with zero, so that increments concurrent with the call
+	// are counted either in the returned snapshot or in the following one, never lost or
+	// counted twice. Exporters treating the counters as monotonic, such as bmemcacheprom, see
+	// a counter reset.
+	//
+	// Returns:
+	//   - A Stats value holding the counters accumulated until the reset.
+	ResetStats() Stats
+
+	// EvictFraction removes approximately the given fraction of the stored items, regardless of
+	// their expiration, to shed memory, e.g. from a memory-pressure handler. Unlike the cleanup,
+	// which only removes expired items, it removes live items too.
+	//
+	// Expired items are selected first. The live items are then selected by least recent
+	// access when WithKeyStats is configured, where an item never read counts as accessed when
+	// it was stored, or arbitrarily otherwise. Evicted items are counted in Stats.Evictions and
+	// are not reported to WithOnExpire. It does nothing on a frozen cache.
+	//
+	// Eviction sorts all the items under the write lock, so it costs O(n log n) and blocks the
+	// cache meanwhile.
+	//
+	// Parameters:
+	//   - f: The fraction of the items to remove, from 0 to 1. The number of items is rounded
+	//        to the nearest integer.
+	//
+	// Returns:
+	//   - The number of items removed.
+	EvictFraction(f float64) int
+
+	// KeyStats returns the access statistics of the cached item associated with the given keys.
+	//
+	// Hits and last access are only tracked when WithKeyStats, or WithCapacity with EvictLRU or
+	// EvictLFU, is configured, otherwise they are left zero.
+	//
+	// Parameters:
+	//   - keys: A variadic list of strings used to generate the cache key.
+	//
+	// Returns:
+	//   - A KeyStat holding the statistics of the item.
+	//   - ErrNotFound or ErrExpired wrapped in a *CacheError if the key is not found or if the
+	//     item has already expired.
+	KeyStats(keys ...string) (KeyStat, error)
+
+	// Healthy reports whether the auto-cleanup goroutine is running and keeping up, e.g. for a
+	// readiness probe: without it, expired items pile up until they are read.
+	//
+	// The goroutine records the time of each completed sweep. The cache is reported unhealthy if
+	// auto-cleanup is not configured, once the cache is closed or frozen, or when no sweep has
+	// completed for three intervals, e.g. because a sweep is stuck behind a long-held lock.
+	//
+	// Returns:
+	//   - true if auto-cleanup is healthy, false otherwise.
+	//   - ErrCleanupDisabled, ErrClosed or an error wrapping ErrCleanupStalled when unhealthy.
+	Healthy() (bool, error)
+
+	// Name returns the name given to the cache with WithName.
+	//
+	// Returns:
+	//   - The name of the cache, or an empty string if it has none.
+	Name() string
+
+	// String describes the cache for logging purposes.
+	//
+	// Returns:
+	//   - A string holding the name of the cache, its number of entries and whether auto-cleanup
+	//     is enabled.
+	String() string
+
+	// Clear removes all items from the cache.
+	//
+	// The storage is swapped for an empty one under the write lock, so reads that take their
+	// snapshot under a single read lock (Keys, Gets, GetsMap, EntriesFromPrefix, Export, Filter,
+	// ...) observe either every item from before the clear or none of them, never part of them.
+	// RangeUnlocked and All are weakly consistent instead: they skip the items a concurrent
+	// clear removed before their turn.
+	Clear()
+
+	// Drain atomically removes all items from the cache and returns the unexpired ones, so that
+	// each of them can be processed exactly once, e.g. when shutting down. Unlike Gets followed
+	// by Clear, no item stored in between is lost.
+	//
+	// Like Clear, it does nothing on a frozen cache, and reports the removal to WithObserver as
+	// OpClear but not to WithOnExpire. Items whose value cannot be decoded are dropped.
+	//
+	// Returns:
+	//   - The removed unexpired items, with their remaining time before expiration. They can be
+	//     stored again with SetManyWithExp.
+	Drain() []EntryWithExp[T]
+
+	// Compact removes the expired items, reporting them to WithOnExpire, and rebuilds the
+	// storage to fit the remaining ones.
+	//
+	// Go maps do not shrink when items are deleted, so a cache that once held many more items
+	// than it does now keeps the memory of its peak size. Compacting releases it. It copies
+	// every item under the write lock, blocking the cache for O(n), so it should be called
+	// sparingly, such as after deleting a large share of the items. On a frozen cache, expired
+	// items are kept.
+	Compact()
+
+	// Reset removes all items from the cache and reconfigures it with the given options, as if
+	// it had been created by New, so that an instance can be reused instead of reallocated.
+	// Unlike Clear, which keeps the configuration, options that are not given revert to their
+	// defaults.
+	//
+	// The options defining the TTLs, limits, key validation, expired entry handling, loader
+	// timeout and WithCleanupEveryNWrites are reapplied. The others are fixed at creation and
+	// keep their original value: the name, the background goroutines (WithAutoCleanUp,
+	// WithEagerExpiration, WithWriteThrough), the representation of entries (WithKeyStats,
+	// WithSizeOf, WithCopyOnGet, WithValueCodec, WithIndex) and the callbacks (WithOnExpire,
+	// WithOnSet, WithPanicOnOverwrite, WithMetricsCallback, WithRefreshAhead,
+	// WithLoaderConcurrency, WithOnMiss, WithObserver).
+	//
+	// Reset is safe to call concurrently with other operations, each of which observes either
+	// the previous configuration or the new one. Like Clear, it does nothing on a frozen cache.
+	//
+	// Parameters:
+	//   - options: The options to reconfigure the cache with. It panics if they are
+	//              inconsistent, as New does, leaving the cache untouched.
+	Reset(options ...Option)
+
+	// Freeze makes the cache read-only. Freezing is one-way: a frozen cache cannot be thawed.
+	//
+	// Once frozen, writes are rejected: methods returning an error (TrySet, Update, Delete,
+	// ExpireAt, GetAndRefresh, ...) return ErrFrozen, while the others (Set, Clear, Import,
+	// TouchPrefix, ...) do nothing. Reads are unaffected, and entries keep expiring, but
+	// expired entries are no longer removed; auto-cleanup is stopped as well.
+	Freeze()
+
+	// Close stops the autoCleanup goroutine, releasing any associated resources.
+	//
+	// This method should be called when the cache is no longer needed.
+	Close()
+}
+
+// New initializes a new BMemCache instance with optional configuration options.
+// It sets up the underlying storage and, if enabled, starts the background auto-cleanup.
+//
+// Parameters:
+//   - options: Variadic list of Option values to configure the cache.
+//
+// Returns:
+//   - A BMemCache instance configured as specified.
+//
+// Example usage:
+//
+//	// Import the package and any necessary options.
+//	import (
+//	    "fmt"
+//	    "time"
+//	    "github.com/bearaujus/bmemcache"
+//	)
+//
 //	func main() {
 //	    // Create a new cache instance with auto-cleanup enabled every 30 seconds
 //	    // and a custom key separator ("-").
@@ -171,181 +1063,1754 @@ func New[T any](options ...Option) BMemCache[T] {
 	for _, v := range options {
 		v.Apply(o)
 	}
+	conf := newSettings[T](o)
 	cache := &bmemCache[T]{
-		items: make(map[string]*cacheEntry[T]),
+		name:            o.Name,
+		cleanupInterval: o.AutoCleanupInterval,
+		eagerExpiration: o.EagerExpiration,
+		items:           make(map[string]*cacheEntry[T], o.InitialCapacity),
+		onOverwrite:     o.OnOverwrite,
+		onMiss:          o.OnMiss,
+		observer:        typedOption[func(Observation[T])](o.Observer, "WithObserver"),
+		metrics:         o.MetricsCallback,
+		keyStats:        o.KeyStats,
+		onExpire:        typedOption[func([]string, T)](o.OnExpire, "WithOnExpire"),
+		onSet:           typedOption[func([]string, T, time.Duration)](o.OnSet, "WithOnSet"),
+		sizeOf:          typedOption[func(T) int64](o.SizeOf, "WithSizeOf"),
+		copyOnGet:       typedOption[func(T) T](o.CopyOnGet, "WithCopyOnGet"),
+		secondaries:     newSecondaryIndexes[T](o.Indexes),
+		refreshLead:     o.RefreshLead,
+		refresh:         typedOption[func([]string) (T, time.Duration, error)](o.RefreshFunc, "WithRefreshAhead"),
+		encode:          typedOption[func(T) ([]byte, error)](o.ValueEncoder, "WithValueCodec"),
+		decode:          typedOption[func([]byte) (T, error)](o.ValueDecoder, "WithValueCodec"),
 	}
-	if o.AutoCleanup {
+	cache.checkSettings(conf)
+	cache.conf.Store(conf)
+	cache.recountLocked()
+	if o.AutoCleanup || o.EagerExpiration || o.WALWriter != nil {
 		cache.doneChan = make(chan struct{})
-		go cache.autoCleanup(o.AutoCleanupInterval)
+	}
+	if o.AutoCleanup {
+		cache.lastSweep.Store(time.Now().UnixNano())
+		go cache.autoCleanup(cache.cleanupInterval)
+	}
+	if o.EagerExpiration {
+		cache.wake = make(chan struct{}, 1)
+		go cache.eagerExpire()
+	}
+	if o.SyncMapBackend {
+		cache.index.Store(new(sync.Map))
+	}
+	if o.BloomExpectedN > 0 {
+		cache.bloom.Store(newBloomFilter(o.BloomExpectedN, o.BloomFPRate))
+	}
+	if o.LoaderConcurrency > 0 {
+		cache.refreshSlots = make(chan struct{}, o.LoaderConcurrency)
+	}
+	if o.WALWriter != nil {
+		cache.walWriter = o.WALWriter
+		cache.walDone = make(chan struct{})
+		go cache.writeThrough(o.WALInterval)
 	}
 	return cache
 }
 
 type bmemCache[T any] struct {
-	items    map[string]*cacheEntry[T]
-	mu       sync.RWMutex
-	doneOnce sync.Once
-	doneChan chan struct{}
+	// Counters are accessed atomically and kept first to guarantee 64-bit alignment.
+	hits        int64
+	misses      int64
+	expirations int64
+	evictions   int64
+	writes      int64 // writes since the last write-triggered cleanup
+
+	name            string
+	cleanupInterval time.Duration
+	lastSweep       atomic.Int64 // unix nanoseconds of the last auto-cleanup sweep, or of its start
+	eagerExpiration bool
+	conf            atomic.Value // *settings[T], replaced by Reset
+	items           map[string]*cacheEntry[T]
+	index           atomic.Pointer[sync.Map]    // mirror of items, nil without WithSyncMapBackend
+	bloom           atomic.Pointer[bloomFilter] // keys ever written, nil without WithBloomFilter
+	mu              sync.RWMutex
+	frozen          bool                       // guarded by mu
+	size            int64                      // guarded by mu, total sizeOf of the entries
+	partitionCounts []int                      // guarded by mu, entry count per limited prefix
+	expiries        expiryHeap[T]              // guarded by mu, only used with eager expiration
+	waiters         map[string][]chan struct{} // guarded by mu, WaitGet calls by serialized key
+	walPending      []walOp[T]                 // guarded by mu, changes waiting to be logged
+	onExpire        func(keys []string, value T)
+	onSet           func(keys []string, value T, ttl time.Duration)
+	metrics         func(op string, hit bool, durationNs int64)
+	onOverwrite     func(keys []string)
+	onMiss          func(keys []string, reason error)
+	observer        func(ev Observation[T])
+	keyStats        bool
+	sizeOf          func(T) int64
+	copyOnGet       func(T) T
+	secondaries     []*secondaryIndex[T] // WithIndex indexes, sorted by name
+	refreshLead     time.Duration
+	refresh         func(keys []string) (T, time.Duration, error)
+	refreshing      map[string]struct{} // guarded by mu, serialized keys being reloaded
+	refreshSlots    chan struct{}       // one token per running reload, nil if unlimited
+	encode          func(T) ([]byte, error)
+	decode          func([]byte) (T, error)
+	flights         flightGroup[T]
+	doneOnce        sync.Once
+	doneChan        chan struct{}
+	wake            chan struct{}
+	walWriter       io.Writer
+	walDone         chan struct{}
+	cleanupYield    func() // called by cleanup between two chunks without the lock, for tests
 }
 
 func (c *bmemCache[T]) Set(data T, keys ...string) {
-	c.SetWithExp(data, 0, keys...)
+	var err error
+	if c.metrics != nil {
+		defer c.record("Set", time.Now(), &err)
+	}
+	err = c.trySetWithExpireAt(data, c.valueExpiration(data), keys)
 }
 
-func (c *bmemCache[T]) SetWithExp(data T, duration time.Duration, keys ...string) {
-	var exp time.Time
-	if duration > 0 {
-		exp = time.Now().Add(duration)
+func (c *bmemCache[T]) SetAndKey(data T, keys ...string) string {
+	var err error
+	if c.metrics != nil {
+		defer c.record("SetAndKey", time.Now(), &err)
 	}
-	c.mu.Lock()
-	c.items[serializeKey(keys)] = &cacheEntry[T]{Data: data, Exp: exp}
-	c.mu.Unlock()
+	err = c.trySetWithExpireAt(data, c.valueExpiration(data), keys)
+	return serializeKey(keys)
 }
 
-func (c *bmemCache[T]) Get(keys ...string) (T, error) {
-	c.mu.RLock()
-	entry, ok := c.items[serializeKey(keys)]
-	c.mu.RUnlock()
-	if !ok {
-		return generateEmptyData[T](), ErrNotFound
+func (c *bmemCache[T]) SetWithExpAndKey(data T, duration time.Duration, keys ...string) string {
+	var err error
+	if c.metrics != nil {
+		defer c.record("SetWithExpAndKey", time.Now(), &err)
 	}
-	if entry.isExpired() {
-		c.mu.Lock()
-		entry.flush()
-		c.mu.Unlock()
-		return generateEmptyData[T](), ErrExpired
+	err = c.trySetWithExpireAt(data, c.expiration(duration), keys)
+	return serializeKey(keys)
+}
+
+func (c *bmemCache[T]) TrySet(data T, keys ...string) (err error) {
+	if c.metrics != nil {
+		defer c.record("TrySet", time.Now(), &err)
 	}
-	return entry.Data, nil
+	return c.trySetWithExpireAt(data, c.valueExpiration(data), keys)
 }
 
-func (c *bmemCache[T]) Gets() ([]T, error) {
-	keys := c.Keys()
-	entries := make([]T, 0, len(keys))
-	for _, key := range keys {
-		entry, err := c.Get(key...)
-		if err == nil {
-			entries = append(entries, entry)
-		}
-		// ignoring if cache already expired
+func (c *bmemCache[T]) SetWithExp(data T, duration time.Duration, keys ...string) {
+	var err error
+	if c.metrics != nil {
+		defer c.record("SetWithExp", time.Now(), &err)
 	}
-	if len(entries) == 0 {
-		return nil, ErrEmpty
+	err = c.trySetWithExpireAt(data, c.expiration(duration), keys)
+}
+
+func (c *bmemCache[T]) SetManyWithExp(entries []EntryWithExp[T]) {
+	var err error
+	if c.metrics != nil {
+		defer c.record("SetManyWithExp", time.Now(), &err)
 	}
-	return entries, nil
+	err = c.setMany(entries)
 }
 
-func (c *bmemCache[T]) GetsFromPrefix(keys ...string) ([]T, error) {
-	if len(keys) == 0 {
-		return c.Gets()
+// setMany implements SetManyWithExp without reporting to the metrics callback.
+//
+// Returns:
+//   - The errors of the items that could not be stored, each wrapped in a *CacheError holding
+//     its keys and joined with errors.Join, or nil if the whole batch was stored.
+func (c *bmemCache[T]) setMany(entries []EntryWithExp[T]) error {
+	var errs []error
+	batch := make([]EntryWithExp[T], 0, len(entries))
+	keys := make([]string, 0, len(entries))
+	stored := make([]*cacheEntry[T], 0, len(entries))
+	for _, e := range entries {
+		if err := c.validateKeys(e.Keys); err != nil {
+			errs = append(errs, newCacheError(e.Keys, err))
+			continue
+		}
+		entry, err := c.newEntry(e.Data, c.expiration(e.Duration))
+		if err != nil {
+			errs = append(errs, newCacheError(e.Keys, err))
+			continue
+		}
+		batch = append(batch, e)
+		keys = append(keys, serializeKey(e.Keys))
+		stored = append(stored, entry)
 	}
-	keysFromPrefix := c.KeysFromPrefix(keys...)
-	entries := make([]T, 0, len(keysFromPrefix))
-	for _, key := range keysFromPrefix {
-		entry, err := c.Get(key...)
-		if err == nil {
-			entries = append(entries, entry)
+
+	var removed removals[T]
+	var overwritten []int
+	n := 0
+	c.mu.Lock()
+	for i, key := range keys {
+		existing, ok := c.items[key]
+		overwrite := ok && !existing.isExpired()
+		reclaimed, err := c.storeLocked(key, stored[i])
+		removed.merge(reclaimed)
+		if err != nil {
+			errs = append(errs, newCacheError(batch[i].Keys, err))
+			continue
 		}
-		// ignoring if cache already expired
+		if overwrite {
+			overwritten = append(overwritten, i)
+		}
+		// Compact the batch down to the stored items, reported once the lock is released.
+		batch[n], stored[n] = batch[i], stored[i]
+		n++
 	}
-	if len(entries) == 0 {
-		return nil, ErrNotFound
+	c.mu.Unlock()
+	c.notifyRemovals(removed)
+
+	if c.onOverwrite != nil {
+		for _, i := range overwritten {
+			c.onOverwrite(deserializeKey(keys[i]))
+		}
 	}
-	return entries, nil
+	if c.onSet != nil {
+		for i, e := range batch[:n] {
+			c.onSet(e.Keys, e.Data, setTTL(stored[i].Exp))
+		}
+	}
+	if c.observer != nil {
+		for i, e := range batch[:n] {
+			c.observer(Observation[T]{Op: OpSet, Keys: e.Keys, Value: e.Data, TTL: setTTL(stored[i].Exp)})
+		}
+	}
+	if every := c.settings().cleanupEveryNWrites; n > 0 && every > 0 {
+		if w := atomic.AddInt64(&c.writes, int64(n)); w >= int64(every) && atomic.CompareAndSwapInt64(&c.writes, w, 0) {
+			c.cleanup()
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func (c *bmemCache[T]) Delete(keys ...string) error {
-	key := serializeKey(keys)
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if _, ok := c.items[key]; !ok {
-		return ErrNotFound
+func (c *bmemCache[T]) TrySetWithExp(data T, duration time.Duration, keys ...string) (err error) {
+	if c.metrics != nil {
+		defer c.record("TrySetWithExp", time.Now(), &err)
 	}
-	delete(c.items, key)
-	return nil
+	return c.trySetWithExpireAt(data, c.expiration(duration), keys)
 }
 
-func (c *bmemCache[T]) Keys() [][]string {
-	keys := make([][]string, len(c.items))
-	c.mu.RLock()
-	var i int
-	for k := range c.items {
-		keys[i] = deserializeKey(k)
-		i++
+func (c *bmemCache[T]) SetWithExpireAt(data T, t time.Time, keys ...string) {
+	var err error
+	if c.metrics != nil {
+		defer c.record("SetWithExpireAt", time.Now(), &err)
 	}
-	c.mu.RUnlock()
-	return keys
+	err = c.trySetWithExpireAt(data, c.capExpiration(t), keys)
 }
 
-func (c *bmemCache[T]) KeysFromPrefix(keys ...string) [][]string {
-	if len(keys) == 0 {
-		return c.Keys()
+func (c *bmemCache[T]) trySetWithExpireAt(data T, t time.Time, keys []string) error {
+	if err := c.validateKeys(keys); err != nil {
+		return err
 	}
-	var ret [][]string
+	entry, err := c.newEntry(data, t)
+	if err != nil {
+		return err
+	}
+	if _, err := c.set(serializeKey(keys), entry, nil); err != nil {
+		return err
+	}
+	c.notifySet(keys, data, t)
+	return nil
+}
+
+// notifySet reports the data stored under keys with the given expiration to the WithOnSet hook
+// and the observer. It must be called without holding the lock.
+func (c *bmemCache[T]) notifySet(keys []string, data T, t time.Time) {
+	if c.onSet != nil {
+		c.onSet(keys, data, setTTL(t))
+	}
+	c.observe(Observation[T]{Op: OpSet, Keys: keys, Value: data, TTL: setTTL(t)})
+}
+
+// setTTL returns the TTL reported to the WithOnSet hook for the given expiration time.
+func setTTL(t time.Time) time.Duration {
+	if t.IsZero() {
+		return 0
+	}
+	if ttl := time.Until(t); ttl != 0 {
+		return ttl
+	}
+	return -1 // already expired, not to be mistaken for no expiration
+}
+
+// set stores the entry under the given serialized key, enforcing the maximum number of entries,
+// reporting overwrites of unexpired entries when WithPanicOnOverwrite is configured and
+// triggering the cleanup configured with WithCleanupEveryNWrites.
+//
+// If check is not nil, it is called under the write lock with the entry currently stored under
+// the key, or nil, and the entry is only stored if it returns nil.
+//
+// Returns:
+//   - The entry stored under the key before the call, or nil if there was none.
+//   - The error returned by check, or ErrFull or ErrFrozen if the entry could not be stored.
+func (c *bmemCache[T]) set(key string, entry *cacheEntry[T], check func(existing *cacheEntry[T]) error) (*cacheEntry[T], error) {
+	c.mu.Lock()
+	existing := c.items[key]
+	if check != nil {
+		if err := check(existing); err != nil {
+			c.mu.Unlock()
+			return existing, err
+		}
+	}
+	overwrite := existing != nil && !existing.isExpired()
+	removed, err := c.storeLocked(key, entry)
+	c.mu.Unlock()
+	c.notifyRemovals(removed)
+	if c.onOverwrite != nil && overwrite && err == nil {
+		c.onOverwrite(deserializeKey(key))
+	}
+	if every := c.settings().cleanupEveryNWrites; err == nil && every > 0 {
+		if n := atomic.AddInt64(&c.writes, 1); n >= int64(every) && atomic.CompareAndSwapInt64(&c.writes, n, 0) {
+			c.cleanup()
+		}
+	}
+	return existing, err
+}
+
+// storeLocked stores the entry under the given serialized key, enforcing the maximum number
+// of entries. It must be called with the write lock held.
+//
+// Returns:
+//   - The entries reclaimed or evicted to make room, to be reported once the lock is released.
+//   - ErrFull or ErrFrozen if the entry could not be stored.
+func (c *bmemCache[T]) storeLocked(key string, entry *cacheEntry[T]) (removals[T], error) {
+	var removed removals[T]
+	if c.frozen {
+		return removed, ErrFrozen
+	}
+	if _, ok := c.items[key]; ok {
+		c.putLocked(key, entry)
+		return removed, nil
+	}
+	conf := c.settings()
+	var reclaimed bool
+	// makeRoom frees entries matching match, or any entry if match is nil, until full reports
+	// that there is room. Expired entries are reclaimed before deciding that there is none.
+	makeRoom := func(full func() bool, match func(key string) bool) error {
+		if full() && !reclaimed {
+			removed.expired, reclaimed = c.removeExpiredLocked(0), true
+		}
+		for full() {
+			victimKey, victim, ok := c.evictLocked(conf.overflowPolicy, match)
+			if !ok {
+				return ErrFull
+			}
+			if removed.evicted == nil {
+				removed.evicted = make(map[string]*cacheEntry[T])
+			}
+			removed.evicted[victimKey] = victim
+		}
+		return nil
+	}
+	if i := conf.partition(key); i >= 0 {
+		limit := conf.prefixLimits[i]
+		if err := makeRoom(func() bool { return c.partitionCounts[i] >= limit.maxEntries }, limit.matches); err != nil {
+			return removed, err
+		}
+	}
+	if conf.maxEntries > 0 {
+		if err := makeRoom(func() bool { return len(c.items) >= conf.maxEntries }, nil); err != nil {
+			return removed, err
+		}
+	}
+	c.putLocked(key, entry)
+	return removed, nil
+}
+
+// removeLocked removes the entry stored under the given serialized key and updates the
+// bookkeeping associated with it. Every removal from the storage goes through it, except for
+// the wholesale replacements of Clear and ReplaceAll. It must be called with the write lock
+// held.
+func (c *bmemCache[T]) removeLocked(key string) {
+	if entry, ok := c.items[key]; ok {
+		c.size -= entry.Size
+		c.countLocked(key, -1)
+		c.unindexLocked(key, entry)
+		delete(c.items, key)
+		if index := c.index.Load(); index != nil {
+			index.Delete(key)
+		}
+		c.logLocked(walDel, key, nil)
+	}
+}
+
+// replaceLocked replaces the whole storage with items. Unlike putLocked, it does not update
+// the bookkeeping. It must be called with the write lock held.
+func (c *bmemCache[T]) replaceLocked(items map[string]*cacheEntry[T]) {
+	if bloom := c.bloom.Load(); bloom != nil {
+		// Swapped in first, so that lookups never miss the new entries.
+		bloom = bloom.empty()
+		for key := range items {
+			bloom.add(key)
+		}
+		c.bloom.Store(bloom)
+	}
+	c.items = items
+	c.recountLocked()
+	c.reindexLocked()
+	if c.index.Load() != nil {
+		// A new index is swapped in, so that lock-free lookups see either storage whole.
+		index := new(sync.Map)
+		for key, entry := range items {
+			index.Store(key, entry)
+		}
+		c.index.Store(index)
+	}
+}
+
+// lookup returns the entry stored under the given serialized key. It takes the read lock,
+// unless WithSyncMapBackend is configured or WithBloomFilter rules the key out.
+func (c *bmemCache[T]) lookup(key string) (*cacheEntry[T], bool) {
+	if bloom := c.bloom.Load(); bloom != nil && !bloom.mayContain(key) {
+		return nil, false
+	}
+	if index := c.index.Load(); index != nil {
+		entry, ok := index.Load(key)
+		if !ok {
+			return nil, false
+		}
+		return entry.(*cacheEntry[T]), true
+	}
+	c.mu.RLock()
+	entry, ok := c.items[key]
+	c.mu.RUnlock()
+	return entry, ok
+}
+
+// putLocked stores the entry under the given serialized key and updates the bookkeeping
+// associated with it. Every write to the storage goes through it. It must be called with the
+// write lock held.
+func (c *bmemCache[T]) putLocked(key string, entry *cacheEntry[T]) {
+	if old, ok := c.items[key]; ok {
+		c.size -= old.Size
+		c.unindexLocked(key, old)
+	} else {
+		c.countLocked(key, 1)
+	}
+	c.size += entry.Size
+	c.indexLocked(key, entry)
+	if bloom := c.bloom.Load(); bloom != nil {
+		bloom.add(key)
+	}
+	c.items[key] = entry
+	if index := c.index.Load(); index != nil {
+		index.Store(key, entry)
+	}
+	c.scheduleLocked(key, entry)
+	c.logLocked(walSet, key, entry)
+	c.signalWaitersLocked(key)
+}
+
+func (c *bmemCache[T]) Get(keys ...string) (data T, err error) {
+	if c.metrics != nil {
+		defer c.record("Get", time.Now(), &err)
+	}
+	return c.get(keys)
+}
+
+// get implements Get without reporting to the metrics callback, for internal lookups.
+func (c *bmemCache[T]) get(keys []string) (T, error) {
+	if err := c.validateKeys(keys); err != nil {
+		return generateEmptyData[T](), err
+	}
+	key := serializeKey(keys)
+	entry, ok := c.lookup(key)
+	if !ok {
+		return generateEmptyData[T](), c.miss(keys, ErrNotFound)
+	}
+	if entry.isExpired() {
+		if conf := c.settings(); conf.lazyDeleteOnGet && entry.isRemovable(conf.expiredRetention) {
+			c.removeExpired(key, entry, conf.expiredRetention)
+		}
+		return generateEmptyData[T](), c.miss(keys, ErrExpired)
+	}
+	return c.read(key, keys, entry)
+}
+
+// miss counts a lookup of keys that found no unexpired entry and reports it to WithOnMiss and
+// the observer.
+//
+// Returns:
+//   - The reason of the miss, ErrNotFound or ErrExpired, wrapped in a *CacheError.
+func (c *bmemCache[T]) miss(keys []string, reason error) error {
+	atomic.AddInt64(&c.misses, 1)
+	if c.onMiss != nil {
+		c.onMiss(keys, reason)
+	}
+	c.observe(Observation[T]{Op: OpMiss, Keys: keys, Err: reason})
+	return newCacheError(keys, reason)
+}
+
+// read returns the data of an unexpired entry found by a lookup, counting the hit and
+// triggering its reload by WithRefreshAhead if due.
+func (c *bmemCache[T]) read(key string, keys []string, entry *cacheEntry[T]) (T, error) {
+	if c.dueForRefresh(entry) {
+		c.refreshAhead(key, keys, entry)
+	}
+	return c.hit(keys, entry)
+}
+
+// hit returns the data of an unexpired entry found by a lookup of keys, counting the hit and
+// reporting it to the observer.
+func (c *bmemCache[T]) hit(keys []string, entry *cacheEntry[T]) (T, error) {
+	c.recordHit(entry)
+	data, err := c.value(entry)
+	if err == nil {
+		c.observe(Observation[T]{Op: OpGet, Keys: keys, Value: data, TTL: setTTL(entry.expiresAt())})
+	}
+	return data, err
+}
+
+func (c *bmemCache[T]) GetTimeout(timeout time.Duration, keys ...string) (data T, err error) {
+	if c.metrics != nil {
+		defer c.record("GetTimeout", time.Now(), &err)
+	}
+	if err := c.validateKeys(keys); err != nil {
+		return generateEmptyData[T](), err
+	}
+	if !c.tryRLock(timeout) {
+		return generateEmptyData[T](), fmt.Errorf("bmemcache: read lock not acquired within %v: %w", timeout, context.DeadlineExceeded)
+	}
+	entry, ok := c.items[serializeKey(keys)]
+	c.mu.RUnlock()
+	if !ok {
+		return generateEmptyData[T](), c.miss(keys, ErrNotFound)
+	}
+	if entry.isExpired() {
+		return generateEmptyData[T](), c.miss(keys, ErrExpired)
+	}
+	return c.hit(keys, entry)
+}
+
+// tryRLock acquires the read lock, polling with an increasing backoff until the given timeout
+// has elapsed.
+//
+// Returns:
+//   - true if the read lock was acquired, false otherwise.
+func (c *bmemCache[T]) tryRLock(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for backoff := time.Microsecond; ; backoff *= 2 {
+		if c.mu.TryRLock() {
+			return true
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+		if backoff > time.Millisecond {
+			backoff = time.Millisecond
+		}
+		if backoff > remaining {
+			backoff = remaining
+		}
+		time.Sleep(backoff)
+	}
+}
+
+func (c *bmemCache[T]) GetOrDefault(def T, keys ...string) T {
+	var err error
+	if c.metrics != nil {
+		defer c.record("GetOrDefault", time.Now(), &err)
+	}
+	data, err := c.get(keys)
+	if err != nil {
+		return def
+	}
+	return data
+}
+
+func (c *bmemCache[T]) TryGet(keys ...string) (T, bool) {
+	var err error
+	if c.metrics != nil {
+		defer c.record("TryGet", time.Now(), &err)
+	}
+	data, err := c.get(keys)
+	if err != nil {
+		return generateEmptyData[T](), false
+	}
+	return data, true
+}
+
+func (c *bmemCache[T]) GetFirst(keyGroups ...[]string) (data T, matched []string, err error) {
+	if c.metrics != nil {
+		defer c.record("GetFirst", time.Now(), &err)
+	}
+	serialized := make([]string, len(keyGroups))
+	for i, keys := range keyGroups {
+		if err := c.validateKeys(keys); err != nil {
+			return generateEmptyData[T](), nil, err
+		}
+		serialized[i] = serializeKey(keys)
+	}
+	var entry *cacheEntry[T]
+	c.mu.RLock()
+	for i, key := range serialized {
+		if e, ok := c.items[key]; ok && !e.isExpired() {
+			entry, matched = e, keyGroups[i]
+			break
+		}
+	}
+	c.mu.RUnlock()
+	if entry == nil {
+		atomic.AddInt64(&c.misses, 1)
+		return generateEmptyData[T](), nil, newCacheError(firstKeys(keyGroups), ErrNotFound)
+	}
+	data, err = c.hit(matched, entry)
+	if err != nil {
+		return generateEmptyData[T](), nil, err
+	}
+	return data, matched, nil
+}
+
+func (c *bmemCache[T]) Peek(keys ...string) (data T, err error) {
+	if c.metrics != nil {
+		defer c.record("Peek", time.Now(), &err)
+	}
+	if err := c.validateKeys(keys); err != nil {
+		return generateEmptyData[T](), err
+	}
+	entry, ok := c.lookup(serializeKey(keys))
+	if !ok {
+		atomic.AddInt64(&c.misses, 1)
+		return generateEmptyData[T](), newCacheError(keys, ErrNotFound)
+	}
+	if entry.isExpired() {
+		atomic.AddInt64(&c.misses, 1)
+		return generateEmptyData[T](), newCacheError(keys, ErrExpired)
+	}
+	atomic.AddInt64(&c.hits, 1) // the entry access metadata is left untouched
+	return c.value(entry)
+}
+
+func (c *bmemCache[T]) GetStale(keys ...string) (data T, stale bool, err error) {
+	if c.metrics != nil {
+		defer c.record("GetStale", time.Now(), &err)
+	}
+	if err := c.validateKeys(keys); err != nil {
+		return generateEmptyData[T](), false, err
+	}
+	entry, ok := c.lookup(serializeKey(keys))
+	if !ok {
+		atomic.AddInt64(&c.misses, 1)
+		return generateEmptyData[T](), false, newCacheError(keys, ErrNotFound)
+	}
+	if entry.isExpired() {
+		atomic.AddInt64(&c.misses, 1)
+		stale = true
+	} else {
+		c.recordHit(entry)
+	}
+	data, err = c.value(entry)
+	return data, stale, err
+}
+
+func (c *bmemCache[T]) GetAndRefresh(duration time.Duration, keys ...string) (data T, err error) {
+	if c.metrics != nil {
+		defer c.record("GetAndRefresh", time.Now(), &err)
+	}
+	entry, err := c.modify(keys, func(entry *cacheEntry[T]) (*cacheEntry[T], error) {
+		return entry.withExp(c.expiration(duration)), nil
+	})
+	switch {
+	case err == nil:
+		return c.hit(keys, entry)
+	case errors.Is(err, ErrNotFound):
+		return generateEmptyData[T](), c.miss(keys, ErrNotFound)
+	case errors.Is(err, ErrExpired):
+		return generateEmptyData[T](), c.miss(keys, ErrExpired)
+	default:
+		return generateEmptyData[T](), err
+	}
+}
+
+func (c *bmemCache[T]) GetSet(data T, keys ...string) (old T, existed bool) {
+	if c.metrics != nil {
+		start := time.Now()
+		defer func() { c.metrics("GetSet", existed, time.Since(start).Nanoseconds()) }()
+	}
+	return c.swap(data, c.valueExpiration(data), keys)
+}
+
+func (c *bmemCache[T]) SwapWithExp(data T, duration time.Duration, keys ...string) (old T, existed bool) {
+	if c.metrics != nil {
+		start := time.Now()
+		defer func() { c.metrics("SwapWithExp", existed, time.Since(start).Nanoseconds()) }()
+	}
+	return c.swap(data, c.expiration(duration), keys)
+}
+
+// swap stores data with the given expiration and returns the unexpired data it replaced, if any.
+func (c *bmemCache[T]) swap(data T, exp time.Time, keys []string) (T, bool) {
+	if c.validateKeys(keys) != nil {
+		return generateEmptyData[T](), false
+	}
+	entry, err := c.newEntry(data, exp)
+	if err != nil {
+		return generateEmptyData[T](), false
+	}
+	previous, err := c.set(serializeKey(keys), entry, nil)
+	if err == nil {
+		c.notifySet(keys, data, exp)
+	}
+	if previous == nil || previous.isExpired() {
+		return generateEmptyData[T](), false
+	}
+	old, _ := c.value(previous) // the previous value is best effort if it cannot be decoded
+	return old, true
+}
+
+func (c *bmemCache[T]) SetIfNewer(data T, version int64, keys ...string) (stored bool) {
+	if c.metrics != nil {
+		start := time.Now()
+		defer func() { c.metrics("SetIfNewer", stored, time.Since(start).Nanoseconds()) }()
+	}
+	if c.validateKeys(keys) != nil {
+		return false
+	}
+	entry, err := c.newEntry(data, c.valueExpiration(data))
+	if err != nil {
+		return false
+	}
+	entry.Version = version
+	_, err = c.set(serializeKey(keys), entry, func(existing *cacheEntry[T]) error {
+		if existing != nil && !existing.isExpired() && existing.Version >= version {
+			return errNotNewer
+		}
+		return nil
+	})
+	if err != nil {
+		return false
+	}
+	c.notifySet(keys, data, entry.Exp)
+	return true
+}
+
+func (c *bmemCache[T]) GetOrSet(loader func() (T, error), keys ...string) (data T, err error) {
+	if c.metrics != nil {
+		defer c.record("GetOrSet", time.Now(), &err)
+	}
+	return c.getOrSet(ignoreContext(loader), c.valueExpiration, keys)
+}
+
+func (c *bmemCache[T]) GetOrSetWithExp(loader func() (T, error), duration time.Duration, keys ...string) (data T, err error) {
+	if c.metrics != nil {
+		defer c.record("GetOrSetWithExp", time.Now(), &err)
+	}
+	return c.getOrSet(ignoreContext(loader), func(T) time.Time { return c.expiration(duration) }, keys)
+}
+
+func (c *bmemCache[T]) GetOrSetCtx(loader func(ctx context.Context) (T, error), keys ...string) (data T, err error) {
+	if c.metrics != nil {
+		defer c.record("GetOrSetCtx", time.Now(), &err)
+	}
+	return c.getOrSet(loader, c.valueExpiration, keys)
+}
+
+// ignoreContext adapts a loader of GetOrSet or GetOrSetWithExp to the loaders of getOrSet.
+func ignoreContext[T any](loader func() (T, error)) func(context.Context) (T, error) {
+	return func(context.Context) (T, error) { return loader() }
+}
+
+// getOrSet implements the GetOrSet family, storing loaded data with the expiration returned by
+// exp.
+func (c *bmemCache[T]) getOrSet(loader func(context.Context) (T, error), exp func(T) time.Time, keys []string) (T, error) {
+	data, err := c.get(keys)
+	if !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrExpired) {
+		return data, err
+	}
+	key := serializeKey(keys)
+	timeout := c.settings().loaderTimeout
+	return c.flights.do(key, timeout, func() (T, error) {
+		// Another caller may have stored the key between the lookup above and this load, e.g.
+		// through a load that completed in the meantime.
+		if entry, ok := c.lookup(key); ok && !entry.isExpired() {
+			return c.value(entry)
+		}
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		defer cancel()
+		data, err := loader(ctx)
+		if err != nil {
+			return generateEmptyData[T](), err
+		}
+		// The callers have given up on a load that outlived the timeout, and a later load may
+		// already have stored a newer value, so its result is dropped.
+		if ctx.Err() != nil {
+			return data, nil
+		}
+		_ = c.trySetWithExpireAt(data, exp(data), keys)
+		return data, nil
+	})
+}
+
+func (c *bmemCache[T]) Gets() ([]T, error) {
+	live := c.liveEntries()
+	entries := make([]T, 0, len(live))
+	for key, entry := range live {
+		data, err := c.read(key, deserializeKey(key), entry)
+		if err == nil {
+			entries = append(entries, data)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, ErrEmpty
+	}
+	return entries, nil
+}
+
+func (c *bmemCache[T]) GetsMap() map[string]T {
+	entries := c.liveEntries()
+	values := make(map[string]T, len(entries))
+	for key, entry := range entries {
+		data, err := c.value(entry)
+		if err != nil {
+			continue
+		}
+		values[key] = data
+	}
+	return values
+}
+
+func (c *bmemCache[T]) Filter(pred func(keys []string, value T) bool) []T {
+	var values []T
+	c.filter(pred, func(_ []string, value T) {
+		values = append(values, value)
+	})
+	return values
+}
+
+func (c *bmemCache[T]) FilterKeys(pred func(keys []string, value T) bool) [][]string {
+	var matches [][]string
+	c.filter(pred, func(keys []string, _ T) {
+		matches = append(matches, keys)
+	})
+	return matches
+}
+
+func (c *bmemCache[T]) RangeUnlocked(fn func(keys []string, value T) bool) {
+	c.rangeEntries(func(key string, entry *cacheEntry[T]) bool {
+		data, err := c.value(entry)
+		return err != nil || fn(deserializeKey(key), data)
+	})
+}
+
+func (c *bmemCache[T]) All() iter.Seq2[[]string, T] {
+	return c.RangeUnlocked
+}
+
+func (c *bmemCache[T]) AllKeys() iter.Seq[[]string] {
+	return func(yield func(keys []string) bool) {
+		c.rangeEntries(func(key string, _ *cacheEntry[T]) bool {
+			return yield(deserializeKey(key))
+		})
+	}
+}
+
+// rangeEntries calls fn with each unexpired entry and its serialized key until fn returns
+// false. Only the set of keys is snapshotted under the read lock; each entry is then looked up
+// on its own, and fn runs without holding the lock.
+func (c *bmemCache[T]) rangeEntries(fn func(key string, entry *cacheEntry[T]) bool) {
+	c.mu.RLock()
+	keys := make([]string, 0, len(c.items))
+	for key := range c.items {
+		keys = append(keys, key)
+	}
+	c.mu.RUnlock()
+	for _, key := range keys {
+		entry, ok := c.lookup(key)
+		if !ok || entry.isExpired() {
+			continue
+		}
+		if !fn(key, entry) {
+			return
+		}
+	}
+}
+
+// filter calls match with the key parts and data of each unexpired item for which pred returns
+// true.
+func (c *bmemCache[T]) filter(pred func(keys []string, value T) bool, match func(keys []string, value T)) {
+	for key, entry := range c.liveEntries() {
+		data, err := c.value(entry)
+		if err != nil {
+			continue
+		}
+		if parts := deserializeKey(key); pred(parts, data) {
+			match(parts, data)
+		}
+	}
+}
+
+func (c *bmemCache[T]) GetsFromPrefix(keys ...string) ([]T, error) {
+	entries, err := c.EntriesFromPrefix(keys...)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]T, len(entries))
+	for i, entry := range entries {
+		values[i] = entry.Value
+	}
+	return values, nil
+}
+
+func (c *bmemCache[T]) GetsFromPrefixPage(limit int, cursor string, keys ...string) ([]T, string, error) {
+	after, ok := decodeCursor(cursor)
+	if !ok {
+		return nil, "", ErrInvalidCursor
+	}
+	type match struct {
+		key   string
+		parts []string
+		entry *cacheEntry[T]
+	}
+	var matches []match
+	c.mu.RLock()
+	for k, entry := range c.items {
+		if k <= after || entry.isExpired() {
+			continue
+		}
+		if parts := deserializeKey(k); hasKeyPrefix(parts, keys) {
+			matches = append(matches, match{key: k, parts: parts, entry: entry})
+		}
+	}
+	c.mu.RUnlock()
+	if len(matches) == 0 {
+		if cursor != "" {
+			return nil, "", nil
+		}
+		if c.isEmpty() {
+			return nil, "", ErrEmpty
+		}
+		return nil, "", ErrNotFound
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].key < matches[j].key })
+	var nextCursor string
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+		nextCursor = encodeCursor(matches[limit-1].key)
+	}
+	values := make([]T, 0, len(matches))
+	for _, m := range matches {
+		if data, err := c.read(m.key, m.parts, m.entry); err == nil {
+			values = append(values, data)
+		}
+	}
+	return values, nextCursor, nil
+}
+
+func (c *bmemCache[T]) EntriesFromPrefix(keys ...string) ([]PrefixEntry[T], error) {
+	var entries []PrefixEntry[T]
+	for key, entry := range c.liveEntries() {
+		parts := deserializeKey(key)
+		if !hasKeyPrefix(parts, keys) {
+			continue
+		}
+		if data, err := c.read(key, parts, entry); err == nil {
+			entries = append(entries, PrefixEntry[T]{Keys: parts, Value: data})
+		}
+	}
+	if len(entries) == 0 {
+		if c.isEmpty() {
+			return nil, ErrEmpty
+		}
+		return nil, ErrNotFound
+	}
+	return entries, nil
+}
+
+func (c *bmemCache[T]) Update(fn func(old T) T, keys ...string) (err error) {
+	if c.metrics != nil {
+		defer c.record("Update", time.Now(), &err)
+	}
+	_, err = c.modify(keys, func(entry *cacheEntry[T]) (*cacheEntry[T], error) {
+		old, err := c.value(entry)
+		if err != nil {
+			return nil, err
+		}
+		return c.withData(entry, fn(old))
+	})
+	return err
+}
+
+func (c *bmemCache[T]) TransformAll(fn func(keys []string, old T) T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frozen {
+		return
+	}
+	for key, entry := range c.items {
+		if entry.isExpired() {
+			continue
+		}
+		old, err := c.value(entry)
+		if err != nil {
+			continue
+		}
+		if updated, err := c.withData(entry, fn(deserializeKey(key), old)); err == nil {
+			c.putLocked(key, updated)
+		}
+	}
+}
+
+// withData returns a copy of the entry holding the given data instead, keeping its expiration,
+// creation time, version and access statistics.
+func (c *bmemCache[T]) withData(entry *cacheEntry[T], data T) (*cacheEntry[T], error) {
+	updated, err := c.newEntry(data, entry.Exp)
+	if err != nil {
+		return nil, err
+	}
+	updated.Stats = entry.Stats
+	updated.Version = entry.Version
+	updated.Created = entry.Created
+	return updated, nil
+}
+
+func (c *bmemCache[T]) ExpireAt(t time.Time, keys ...string) (err error) {
+	if c.metrics != nil {
+		defer c.record("ExpireAt", time.Now(), &err)
+	}
+	_, err = c.modify(keys, func(entry *cacheEntry[T]) (*cacheEntry[T], error) {
+		return entry.withExp(c.capExpiration(t)), nil
+	})
+	return err
+}
+
+func (c *bmemCache[T]) UpdateExp(duration time.Duration, keys ...string) (err error) {
+	if c.metrics != nil {
+		defer c.record("UpdateExp", time.Now(), &err)
+	}
+	_, err = c.modify(keys, func(entry *cacheEntry[T]) (*cacheEntry[T], error) {
+		return entry.withExp(c.expiration(duration)), nil
+	})
+	return err
+}
+
+func (c *bmemCache[T]) Delete(keys ...string) (err error) {
+	if c.metrics != nil {
+		defer c.record("Delete", time.Now(), &err)
+	}
+	if err := c.validateKeys(keys); err != nil {
+		return err
+	}
+	key := serializeKey(keys)
+	c.mu.Lock()
+	if c.frozen {
+		c.mu.Unlock()
+		return ErrFrozen
+	}
+	entry, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+		return newCacheError(keys, ErrNotFound)
+	}
+	c.removeLocked(key)
+	c.mu.Unlock()
+	c.observeEntry(OpDelete, keys, entry)
+	return nil
+}
+
+func (c *bmemCache[T]) KeyStrings() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	keys := make([]string, 0, len(c.items))
+	for key := range c.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (c *bmemCache[T]) DeleteMany(keyGroups [][]string) int {
+	var err error
+	if c.metrics != nil {
+		defer c.record("DeleteMany", time.Now(), &err)
+	}
+	serialized := make([]string, len(keyGroups))
+	for i, keys := range keyGroups {
+		if c.validateKeys(keys) == nil {
+			serialized[i] = serializeKey(keys)
+		}
+	}
+	removed := make(map[int]*cacheEntry[T])
+	c.mu.Lock()
+	if c.frozen {
+		c.mu.Unlock()
+		err = ErrFrozen
+		return 0
+	}
+	for i, key := range serialized {
+		if key == "" {
+			continue
+		}
+		if entry, ok := c.items[key]; ok {
+			c.removeLocked(key)
+			removed[i] = entry
+		}
+	}
+	c.mu.Unlock()
+	for i, entry := range removed {
+		c.observeEntry(OpDelete, keyGroups[i], entry)
+	}
+	if len(removed) < len(keyGroups) {
+		err = ErrNotFound
+	}
+	return len(removed)
+}
+
+func (c *bmemCache[T]) Keys() [][]string {
+	c.mu.RLock()
+	keys := make([][]string, len(c.items))
+	var i int
+	for k := range c.items {
+		keys[i] = deserializeKey(k)
+		i++
+	}
+	c.mu.RUnlock()
+	return keys
+}
+
+func (c *bmemCache[T]) KeysSorted() [][]string {
+	keys := c.Keys()
+	sort.Slice(keys, func(i, j int) bool {
+		return lessKey(keys[i], keys[j])
+	})
+	return keys
+}
+
+func (c *bmemCache[T]) KeysFromPrefix(keys ...string) [][]string {
+	if len(keys) == 0 {
+		return c.Keys()
+	}
+	var ret [][]string
+	for _, existingKeyFrags := range c.Keys() {
+		if hasKeyPrefix(existingKeyFrags, keys) {
+			ret = append(ret, existingKeyFrags)
+		}
+	}
+	return ret
+}
+
+func (c *bmemCache[T]) KeysFromSuffix(keys ...string) [][]string {
+	if len(keys) == 0 {
+		return c.Keys()
+	}
+	var ret [][]string
 	for _, existingKeyFrags := range c.Keys() {
-		if len(existingKeyFrags) < len(keys) {
+		if hasKeySuffix(existingKeyFrags, keys) {
+			ret = append(ret, existingKeyFrags)
+		}
+	}
+	return ret
+}
+
+func (c *bmemCache[T]) KeysPage(limit int, cursor string) ([][]string, string) {
+	after, ok := decodeCursor(cursor)
+	if !ok {
+		return nil, ""
+	}
+	var serialized []string
+	c.mu.RLock()
+	for k := range c.items {
+		if k > after {
+			serialized = append(serialized, k)
+		}
+	}
+	c.mu.RUnlock()
+	sort.Strings(serialized)
+	var nextCursor string
+	if limit > 0 && len(serialized) > limit {
+		serialized = serialized[:limit]
+		nextCursor = encodeCursor(serialized[limit-1])
+	}
+	keys := make([][]string, len(serialized))
+	for i, k := range serialized {
+		keys[i] = deserializeKey(k)
+	}
+	return keys, nextCursor
+}
+
+func (c *bmemCache[T]) TouchPrefix(duration time.Duration, keys ...string) int {
+	exp := c.expiration(duration)
+	var n int
+	c.mu.Lock()
+	if c.frozen {
+		c.mu.Unlock()
+		return 0
+	}
+	for key, entry := range c.items {
+		if entry.isExpired() || !hasKeyPrefix(deserializeKey(key), keys) {
+			continue
+		}
+		c.putLocked(key, entry.withExp(exp))
+		n++
+	}
+	c.mu.Unlock()
+	return n
+}
+
+func (c *bmemCache[T]) Export() []KeyValueExp[T] {
+	entries := c.liveEntries()
+	kvs := make([]KeyValueExp[T], 0, len(entries))
+	for key, entry := range entries {
+		data, err := c.value(entry)
+		if err != nil {
+			continue
+		}
+		kvs = append(kvs, KeyValueExp[T]{Keys: deserializeKey(key), Value: data, ExpiresAt: entry.Exp})
+	}
+	return kvs
+}
+
+func (c *bmemCache[T]) Import(kvs []KeyValueExp[T], overwrite bool) {
+	keys := make([]string, 0, len(kvs))
+	entries := make([]*cacheEntry[T], 0, len(kvs))
+	for _, kv := range kvs {
+		if c.validateKeys(kv.Keys) != nil {
 			continue
 		}
-		match := true
-		for i := range keys {
-			if keys[i] != existingKeyFrags[i] {
-				match = false
-				break
+		entry, err := c.newEntry(kv.Value, c.capExpiration(kv.ExpiresAt))
+		if err != nil || entry.isExpired() {
+			continue
+		}
+		keys = append(keys, serializeKey(kv.Keys))
+		entries = append(entries, entry)
+	}
+	var removed removals[T]
+	c.mu.Lock()
+	for i, key := range keys {
+		if existing, ok := c.items[key]; ok && !overwrite && !existing.isExpired() {
+			continue
+		}
+		reclaimed, _ := c.storeLocked(key, entries[i])
+		removed.merge(reclaimed)
+	}
+	c.mu.Unlock()
+	c.notifyRemovals(removed)
+}
+
+func (c *bmemCache[T]) Merge(other BMemCache[T], overwrite bool) {
+	c.Import(other.Export(), overwrite)
+}
+
+func (c *bmemCache[T]) ReplaceAll(kvs []KeyValueExp[T]) {
+	items := make(map[string]*cacheEntry[T], len(kvs))
+	order := make(map[string]int, len(kvs))
+	var size int64
+	conf := c.settings()
+	partitionCounts := make([]int, len(conf.prefixLimits))
+	remove := func(key string) {
+		size -= items[key].Size
+		if i := conf.partition(key); i >= 0 {
+			partitionCounts[i]--
+		}
+		delete(items, key)
+		delete(order, key)
+	}
+	// makeRoom applies the overflow policy to the items stored so far, as storeLocked does to
+	// the stored entries, until full reports that there is room.
+	makeRoom := func(full func() bool, match func(key string) bool) bool {
+		for full() {
+			victimKey, ok := pendingVictim(conf.overflowPolicy, items, order, match)
+			if !ok {
+				return false
 			}
+			remove(victimKey)
 		}
-		if match {
-			ret = append(ret, existingKeyFrags)
+		return true
+	}
+	for seq, kv := range kvs {
+		if c.validateKeys(kv.Keys) != nil {
+			continue
+		}
+		entry, err := c.newEntry(kv.Value, c.capExpiration(kv.ExpiresAt))
+		if err != nil || entry.isExpired() {
+			continue
+		}
+		key := serializeKey(kv.Keys)
+		if _, ok := items[key]; ok {
+			remove(key)
+		} else {
+			if i := conf.partition(key); i >= 0 {
+				limit := conf.prefixLimits[i]
+				if !makeRoom(func() bool { return partitionCounts[i] >= limit.maxEntries }, limit.matches) {
+					continue
+				}
+			}
+			if conf.maxEntries > 0 && !makeRoom(func() bool { return len(items) >= conf.maxEntries }, nil) {
+				continue
+			}
+		}
+		if i := conf.partition(key); i >= 0 {
+			partitionCounts[i]++
 		}
+		size += entry.Size
+		items[key] = entry
+		order[key] = seq
+	}
+	expiries := c.newExpiryHeap(items)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frozen {
+		return
+	}
+	c.replaceLocked(items)
+	c.expiries = expiries
+	c.size = size
+	c.logLocked(walClear, "", nil)
+	for key, entry := range items {
+		c.logLocked(walSet, key, entry)
+	}
+	for key := range c.waiters {
+		if _, ok := items[key]; ok {
+			c.signalWaitersLocked(key)
+		}
+	}
+	if c.eagerExpiration {
+		c.wakeExpirer()
 	}
-	return ret
 }
 
 func (c *bmemCache[T]) IsExist(keys ...string) bool {
-	c.mu.RLock()
-	_, ok := c.items[serializeKey(keys)]
-	c.mu.RUnlock()
+	_, ok := c.lookup(serializeKey(keys))
 	return ok
 }
 
+func (c *bmemCache[T]) IsValid(keys ...string) bool {
+	entry, ok := c.lookup(serializeKey(keys))
+	return ok && !entry.isExpired()
+}
+
 func (c *bmemCache[T]) IsExpired(keys ...string) (bool, error) {
-	c.mu.RLock()
-	entry, ok := c.items[serializeKey(keys)]
-	c.mu.RUnlock()
+	if err := c.validateKeys(keys); err != nil {
+		return false, err
+	}
+	entry, ok := c.lookup(serializeKey(keys))
 	if !ok {
-		return false, ErrNotFound
+		return false, newCacheError(keys, ErrNotFound)
 	}
 	return entry.isExpired(), nil
 }
 
 func (c *bmemCache[T]) TTL(keys ...string) (time.Duration, error) {
-	c.mu.RLock()
-	entry, ok := c.items[serializeKey(keys)]
-	c.mu.RUnlock()
+	if err := c.validateKeys(keys); err != nil {
+		return 0, err
+	}
+	entry, ok := c.lookup(serializeKey(keys))
 	if !ok {
-		return 0, ErrNotFound
+		return 0, newCacheError(keys, ErrNotFound)
 	}
-	if entry.Exp.IsZero() {
+	exp := entry.expiresAt()
+	if exp.IsZero() {
 		return -1, nil // No expiration
 	}
-	remaining := time.Until(entry.Exp)
+	remaining := time.Until(exp)
 	if remaining <= 0 {
-		return 0, ErrExpired
+		return 0, newCacheError(keys, ErrExpired)
 	}
 	return remaining, nil
 }
 
+func (c *bmemCache[T]) ExpiresAt(keys ...string) (time.Time, bool, error) {
+	if err := c.validateKeys(keys); err != nil {
+		return time.Time{}, false, err
+	}
+	entry, ok := c.lookup(serializeKey(keys))
+	if !ok {
+		return time.Time{}, false, newCacheError(keys, ErrNotFound)
+	}
+	if entry.isExpired() {
+		return time.Time{}, false, newCacheError(keys, ErrExpired)
+	}
+	exp := entry.expiresAt()
+	return exp, !exp.IsZero(), nil
+}
+
+func (c *bmemCache[T]) Age(keys ...string) (time.Duration, error) {
+	if err := c.validateKeys(keys); err != nil {
+		return 0, err
+	}
+	entry, ok := c.lookup(serializeKey(keys))
+	if !ok {
+		return 0, newCacheError(keys, ErrNotFound)
+	}
+	if entry.isExpired() {
+		return 0, newCacheError(keys, ErrExpired)
+	}
+	return time.Since(entry.Created), nil
+}
+
+func (c *bmemCache[T]) Remaining(keys ...string) time.Duration {
+	ttl, _ := c.TTL(keys...) // TTL reports 0 along with every error
+	return ttl
+}
+
+func (c *bmemCache[T]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.items)
+}
+
+func (c *bmemCache[T]) ExpiredCount() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var n int
+	for _, entry := range c.items {
+		if entry.isExpired() {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *bmemCache[T]) TTLHistogram(buckets []time.Duration) map[time.Duration]int {
+	bounds := append([]time.Duration(nil), buckets...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	hist := make(map[time.Duration]int, len(bounds)+2)
+	hist[-1], hist[math.MaxInt64] = 0, 0
+	for _, b := range bounds {
+		hist[b] = 0
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := time.Now()
+	for _, entry := range c.items {
+		exp := entry.expiresAt()
+		if exp.IsZero() {
+			hist[-1]++
+			continue
+		}
+		remaining := exp.Sub(now)
+		if remaining <= 0 {
+			continue
+		}
+		if i := sort.Search(len(bounds), func(i int) bool { return bounds[i] >= remaining }); i < len(bounds) {
+			hist[bounds[i]]++
+		} else {
+			hist[math.MaxInt64]++
+		}
+	}
+	return hist
+}
+
+func (c *bmemCache[T]) Size() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.size
+}
+
+func (c *bmemCache[T]) Stats() Stats {
+	return Stats{
+		Hits:        atomic.LoadInt64(&c.hits),
+		Misses:      atomic.LoadInt64(&c.misses),
+		Expirations: atomic.LoadInt64(&c.expirations),
+		Evictions:   atomic.LoadInt64(&c.evictions),
+	}
+}
+
+func (c *bmemCache[T]) ResetStats() Stats {
+	return Stats{
+		Hits:        atomic.SwapInt64(&c.hits, 0),
+		Misses:      atomic.SwapInt64(&c.misses, 0),
+		Expirations: atomic.SwapInt64(&c.expirations, 0),
+		Evictions:   atomic.SwapInt64(&c.evictions, 0),
+	}
+}
+
+func (c *bmemCache[T]) KeyStats(keys ...string) (KeyStat, error) {
+	if err := c.validateKeys(keys); err != nil {
+		return KeyStat{}, err
+	}
+	entry, ok := c.lookup(serializeKey(keys))
+	if !ok {
+		return KeyStat{}, newCacheError(keys, ErrNotFound)
+	}
+	if entry.isExpired() {
+		return KeyStat{}, newCacheError(keys, ErrExpired)
+	}
+	stat := KeyStat{TTL: -1}
+	if exp := entry.expiresAt(); !exp.IsZero() {
+		stat.TTL = time.Until(exp)
+	}
+	if entry.Stats != nil {
+		stat.Hits = atomic.LoadInt64(&entry.Stats.hits)
+		if lastAccess := atomic.LoadInt64(&entry.Stats.lastAccess); lastAccess != 0 {
+			stat.LastAccess = time.Unix(0, lastAccess)
+		}
+	}
+	stat.Created = entry.Created
+	return stat, nil
+}
+
+// stalledSweeps is the number of cleanup intervals without a completed sweep after which
+// Healthy reports auto-cleanup as stalled.
+const stalledSweeps = 3
+
+func (c *bmemCache[T]) Healthy() (bool, error) {
+	if c.cleanupInterval <= 0 {
+		return false, ErrCleanupDisabled
+	}
+	select {
+	case <-c.doneChan:
+		return false, ErrClosed
+	default:
+	}
+	if since := time.Since(time.Unix(0, c.lastSweep.Load())); since > stalledSweeps*c.cleanupInterval {
+		return false, fmt.Errorf("bmemcache: no cleanup sweep for %v: %w", since, ErrCleanupStalled)
+	}
+	return true, nil
+}
+
+func (c *bmemCache[T]) Name() string {
+	return c.name
+}
+
+func (c *bmemCache[T]) String() string {
+	return fmt.Sprintf("bmemcache(name=%q, entries=%d, autoCleanup=%t)", c.name, c.Len(), c.cleanupInterval > 0)
+}
+
 func (c *bmemCache[T]) Clear() {
 	c.mu.Lock()
-	c.items = make(map[string]*cacheEntry[T])
+	cleared := !c.frozen
+	if cleared {
+		c.replaceLocked(make(map[string]*cacheEntry[T]))
+		c.expiries = nil
+		c.size = 0
+		c.logLocked(walClear, "", nil)
+	}
+	c.mu.Unlock()
+	if cleared {
+		c.observe(Observation[T]{Op: OpClear})
+	}
+}
+
+func (c *bmemCache[T]) Drain() []EntryWithExp[T] {
+	c.mu.Lock()
+	if c.frozen {
+		c.mu.Unlock()
+		return nil
+	}
+	items := c.items
+	c.replaceLocked(make(map[string]*cacheEntry[T]))
+	c.expiries = nil
+	c.size = 0
+	c.logLocked(walClear, "", nil)
+	c.mu.Unlock()
+	c.observe(Observation[T]{Op: OpClear})
+
+	entries := make([]EntryWithExp[T], 0, len(items))
+	for key, entry := range items {
+		var duration time.Duration
+		if exp := entry.expiresAt(); !exp.IsZero() {
+			if duration = time.Until(exp); duration <= 0 {
+				continue
+			}
+		}
+		data, err := c.value(entry)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, EntryWithExp[T]{Keys: deserializeKey(key), Data: data, Duration: duration})
+	}
+	return entries
+}
+
+func (c *bmemCache[T]) Compact() {
+	var expired map[string]*cacheEntry[T]
+	c.mu.Lock()
+	if !c.frozen {
+		expired = c.removeExpiredLocked(c.settings().expiredRetention)
+	}
+	items := make(map[string]*cacheEntry[T], len(c.items))
+	for key, entry := range c.items {
+		items[key] = entry
+	}
+	c.items = items
+	c.compactExpiriesLocked()
+	c.mu.Unlock()
+	c.notifyExpireAll(expired)
+}
+
+func (c *bmemCache[T]) Reset(options ...Option) {
+	o := &option{}
+	for _, v := range options {
+		v.Apply(o)
+	}
+	conf := newSettings[T](o)
+	c.checkSettings(conf)
+	c.mu.Lock()
+	if !c.frozen {
+		c.conf.Store(conf)
+		c.replaceLocked(make(map[string]*cacheEntry[T], o.InitialCapacity))
+		c.expiries = nil
+		c.size = 0
+		c.logLocked(walClear, "", nil)
+		// The retention of expired entries may have changed.
+		c.wakeExpirer()
+	}
+	c.mu.Unlock()
+}
+
+func (c *bmemCache[T]) Freeze() {
+	c.mu.Lock()
+	c.frozen = true
 	c.mu.Unlock()
+	// Stopping the background goroutines and flushing the write-ahead log is all Close does.
+	c.Close()
+}
+
+// liveEntries returns a snapshot of the unexpired entries, keyed by their serialized key.
+// Entries are immutable, so they can be read after the lock is released.
+func (c *bmemCache[T]) liveEntries() map[string]*cacheEntry[T] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entries := make(map[string]*cacheEntry[T], len(c.items))
+	for key, entry := range c.items {
+		if !entry.isExpired() {
+			entries[key] = entry
+		}
+	}
+	return entries
+}
+
+// newEntry creates an entry holding the given data, encoding it if WithValueCodec is configured.
+func (c *bmemCache[T]) newEntry(data T, exp time.Time) (*cacheEntry[T], error) {
+	conf := c.settings()
+	entry := &cacheEntry[T]{Exp: exp, Created: time.Now()}
+	if c.sizeOf != nil {
+		entry.Size = c.sizeOf(data)
+		if conf.maxValueSize > 0 && entry.Size > conf.maxValueSize {
+			return nil, ErrTooLarge
+		}
+	}
+	entry.Fields = c.extractFields(data)
+	if c.encode == nil {
+		entry.Data = data
+	} else {
+		raw, err := c.encode(data)
+		if err != nil {
+			return nil, err
+		}
+		entry.Raw = raw
+	}
+	if c.keyStats || conf.overflowPolicy.tracksAccess() {
+		entry.Stats = &entryStats{}
+	}
+	if conf.timeToIdle > 0 {
+		entry.Idle = new(atomic.Int64)
+		entry.Idle.Store(entry.Created.Add(conf.timeToIdle).UnixNano())
+	}
+	return entry, nil
+}
+
+// recordHit counts a lookup that found the given entry unexpired.
+func (c *bmemCache[T]) recordHit(entry *cacheEntry[T]) {
+	atomic.AddInt64(&c.hits, 1)
+	if entry.Stats != nil {
+		entry.Stats.recordAccess()
+	}
+	if entry.Idle != nil {
+		entry.Idle.Store(time.Now().Add(c.settings().timeToIdle).UnixNano())
+	}
+}
+
+// value returns the data to hand out to callers for the given entry, decoding it if
+// WithValueCodec is configured or cloning it if WithCopyOnGet is configured.
+func (c *bmemCache[T]) value(entry *cacheEntry[T]) (T, error) {
+	if c.decode != nil {
+		// Decoding always produces a fresh value, so there is no need to copy it.
+		return c.decode(entry.Raw)
+	}
+	if c.copyOnGet != nil {
+		return c.copyOnGet(entry.Data), nil
+	}
+	return entry.Data, nil
+}
+
+// expiration returns the absolute expiration time for an entry stored now with the given
+// duration, or the zero time if the entry should not expire. Positive durations are raised to
+// WithMinTTL, and the result is capped by WithMaxTTL.
+func (c *bmemCache[T]) expiration(duration time.Duration) time.Time {
+	if duration <= 0 {
+		return c.capExpiration(time.Time{})
+	}
+	if minTTL := c.settings().minTTL; duration < minTTL {
+		duration = minTTL
+	}
+	return c.capExpiration(time.Now().Add(duration))
+}
+
+// valueExpiration returns the expiration time data is stored with by Set, as derived by
+// WithValueTTLFunc. Unlike with SetWithExp, a negative duration stores the data already expired.
+func (c *bmemCache[T]) valueExpiration(data T) time.Time {
+	valueTTLFunc := c.settings().valueTTLFunc
+	if valueTTLFunc == nil {
+		return c.expiration(0)
+	}
+	duration := valueTTLFunc(data)
+	if duration < 0 {
+		return time.Now().Add(duration)
+	}
+	return c.expiration(duration)
+}
+
+// record reports an operation started at the given time to the metrics callback. It is
+// deferred by the instrumented methods, which set err before returning.
+func (c *bmemCache[T]) record(op string, start time.Time, err *error) {
+	c.metrics(op, *err == nil, time.Since(start).Nanoseconds())
+}
+
+// capExpiration clamps an absolute expiration time, where the zero time means no expiration,
+// to the maximum TTL configured with WithMaxTTL.
+func (c *bmemCache[T]) capExpiration(exp time.Time) time.Time {
+	maxTTL := c.settings().maxTTL
+	if maxTTL <= 0 {
+		return exp
+	}
+	if limit := time.Now().Add(maxTTL); exp.IsZero() || exp.After(limit) {
+		return limit
+	}
+	return exp
+}
+
+// validateKeys checks the key parts against the configured key limits.
+func (c *bmemCache[T]) validateKeys(keys []string) error {
+	conf := c.settings()
+	if len(keys) == 0 && conf.disallowEmptyKeys {
+		return ErrEmptyKey
+	}
+	if conf.maxKeyParts > 0 && len(keys) > conf.maxKeyParts {
+		return ErrTooManyKeyParts
+	}
+	if conf.keyValidator != nil {
+		return conf.keyValidator(keys)
+	}
+	return nil
+}
+
+// isEmpty reports whether the cache holds no unexpired entries.
+func (c *bmemCache[T]) isEmpty() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, entry := range c.items {
+		if !entry.isExpired() {
+			return false
+		}
+	}
+	return true
 }
 
 func (c *bmemCache[T]) Close() {
 	c.doneOnce.Do(func() {
 		if c.doneChan != nil {
-			c.doneChan <- struct{}{}
 			close(c.doneChan)
 		}
 	})
+	if c.walDone != nil {
+		// Wait for the final flush of the write-ahead log.
+		<-c.walDone
+	}
 }
 
 func (c *bmemCache[T]) autoCleanup(interval time.Duration) {
@@ -354,15 +2819,137 @@ func (c *bmemCache[T]) autoCleanup(interval time.Duration) {
 	for {
 		select {
 		case <-ticker.C:
-			c.mu.Lock()
-			for key, entry := range c.items {
-				if entry.isExpired() {
-					delete(c.items, key)
-				}
-			}
-			c.mu.Unlock()
+			c.cleanup()
+			c.lastSweep.Store(time.Now().UnixNano())
 		case <-c.doneChan:
 			return
 		}
 	}
 }
+
+// cleanupChunkSize is the number of entries cleanup examines per acquisition of the write lock.
+const cleanupChunkSize = 1024
+
+// cleanup removes every expired entry and reports each of them to the expiration callback.
+//
+// The write lock is released after every cleanupChunkSize entries, so that a sweep of a large
+// cache does not stall the other operations for its whole duration. Resuming the iteration
+// afterwards is safe: range tolerates entries added or removed in the meantime, and an entry
+// is only removed if it is still the one stored under its key, which also covers the storage
+// being replaced by Clear or ReplaceAll.
+func (c *bmemCache[T]) cleanup() {
+	expired := make(map[string]*cacheEntry[T])
+	c.mu.Lock()
+	retention := c.settings().expiredRetention
+	var n int
+	for key, entry := range c.items {
+		if c.frozen {
+			break
+		}
+		if entry.isRemovable(retention) && c.items[key] == entry {
+			c.removeLocked(key)
+			expired[key] = entry
+		}
+		if n++; n%cleanupChunkSize == 0 {
+			c.mu.Unlock()
+			if c.cleanupYield != nil {
+				c.cleanupYield()
+			}
+			c.mu.Lock()
+		}
+	}
+	c.mu.Unlock()
+	c.notifyExpireAll(expired)
+}
+
+// modify replaces the unexpired entry stored under keys with the entry returned by fn, which
+// runs with the write lock held. An expired entry is removed instead, unless it is retained
+// by WithExpiredRetention, and reported once the lock has been released.
+//
+// Entries are never mutated in place since readers access them after releasing the lock, so
+// fn must return a new entry rather than modifying the one it receives.
+//
+// Returns:
+//   - The entry that was replaced.
+//   - ErrNotFound or ErrExpired wrapped in a *CacheError if there is no unexpired entry,
+//     ErrFrozen if the cache is frozen, an error if the keys are invalid, or the error
+//     returned by fn.
+func (c *bmemCache[T]) modify(keys []string, fn func(entry *cacheEntry[T]) (*cacheEntry[T], error)) (*cacheEntry[T], error) {
+	if err := c.validateKeys(keys); err != nil {
+		return nil, err
+	}
+	key := serializeKey(keys)
+	c.mu.Lock()
+	if c.frozen {
+		c.mu.Unlock()
+		return nil, ErrFrozen
+	}
+	entry, ok := c.items[key]
+	if ok && entry.isExpired() {
+		removed := entry.isRemovable(c.settings().expiredRetention)
+		if removed {
+			c.removeLocked(key)
+		}
+		c.mu.Unlock()
+		if removed {
+			c.notifyExpire(key, entry)
+		}
+		return nil, newCacheError(keys, ErrExpired)
+	}
+	defer c.mu.Unlock()
+	if !ok {
+		return nil, newCacheError(keys, ErrNotFound)
+	}
+	updated, err := fn(entry)
+	if err != nil {
+		return nil, err
+	}
+	c.putLocked(key, updated)
+	return entry, nil
+}
+
+// removeExpired removes the given expired entry, unless another goroutine has replaced or
+// removed it since it was read. Whether it is still removable is checked again under the
+// write lock, since a concurrent read may have pushed back its WithTimeToIdle deadline.
+func (c *bmemCache[T]) removeExpired(key string, entry *cacheEntry[T], retention time.Duration) {
+	c.mu.Lock()
+	removed := !c.frozen && c.items[key] == entry && entry.isRemovable(retention)
+	if removed {
+		c.removeLocked(key)
+	}
+	c.mu.Unlock()
+	if removed {
+		c.notifyExpire(key, entry)
+	}
+}
+
+// removeExpiredLocked removes every entry expired for longer than the given retention and
+// returns them keyed by their serialized key. It must be called with the write lock held.
+func (c *bmemCache[T]) removeExpiredLocked(retention time.Duration) map[string]*cacheEntry[T] {
+	expired := make(map[string]*cacheEntry[T])
+	for key, entry := range c.items {
+		if entry.isRemovable(retention) {
+			expired[key] = entry
+			c.removeLocked(key)
+		}
+	}
+	return expired
+}
+
+// notifyExpire records an entry that has been removed because its TTL lapsed and invokes the
+// expiration callback, if any. It must be called without holding the lock.
+func (c *bmemCache[T]) notifyExpire(key string, entry *cacheEntry[T]) {
+	atomic.AddInt64(&c.expirations, 1)
+	if c.onExpire != nil {
+		data, _ := c.value(entry) // the last-known value is best effort if it cannot be decoded
+		c.onExpire(deserializeKey(key), data)
+	}
+	c.observeEntry(OpExpire, deserializeKey(key), entry)
+}
+
+// notifyExpireAll calls notifyExpire for each of the given entries.
+func (c *bmemCache[T]) notifyExpireAll(entries map[string]*cacheEntry[T]) {
+	for key, entry := range entries {
+		c.notifyExpire(key, entry)
+	}
+}
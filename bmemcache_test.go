@@ -1,6 +1,19 @@
 package bmemcache
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -26,7 +39,7 @@ func TestGetNonExistentKey(t *testing.T) {
 	defer cache.Close()
 
 	_, err := cache.Get("nonexistent")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("expected ErrNotFound, got: %v", err)
 	}
 }
@@ -113,8 +126,8 @@ func TestGet(t *testing.T) {
 	}
 
 	_, err = cache.GetsFromPrefix("a", "b", "c", "d")
-	if err == nil {
-		t.Errorf("expected error, got: %v", err)
+	if err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got: %v", err)
 	}
 
 	check = map[string]bool{"three": false}
@@ -196,8 +209,8 @@ func TestGet(t *testing.T) {
 	}
 
 	data, err = cache2.Gets()
-	if err == nil {
-		t.Errorf("expected error, got: %v", err)
+	if err != ErrEmpty {
+		t.Errorf("expected ErrEmpty, got: %v", err)
 	}
 }
 
@@ -218,11 +231,29 @@ func TestSetWithExp(t *testing.T) {
 	// Wait for expiration
 	time.Sleep(150 * time.Millisecond)
 	_, err = cache.Get("key")
-	if err != ErrExpired {
+	if !errors.Is(err, ErrExpired) {
 		t.Errorf("expected ErrExpired after expiration, got: %v", err)
 	}
 }
 
+// TestGetRemovesExpiredEntry verifies that Get reclaims an expired entry instead of leaving it in the cache.
+func TestGetRemovesExpiredEntry(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	cache.SetWithExp("temp", 10*time.Millisecond, "key")
+	time.Sleep(20 * time.Millisecond)
+	if _, err := cache.Get("key"); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got: %v", err)
+	}
+	if keys := cache.Keys(); len(keys) != 0 {
+		t.Errorf("expected expired key to be removed, got keys: %v", keys)
+	}
+	if _, err := cache.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound once removed, got: %v", err)
+	}
+}
+
 // TestTTL verifies that TTL returns the correct duration for expiring values and -1 for non-expiring values.
 func TestTTL(t *testing.T) {
 	cache := New[string]()
@@ -251,13 +282,13 @@ func TestTTL(t *testing.T) {
 	// Wait until it expires and check that TTL returns ErrExpired.
 	time.Sleep(250 * time.Millisecond)
 	_, err = cache.TTL("key2")
-	if err != ErrExpired {
+	if !errors.Is(err, ErrExpired) {
 		t.Errorf("expected ErrExpired after expiration, got: %v", err)
 	}
 
 	// Not found
 	_, err = cache.TTL("invalid")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("expected ErrNotFound, got: %v", err)
 	}
 }
@@ -276,6 +307,29 @@ func TestIsExist(t *testing.T) {
 	}
 }
 
+// TestIsValid verifies that IsValid treats expired entries as non-existent while IsExist does not.
+func TestIsValid(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	if cache.IsValid("key") {
+		t.Error("expected missing key to be invalid")
+	}
+	cache.Set("value", "key")
+	if !cache.IsValid("key") {
+		t.Error("expected key to be valid")
+	}
+
+	cache.SetWithExp("temp", 10*time.Millisecond, "expiring")
+	time.Sleep(20 * time.Millisecond)
+	if !cache.IsExist("expiring") {
+		t.Error("expected expired but uncleaned key to exist")
+	}
+	if cache.IsValid("expiring") {
+		t.Error("expected expired key to be invalid")
+	}
+}
+
 // TestIsExpired verifies that IsExpired correctly identifies expired and non-expired cache entries.
 func TestIsExpired(t *testing.T) {
 	cache := New[string]()
@@ -283,7 +337,7 @@ func TestIsExpired(t *testing.T) {
 
 	// Case 1: Non-existent key should return an error
 	_, err := cache.IsExpired("nonexistent")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("expected ErrNotFound for non-existent key, got: %v", err)
 	}
 
@@ -315,7 +369,7 @@ func TestDelete(t *testing.T) {
 	defer cache.Close()
 
 	err := cache.Delete("key")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("expected ErrNotFound for deleting non-existent key, got: %v", err)
 	}
 	cache.Set("value", "key")
@@ -328,6 +382,58 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+// TestDrain checks that Drain empties the cache and returns its unexpired items.
+func TestDrain(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	cache.Set("permanent", "a")
+	cache.SetWithExp("temporary", time.Hour, "b", "c")
+	cache.SetWithExp("expired", time.Nanosecond, "d")
+	time.Sleep(time.Millisecond)
+
+	entries := cache.Drain()
+	if n := cache.Len(); n != 0 {
+		t.Errorf("expected an empty cache, got: %d items", n)
+	}
+	sort.Slice(entries, func(i, j int) bool { return lessKey(entries[i].Keys, entries[j].Keys) })
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 drained items, got: %d", len(entries))
+	}
+	if e := entries[0]; !reflect.DeepEqual(e.Keys, []string{"a"}) || e.Data != "permanent" || e.Duration != 0 {
+		t.Errorf("expected the permanent item, got: %+v", e)
+	}
+	if e := entries[1]; !reflect.DeepEqual(e.Keys, []string{"b", "c"}) || e.Data != "temporary" || e.Duration <= 0 || e.Duration > time.Hour {
+		t.Errorf("expected the temporary item, got: %+v", e)
+	}
+	if entries := cache.Drain(); len(entries) != 0 {
+		t.Errorf("expected nothing left to drain, got: %+v", entries)
+	}
+}
+
+// TestDeleteMany checks that only the items found are removed and counted.
+func TestDeleteMany(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	cache.Set("value", "a")
+	cache.Set("value", "b", "1")
+	cache.Set("value", "c")
+	n := cache.DeleteMany([][]string{{"a"}, {"b", "1"}, {"missing"}, {"b"}, {"a"}})
+	if n != 2 {
+		t.Errorf("expected 2 removed items, got: %d", n)
+	}
+	if cache.IsExist("a") || cache.IsExist("b", "1") {
+		t.Error("expected keys to be deleted")
+	}
+	if !cache.IsExist("c") {
+		t.Error("expected other keys to be kept")
+	}
+	if n := cache.DeleteMany(nil); n != 0 {
+		t.Errorf("expected 0 removed items, got: %d", n)
+	}
+}
+
 // TestClear verifies that the Clear method removes all entries.
 func TestClear(t *testing.T) {
 	cache := New[string]()
@@ -341,6 +447,47 @@ func TestClear(t *testing.T) {
 	}
 }
 
+// TestClearDuringIteration verifies that snapshot reads racing with Clear see either every
+// item or none of them.
+func TestClearDuringIteration(t *testing.T) {
+	const n = 100
+	cache := New[int]()
+	defer cache.Close()
+
+	kvs := make([]KeyValueExp[int], n)
+	for i := range kvs {
+		kvs[i] = KeyValueExp[int]{Keys: []string{"group", strconv.Itoa(i)}, Value: i}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			cache.Clear()
+			runtime.Gosched()
+			cache.ReplaceAll(kvs)
+			runtime.Gosched()
+		}
+	}()
+	cache.ReplaceAll(kvs)
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if keys := cache.Keys(); len(keys) != 0 && len(keys) != n {
+			t.Fatalf("expected Keys to return 0 or %d keys, got: %d", n, len(keys))
+		}
+		if values, _ := cache.Gets(); len(values) != 0 && len(values) != n {
+			t.Fatalf("expected Gets to return 0 or %d values, got: %d", n, len(values))
+		}
+		if entries, _ := cache.EntriesFromPrefix("group"); len(entries) != 0 && len(entries) != n {
+			t.Fatalf("expected EntriesFromPrefix to return 0 or %d entries, got: %d", n, len(entries))
+		}
+		runtime.Gosched()
+	}
+}
+
 // TestAutoCleanup verifies that autoCleanup removes expired entries automatically.
 func TestAutoCleanup(t *testing.T) {
 	// Enable auto-cleanup with a short interval.
@@ -363,6 +510,36 @@ func TestClose(t *testing.T) {
 	cache.Close()
 }
 
+// TestHealthy verifies that Healthy reports a running auto-cleanup as healthy, and a disabled,
+// stalled or closed one as unhealthy.
+func TestHealthy(t *testing.T) {
+	cache := New[string](WithAutoCleanUp(10 * time.Millisecond))
+	if ok, err := cache.Healthy(); !ok || err != nil {
+		t.Errorf("expected a new cache to be healthy, got: %v, %v", ok, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if ok, err := cache.Healthy(); !ok || err != nil {
+		t.Errorf("expected a running cache to be healthy, got: %v, %v", ok, err)
+	}
+	cache.Close()
+	if ok, err := cache.Healthy(); ok || !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed after Close, got: %v, %v", ok, err)
+	}
+
+	stalled := New[string](WithAutoCleanUp(time.Hour)).(*bmemCache[string])
+	defer stalled.Close()
+	stalled.lastSweep.Store(time.Now().Add(-4 * time.Hour).UnixNano())
+	if ok, err := stalled.Healthy(); ok || !errors.Is(err, ErrCleanupStalled) {
+		t.Errorf("expected ErrCleanupStalled, got: %v, %v", ok, err)
+	}
+
+	disabled := New[string]()
+	defer disabled.Close()
+	if ok, err := disabled.Healthy(); ok || !errors.Is(err, ErrCleanupDisabled) {
+		t.Errorf("expected ErrCleanupDisabled, got: %v, %v", ok, err)
+	}
+}
+
 func TestGenerateCacheKey(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -437,3 +614,3509 @@ func TestWithAutoCleanUpApply(t *testing.T) {
 		})
 	}
 }
+
+// TestOnExpire verifies that the expiration callback fires for TTL expiry but not for Delete or Clear.
+func TestOnExpire(t *testing.T) {
+	var mu sync.Mutex
+	var expired []string
+	cache := New[string](
+		WithAutoCleanUp(50*time.Millisecond),
+		WithOnExpire(func(keys []string, value string) {
+			mu.Lock()
+			expired = append(expired, value)
+			mu.Unlock()
+			if !reflect.DeepEqual(keys, []string{"key", value}) {
+				t.Errorf("unexpected keys for %s: %v", value, keys)
+			}
+		}),
+	)
+	defer cache.Close()
+
+	cache.SetWithExp("lazy", 30*time.Millisecond, "key", "lazy")
+	cache.SetWithExp("background", 30*time.Millisecond, "key", "background")
+	cache.SetWithExp("deleted", 30*time.Millisecond, "key", "deleted")
+	cache.Set("cleared", "key", "cleared")
+	if err := cache.Delete("key", "deleted"); err != nil {
+		t.Fatalf("unexpected error on delete: %v", err)
+	}
+
+	// Lazy expiration through Get.
+	time.Sleep(40 * time.Millisecond)
+	if _, err := cache.Get("key", "lazy"); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got: %v", err)
+	}
+	// Background expiration through autoCleanup.
+	time.Sleep(60 * time.Millisecond)
+	cache.Clear()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(expired) != 2 || expired[0] != "lazy" || expired[1] != "background" {
+		t.Errorf("expected [lazy background] to expire, got: %v", expired)
+	}
+}
+
+// TestOnExpireTypeMismatch verifies that New panics when the callback type does not match the cache type.
+func TestOnExpireTypeMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for mismatched callback type")
+		}
+	}()
+	New[string](WithOnExpire(func(keys []string, value int) {}))
+}
+
+// TestUpdate verifies that Update modifies the cached value in place and preserves its expiration.
+func TestUpdate(t *testing.T) {
+	cache := New[[]int]()
+	defer cache.Close()
+
+	err := cache.Update(func(old []int) []int { return append(old, 1) }, "missing")
+	var cacheErr *CacheError
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &cacheErr) || !reflect.DeepEqual(cacheErr.Keys, []string{"missing"}) {
+		t.Errorf("expected ErrNotFound wrapped in a CacheError, got: %v", err)
+	}
+
+	cache.SetWithExp([]int{}, time.Minute, "key")
+	ttlBefore, _ := cache.TTL("key")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := cache.Update(func(old []int) []int { return append(old, i) }, "key"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	value, err := cache.Get("key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(value) != 100 {
+		t.Errorf("expected 100 elements after concurrent updates, got: %d", len(value))
+	}
+	ttlAfter, _ := cache.TTL("key")
+	if ttlAfter <= 0 || ttlAfter > ttlBefore {
+		t.Errorf("expected expiration to be preserved, got TTL %v (before %v)", ttlAfter, ttlBefore)
+	}
+
+	cache.SetWithExp([]int{}, 10*time.Millisecond, "expiring")
+	time.Sleep(20 * time.Millisecond)
+	err = cache.Update(func(old []int) []int { return append(old, 1) }, "expiring")
+	if !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got: %v", err)
+	}
+}
+
+// TestTransformAll verifies that TransformAll rewrites every unexpired value in place while
+// preserving the expirations.
+func TestTransformAll(t *testing.T) {
+	cache := New[string](WithLazyDeleteOnGet(false))
+	defer cache.Close()
+
+	cache.Set("a", "one")
+	cache.SetWithExp("b", time.Minute, "two")
+	cache.SetWithExp("c", time.Hour, "group", "three")
+	cache.SetWithExp("expired", time.Millisecond, "four")
+	time.Sleep(5 * time.Millisecond)
+	expBefore, _, _ := cache.ExpiresAt("two")
+
+	var calls int
+	cache.TransformAll(func(keys []string, old string) string {
+		calls++
+		return strings.ToUpper(old) + "-" + keys[len(keys)-1]
+	})
+	if calls != 3 {
+		t.Errorf("expected fn to be called for the 3 unexpired items, got: %d", calls)
+	}
+	expected := map[string][]string{"A-one": {"one"}, "B-two": {"two"}, "C-three": {"group", "three"}}
+	for want, keys := range expected {
+		if v, err := cache.Get(keys...); err != nil || v != want {
+			t.Errorf("expected %q for %v, got: %q, %v", want, keys, v, err)
+		}
+	}
+	if expAfter, _, _ := cache.ExpiresAt("two"); !expAfter.Equal(expBefore) {
+		t.Errorf("expected expiration %v to be preserved, got: %v", expBefore, expAfter)
+	}
+	if ttl, err := cache.TTL("one"); err != nil || ttl != -1 {
+		t.Errorf("expected no expiration, got: %v, %v", ttl, err)
+	}
+	if v, _, _ := cache.GetStale("four"); v != "expired" {
+		t.Errorf("expected the expired item to be left untouched, got: %q", v)
+	}
+}
+
+// TestTouchPrefix verifies that TouchPrefix updates the expiration of matching entries only.
+func TestTouchPrefix(t *testing.T) {
+	cache := New[string]().(*bmemCache[string])
+	defer cache.Close()
+
+	cache.Set("one", "tenant", "a", "1")
+	cache.SetWithExp("two", time.Hour, "tenant", "a", "2")
+	cache.Set("three", "tenant", "b", "1")
+	cache.Set("four", "other")
+
+	n := cache.TouchPrefix(time.Minute, "tenant", "a")
+	if n != 2 {
+		t.Errorf("expected 2 entries touched, got: %d", n)
+	}
+	one := cache.items[serializeKey([]string{"tenant", "a", "1"})]
+	two := cache.items[serializeKey([]string{"tenant", "a", "2"})]
+	if one.Exp.IsZero() || !one.Exp.Equal(two.Exp) {
+		t.Errorf("expected matching entries to share the new expiration, got: %v and %v", one.Exp, two.Exp)
+	}
+	if ttl, _ := cache.TTL("tenant", "b", "1"); ttl != -1 {
+		t.Errorf("expected non-matching entry to be unchanged, got TTL: %v", ttl)
+	}
+
+	// A zero duration clears the expiration.
+	if n := cache.TouchPrefix(0, "tenant"); n != 3 {
+		t.Errorf("expected 3 entries touched, got: %d", n)
+	}
+	if ttl, _ := cache.TTL("tenant", "a", "2"); ttl != -1 {
+		t.Errorf("expected expiration to be cleared, got TTL: %v", ttl)
+	}
+
+	// An empty prefix touches everything.
+	if n := cache.TouchPrefix(time.Minute); n != 4 {
+		t.Errorf("expected 4 entries touched, got: %d", n)
+	}
+}
+
+// TestAggregateReadErrors verifies that aggregate reads distinguish an empty cache from an unmatched prefix.
+func TestAggregateReadErrors(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	if _, err := cache.Gets(); err != ErrEmpty {
+		t.Errorf("expected ErrEmpty from Gets on empty cache, got: %v", err)
+	}
+	if _, err := cache.GetsFromPrefix("a"); err != ErrEmpty {
+		t.Errorf("expected ErrEmpty from GetsFromPrefix on empty cache, got: %v", err)
+	}
+
+	// A cache holding only expired entries is considered empty.
+	cache.SetWithExp("temp", 10*time.Millisecond, "a", "temp")
+	time.Sleep(20 * time.Millisecond)
+	if _, err := cache.GetsFromPrefix("a"); err != ErrEmpty {
+		t.Errorf("expected ErrEmpty with only expired entries, got: %v", err)
+	}
+
+	cache.Set("value", "b")
+	if _, err := cache.GetsFromPrefix("a"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for unmatched prefix, got: %v", err)
+	}
+	if _, err := cache.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound from Get, got: %v", err)
+	}
+}
+
+func TestWithInitialCapacityApply(t *testing.T) {
+	tests := []struct {
+		name          string
+		n             int
+		expectedValue int
+	}{
+		{
+			name:          "positive capacity",
+			n:             1000,
+			expectedValue: 1000,
+		},
+		{
+			name:          "zero capacity",
+			n:             0,
+			expectedValue: 0,
+		},
+		{
+			name:          "negative capacity",
+			n:             -1,
+			expectedValue: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := &option{}
+			w := &withInitialCapacity{n: tt.n}
+			w.Apply(opt)
+
+			if opt.InitialCapacity != tt.expectedValue {
+				t.Errorf("expected InitialCapacity to be %v, got %v", tt.expectedValue, opt.InitialCapacity)
+			}
+		})
+	}
+}
+
+// BenchmarkBulkLoad compares bulk loading a large number of entries with and without a capacity hint.
+func BenchmarkBulkLoad(b *testing.B) {
+	const n = 100000
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	for _, bm := range []struct {
+		name    string
+		options []Option
+	}{
+		{name: "default"},
+		{name: "initial capacity", options: []Option{WithInitialCapacity(n)}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				cache := New[int](bm.options...)
+				for j, key := range keys {
+					cache.Set(j, key)
+				}
+				cache.Close()
+			}
+		})
+	}
+}
+
+// TestMaxEntriesReject verifies that a full cache rejects new entries without evicting existing ones.
+func TestMaxEntriesReject(t *testing.T) {
+	cache := New[string](WithMaxEntriesReject(2))
+	defer cache.Close()
+
+	if err := cache.TrySet("one", "1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := cache.TrySetWithExp("two", time.Minute, "2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := cache.TrySet("three", "3"); err != ErrFull {
+		t.Errorf("expected ErrFull, got: %v", err)
+	}
+	cache.Set("three", "3")
+	if cache.IsExist("3") {
+		t.Error("expected Set on a full cache to be discarded")
+	}
+	if !cache.IsExist("1") || !cache.IsExist("2") {
+		t.Error("expected existing entries to be intact")
+	}
+
+	// Overwriting an existing key is allowed.
+	if err := cache.TrySet("uno", "1"); err != nil {
+		t.Errorf("unexpected error on overwrite: %v", err)
+	}
+
+	if err := cache.Delete("1"); err != nil {
+		t.Fatalf("unexpected error on delete: %v", err)
+	}
+	if err := cache.TrySet("three", "3"); err != nil {
+		t.Errorf("expected set to succeed after delete, got: %v", err)
+	}
+
+	// Expired entries are reclaimed before deciding fullness.
+	cache.SetWithExp("two", 10*time.Millisecond, "2")
+	time.Sleep(20 * time.Millisecond)
+	if err := cache.TrySet("four", "4"); err != nil {
+		t.Errorf("expected expired entry to be reclaimed, got: %v", err)
+	}
+}
+
+// TestWithCapacity verifies the entry each overflow policy evicts from a full cache.
+func TestWithCapacity(t *testing.T) {
+	tests := []struct {
+		policy  OverflowPolicy
+		fill    func(cache BMemCache[int])
+		evicted string
+	}{
+		{
+			policy: RejectNew,
+			fill: func(cache BMemCache[int]) {
+				cache.Set(1, "a")
+				cache.Set(2, "b")
+				cache.Set(3, "c")
+			},
+		},
+		{
+			policy: EvictLRU,
+			fill: func(cache BMemCache[int]) {
+				cache.Set(1, "a")
+				cache.Set(2, "b")
+				cache.Set(3, "c")
+				time.Sleep(time.Millisecond)
+				_, _ = cache.Get("a")
+				_, _ = cache.Get("c")
+			},
+			evicted: "b",
+		},
+		{
+			policy: EvictLFU,
+			fill: func(cache BMemCache[int]) {
+				cache.Set(1, "a")
+				cache.Set(2, "b")
+				cache.Set(3, "c")
+				for _, key := range []string{"a", "a", "b", "c", "c", "c"} {
+					_, _ = cache.Get(key)
+				}
+			},
+			evicted: "b",
+		},
+		{
+			policy: EvictSoonestExpiring,
+			fill: func(cache BMemCache[int]) {
+				cache.SetWithExp(1, time.Hour, "a")
+				cache.SetWithExp(2, time.Minute, "b")
+				cache.Set(3, "c")
+			},
+			evicted: "b",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			cache := New[int](WithCapacity(3, tc.policy))
+			defer cache.Close()
+			tc.fill(cache)
+
+			err := cache.TrySet(4, "d")
+			if tc.evicted == "" {
+				if !errors.Is(err, ErrFull) {
+					t.Errorf("expected ErrFull, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, key := range []string{"a", "b", "c", "d"} {
+				if exists := cache.IsExist(key); exists == (key == tc.evicted) {
+					t.Errorf("expected %s to exist: %v, got: %v", key, key != tc.evicted, exists)
+				}
+			}
+			if n := cache.Stats().Evictions; n != 1 {
+				t.Errorf("expected 1 eviction, got: %d", n)
+			}
+		})
+	}
+}
+
+// TestReplaceAllOverflow verifies that ReplaceAll applies the overflow policy to the items
+// beyond the limits as Set would.
+func TestReplaceAllOverflow(t *testing.T) {
+	kvs := make([]KeyValueExp[int], 4)
+	for i := range kvs {
+		kvs[i] = KeyValueExp[int]{Keys: []string{"tenant", "a", strconv.Itoa(i)}, Value: i}
+	}
+	for _, tt := range []struct {
+		name    string
+		options []Option
+		kept    []string
+	}{
+		{name: "reject", options: []Option{WithCapacity(2, RejectNew)}, kept: []string{"0", "1"}},
+		{name: "evict", options: []Option{WithCapacity(2, EvictLRU)}, kept: []string{"2", "3"}},
+		{name: "partition", options: []Option{WithCapacity(0, EvictLRU), WithPrefixLimit([]string{"tenant", "a"}, 2)}, kept: []string{"2", "3"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := New[int](tt.options...)
+			defer cache.Close()
+
+			cache.ReplaceAll(kvs)
+			if n := cache.Len(); n != len(tt.kept) {
+				t.Errorf("expected %d items, got: %d", len(tt.kept), n)
+			}
+			for _, key := range tt.kept {
+				if !cache.IsExist("tenant", "a", key) {
+					t.Errorf("expected item %s to be kept", key)
+				}
+			}
+			if n := cache.Stats().Evictions; n != 0 {
+				t.Errorf("expected no evictions to be reported, got: %d", n)
+			}
+		})
+	}
+}
+
+// TestWithPrefixLimit verifies that a full partition only ever evicts its own entries.
+func TestWithPrefixLimit(t *testing.T) {
+	t.Run("reject", func(t *testing.T) {
+		cache := New[int](WithPrefixLimit([]string{"tenant", "a"}, 2))
+		defer cache.Close()
+
+		cache.Set(1, "tenant", "a", "1")
+		cache.Set(2, "tenant", "a", "2")
+		if err := cache.TrySet(3, "tenant", "a", "3"); !errors.Is(err, ErrFull) {
+			t.Errorf("expected ErrFull, got: %v", err)
+		}
+		if err := cache.TrySet(3, "tenant", "ab", "3"); err != nil {
+			t.Errorf("expected a key outside the partition to be stored, got: %v", err)
+		}
+		if err := cache.TrySet(4, "tenant", "a", "1"); err != nil {
+			t.Errorf("expected an overwrite within a full partition to be stored, got: %v", err)
+		}
+		_ = cache.Delete("tenant", "a", "2")
+		if err := cache.TrySet(5, "tenant", "a", "5"); err != nil {
+			t.Errorf("expected room after a delete, got: %v", err)
+		}
+	})
+
+	t.Run("evict", func(t *testing.T) {
+		cache := New[int](
+			WithCapacity(0, EvictLRU),
+			WithPrefixLimit([]string{"tenant", "a"}, 2),
+			WithPrefixLimit([]string{"tenant", "b"}, 2),
+		)
+		defer cache.Close()
+
+		cache.Set(1, "tenant", "b", "1")
+		cache.Set(2, "tenant", "b", "2")
+		for i := 0; i < 10; i++ {
+			cache.Set(i, "tenant", "a", strconv.Itoa(i))
+		}
+		if keys := cache.KeysFromPrefix("tenant", "a"); len(keys) != 2 {
+			t.Errorf("expected 2 entries in the full partition, got: %q", keys)
+		}
+		if !cache.IsExist("tenant", "a", "8") || !cache.IsExist("tenant", "a", "9") {
+			t.Error("expected the most recent entries of the partition to be kept")
+		}
+		if !cache.IsExist("tenant", "b", "1") || !cache.IsExist("tenant", "b", "2") {
+			t.Error("expected the other partition to be intact")
+		}
+		if n := cache.Stats().Evictions; n != 8 {
+			t.Errorf("expected 8 evictions, got: %d", n)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		cache := New[int](WithPrefixLimit([]string{"tenant", "a"}, 2))
+		defer cache.Close()
+
+		var kvs []KeyValueExp[int]
+		for i := 0; i < 4; i++ {
+			kvs = append(kvs,
+				KeyValueExp[int]{Keys: []string{"tenant", "a", strconv.Itoa(i)}, Value: i},
+				KeyValueExp[int]{Keys: []string{"tenant", "b", strconv.Itoa(i)}, Value: i})
+		}
+		cache.ReplaceAll(kvs)
+		if keys := cache.KeysFromPrefix("tenant", "a"); len(keys) != 2 {
+			t.Errorf("expected 2 entries in the limited partition, got: %q", keys)
+		}
+		if !cache.IsExist("tenant", "a", "0") || !cache.IsExist("tenant", "a", "1") {
+			t.Error("expected the first items of the partition to be kept")
+		}
+		if keys := cache.KeysFromPrefix("tenant", "b"); len(keys) != 4 {
+			t.Errorf("expected 4 entries outside the partition, got: %q", keys)
+		}
+		if err := cache.TrySet(2, "tenant", "a", "2"); !errors.Is(err, ErrFull) {
+			t.Errorf("expected the partition to be counted as full, got: %v", err)
+		}
+	})
+}
+
+// TestGetAndRefresh verifies that GetAndRefresh extends the expiration only on hits.
+func TestGetAndRefresh(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	var cacheErr *CacheError
+	_, err := cache.GetAndRefresh(time.Minute, "missing")
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &cacheErr) || !reflect.DeepEqual(cacheErr.Keys, []string{"missing"}) {
+		t.Errorf("expected ErrNotFound wrapped in a CacheError, got: %v", err)
+	}
+	if cache.IsExist("missing") {
+		t.Error("expected a miss not to create an entry")
+	}
+
+	cache.SetWithExp("token", 50*time.Millisecond, "key")
+	value, err := cache.GetAndRefresh(time.Minute, "key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != "token" {
+		t.Errorf("expected 'token', got: %s", value)
+	}
+	if ttl, _ := cache.TTL("key"); ttl <= 50*time.Millisecond {
+		t.Errorf("expected TTL to be extended, got: %v", ttl)
+	}
+
+	cache.SetWithExp("token", 10*time.Millisecond, "key")
+	time.Sleep(20 * time.Millisecond)
+	if _, err := cache.GetAndRefresh(time.Minute, "key"); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got: %v", err)
+	}
+	if cache.IsExist("key") {
+		t.Error("expected expired entry not to be refreshed")
+	}
+}
+
+// TestCopyOnGet verifies that mutating a returned value does not affect the cached value when copying is enabled.
+func TestCopyOnGet(t *testing.T) {
+	cache := New[[]string](WithCopyOnGet(func(v []string) []string {
+		return append([]string(nil), v...)
+	}))
+	defer cache.Close()
+
+	cache.Set([]string{"a", "b"}, "key")
+	value, err := cache.Get("key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	value[0] = "mutated"
+	values, err := cache.Gets()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	values[0][1] = "mutated"
+
+	value, _ = cache.Get("key")
+	if !reflect.DeepEqual(value, []string{"a", "b"}) {
+		t.Errorf("expected cached value to be unaffected, got: %v", value)
+	}
+
+	// Without the option, the shared value is returned.
+	shared := New[[]string]()
+	defer shared.Close()
+	shared.Set([]string{"a", "b"}, "key")
+	value, _ = shared.Get("key")
+	value[0] = "mutated"
+	if value, _ = shared.Get("key"); value[0] != "mutated" {
+		t.Errorf("expected shared value without the option, got: %v", value)
+	}
+}
+
+// TestValueCodec verifies that values round-trip through the configured codec and that codec errors surface.
+func TestValueCodec(t *testing.T) {
+	type user struct {
+		Name string
+		Tags []string
+	}
+	errInvalid := errors.New("invalid user")
+	cache := New[user](WithValueCodec(
+		func(u user) ([]byte, error) {
+			if u.Name == "" {
+				return nil, errInvalid
+			}
+			return json.Marshal(u)
+		},
+		func(b []byte) (user, error) {
+			var u user
+			err := json.Unmarshal(b, &u)
+			return u, err
+		},
+	)).(*bmemCache[user])
+	defer cache.Close()
+
+	want := user{Name: "alice", Tags: []string{"admin"}}
+	if err := cache.TrySet(want, "alice"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if raw := cache.items[serializeKey([]string{"alice"})].Raw; len(raw) == 0 {
+		t.Error("expected value to be stored encoded")
+	}
+	got, err := cache.Get("alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got: %v", want, got)
+	}
+
+	err = cache.Update(func(old user) user {
+		old.Tags = append(old.Tags, "owner")
+		return old
+	}, "alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ = cache.Get("alice"); !reflect.DeepEqual(got.Tags, []string{"admin", "owner"}) {
+		t.Errorf("expected updated tags, got: %v", got.Tags)
+	}
+
+	// Encoding errors surface on set.
+	if err := cache.TrySet(user{}, "anonymous"); err != errInvalid {
+		t.Errorf("expected encoding error, got: %v", err)
+	}
+	if cache.IsExist("anonymous") {
+		t.Error("expected entry not to be stored on encoding error")
+	}
+
+	// Decoding errors surface on get.
+	cache.items[serializeKey([]string{"corrupt"})] = &cacheEntry[user]{Raw: []byte("{")}
+	if _, err := cache.Get("corrupt"); err == nil {
+		t.Error("expected decoding error")
+	}
+}
+
+// TestExpireAt verifies absolute expiration times set through SetWithExpireAt and ExpireAt.
+func TestExpireAt(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	future := time.Now().Add(time.Hour)
+	cache.SetWithExpireAt("value", future, "future")
+	ttl, err := cache.TTL("future")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("expected TTL close to 1h, got: %v", ttl)
+	}
+
+	cache.SetWithExpireAt("value", time.Now().Add(-time.Second), "past")
+	if _, err := cache.Get("past"); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired for a past expiration, got: %v", err)
+	}
+
+	if err := cache.ExpireAt(future, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+
+	cache.Set("value", "key")
+	if err := cache.ExpireAt(future.Add(time.Hour), "key"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ttl, _ := cache.TTL("key"); ttl <= time.Hour {
+		t.Errorf("expected TTL close to 2h, got: %v", ttl)
+	}
+	if err := cache.ExpireAt(time.Time{}, "key"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ttl, _ := cache.TTL("key"); ttl != -1 {
+		t.Errorf("expected expiration to be cleared, got TTL: %v", ttl)
+	}
+	if err := cache.ExpireAt(time.Now().Add(-time.Second), "key"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cache.Get("key"); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired after expiring in the past, got: %v", err)
+	}
+}
+
+// TestPeek verifies that Peek reads values without removing expired entries.
+func TestPeek(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	var cacheErr *CacheError
+	_, err := cache.Peek("missing")
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &cacheErr) || !reflect.DeepEqual(cacheErr.Keys, []string{"missing"}) {
+		t.Errorf("expected ErrNotFound wrapped in a CacheError, got: %v", err)
+	}
+	cache.Set("value", "key")
+	if value, err := cache.Peek("key"); err != nil || value != "value" {
+		t.Errorf("unexpected peek result: %v, %v", value, err)
+	}
+
+	cache.SetWithExp("temp", 10*time.Millisecond, "expiring")
+	time.Sleep(20 * time.Millisecond)
+	if _, err := cache.Peek("expiring"); !errors.Is(err, ErrExpired) || !errors.As(err, &cacheErr) {
+		t.Errorf("expected ErrExpired wrapped in a CacheError, got: %v", err)
+	}
+	if !cache.IsExist("expiring") {
+		t.Error("expected Peek to leave the expired entry in place")
+	}
+}
+
+// BenchmarkExpiredReads compares concurrent Get and Peek on a workload where most entries have expired.
+func BenchmarkExpiredReads(b *testing.B) {
+	const n = 10000
+	for _, bm := range []struct {
+		name string
+		read func(cache BMemCache[int], keys ...string) (int, error)
+	}{
+		{name: "Get", read: BMemCache[int].Get},
+		{name: "Peek", read: BMemCache[int].Peek},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			cache := New[int]()
+			defer cache.Close()
+			keys := make([]string, n)
+			for i := range keys {
+				keys[i] = strconv.Itoa(i)
+				if i%10 == 0 {
+					cache.Set(i, keys[i])
+				} else {
+					cache.SetWithExp(i, time.Nanosecond, keys[i])
+				}
+			}
+			time.Sleep(time.Millisecond)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var i int
+				for pb.Next() {
+					_, _ = bm.read(cache, keys[i%n])
+					i++
+				}
+			})
+		})
+	}
+}
+
+// TestMaxKeyParts verifies that keys with too many parts are rejected on both writes and lookups.
+func TestMaxKeyParts(t *testing.T) {
+	cache := New[string](WithMaxKeyParts(2))
+	defer cache.Close()
+
+	if err := cache.TrySet("value", "a", "b"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := cache.TrySet("value", "a", "b", "c"); err != ErrTooManyKeyParts {
+		t.Errorf("expected ErrTooManyKeyParts, got: %v", err)
+	}
+	cache.Set("value", "x", "y", "z")
+	if len(cache.Keys()) != 1 {
+		t.Errorf("expected only the valid key to be stored, got: %v", cache.Keys())
+	}
+	if _, err := cache.Get("a", "b", "c"); err != ErrTooManyKeyParts {
+		t.Errorf("expected ErrTooManyKeyParts from Get, got: %v", err)
+	}
+	if err := cache.Delete("a", "b", "c"); err != ErrTooManyKeyParts {
+		t.Errorf("expected ErrTooManyKeyParts from Delete, got: %v", err)
+	}
+	if len(cache.KeysFromPrefix("a", "b", "c")) != 0 {
+		t.Error("expected a prefix longer than the limit to match nothing")
+	}
+}
+
+// TestStats verifies that lookups and expirations are reflected in the usage counters and Len.
+func TestStats(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	cache.Set("value", "key")
+	cache.SetWithExp("temp", 10*time.Millisecond, "expiring")
+	if n := cache.Len(); n != 2 {
+		t.Errorf("expected Len 2, got: %d", n)
+	}
+
+	_, _ = cache.Get("key")
+	_, _ = cache.Peek("key")
+	_, _ = cache.Get("missing")
+	time.Sleep(20 * time.Millisecond)
+	_, _ = cache.Get("expiring")
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Expirations != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if n := cache.Len(); n != 1 {
+		t.Errorf("expected Len 1 after expiration, got: %d", n)
+	}
+}
+
+// TestResetStats verifies that ResetStats returns the counters so far and restarts them from zero.
+func TestResetStats(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	cache.Set("value", "key")
+	_, _ = cache.Get("key")
+	_, _ = cache.Get("key")
+	_, _ = cache.Get("missing")
+	if stats := cache.ResetStats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("unexpected stats before reset: %+v", stats)
+	}
+
+	_, _ = cache.Get("key")
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 0 {
+		t.Errorf("unexpected stats after reset: %+v", stats)
+	}
+	if !cache.IsExist("key") {
+		t.Error("expected the items to be kept")
+	}
+
+	// Concurrent hits are counted exactly once across snapshots.
+	var total int64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				_, _ = cache.Get("key")
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			total += cache.ResetStats().Hits
+		}
+	}
+	total += cache.ResetStats().Hits
+	if total != 4001 {
+		t.Errorf("expected 4001 hits across snapshots, got: %d", total)
+	}
+}
+
+// TestKeyValidator verifies that keys rejected by the validator are neither stored nor looked up.
+func TestKeyValidator(t *testing.T) {
+	errEmptyPart := errors.New("empty key part")
+	cache := New[string](WithKeyValidator(func(parts []string) error {
+		for _, part := range parts {
+			if part == "" {
+				return errEmptyPart
+			}
+		}
+		return nil
+	}))
+	defer cache.Close()
+
+	if err := cache.TrySet("value", "a", "b"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := cache.TrySet("value", "a", ""); err != errEmptyPart {
+		t.Errorf("expected validator error, got: %v", err)
+	}
+	cache.SetWithExp("value", time.Minute, "", "b")
+	if n := cache.Len(); n != 1 {
+		t.Errorf("expected only the valid key to be stored, got %d entries", n)
+	}
+	if _, err := cache.Get("a", ""); err != errEmptyPart {
+		t.Errorf("expected validator error from Get, got: %v", err)
+	}
+	if value, err := cache.Get("a", "b"); err != nil || value != "value" {
+		t.Errorf("unexpected get result: %v, %v", value, err)
+	}
+}
+
+// TestExportImport verifies that exported items can be imported into another cache with their expiration.
+func TestExportImport(t *testing.T) {
+	src := New[string]()
+	defer src.Close()
+	src.Set("permanent", "a")
+	src.SetWithExp("temporary", time.Hour, "b", "c")
+	src.SetWithExp("expired", 10*time.Millisecond, "d")
+	time.Sleep(20 * time.Millisecond)
+
+	kvs := src.Export()
+	if len(kvs) != 2 {
+		t.Fatalf("expected 2 exported items, got: %d", len(kvs))
+	}
+
+	dst := New[string]()
+	defer dst.Close()
+	dst.Set("existing", "a")
+	dst.Import(kvs, false)
+	if value, _ := dst.Get("a"); value != "existing" {
+		t.Errorf("expected existing item to be kept without overwrite, got: %s", value)
+	}
+	if value, _ := dst.Get("b", "c"); value != "temporary" {
+		t.Errorf("expected imported item, got: %s", value)
+	}
+	srcTTL, _ := src.TTL("b", "c")
+	dstTTL, _ := dst.TTL("b", "c")
+	if dstTTL <= 0 || dstTTL > srcTTL {
+		t.Errorf("expected imported expiration to be preserved, got: %v (source %v)", dstTTL, srcTTL)
+	}
+
+	dst.Import(kvs, true)
+	if value, _ := dst.Get("a"); value != "permanent" {
+		t.Errorf("expected existing item to be overwritten, got: %s", value)
+	}
+
+	// Expired items are skipped.
+	dst.Import([]KeyValueExp[string]{{Keys: []string{"e"}, Value: "expired", ExpiresAt: time.Now().Add(-time.Second)}}, true)
+	if dst.IsExist("e") {
+		t.Error("expected expired item to be skipped")
+	}
+}
+
+// TestLazyDeleteKeepsConcurrentSet verifies that Get removing an expired entry never removes
+// a value stored concurrently under the same key.
+func TestLazyDeleteKeepsConcurrentSet(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					_, _ = cache.Get("key")
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 500; i++ {
+		cache.SetWithExp(-i, time.Nanosecond, "key")
+		runtime.Gosched() // let the readers find the expired entry
+		cache.Set(i, "key")
+		if data, err := cache.Peek("key"); err != nil || data != i {
+			t.Errorf("expected %d, got: %d, %v", i, data, err)
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+// TestLazyDeleteOnGetDisabled verifies that Get never takes the write lock on expired entries when disabled.
+func TestLazyDeleteOnGetDisabled(t *testing.T) {
+	cache := New[string](WithLazyDeleteOnGet(false)).(*bmemCache[string])
+	defer cache.Close()
+
+	cache.SetWithExp("temp", 10*time.Millisecond, "key")
+	time.Sleep(20 * time.Millisecond)
+
+	// Holding the read lock makes any attempt to take the write lock block.
+	cache.mu.RLock()
+	done := make(chan error, 1)
+	go func() {
+		_, err := cache.Get("key")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrExpired) {
+			t.Errorf("expected ErrExpired, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected Get not to take the write lock")
+	}
+	cache.mu.RUnlock()
+
+	if !cache.IsExist("key") {
+		t.Error("expected the expired entry to be left in place")
+	}
+}
+
+// TestKeysPage verifies that paging through the keys returns every key exactly once.
+func TestKeysPage(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	for i := 0; i < 25; i++ {
+		cache.Set(i, "group", strconv.Itoa(i))
+	}
+
+	seen := make(map[string]bool)
+	var cursor string
+	var pages int
+	for {
+		keys, next := cache.KeysPage(10, cursor)
+		pages++
+		if len(keys) > 10 {
+			t.Fatalf("expected at most 10 keys per page, got: %d", len(keys))
+		}
+		for _, k := range keys {
+			id := k[1]
+			if seen[id] {
+				t.Errorf("expected key %v to be returned once", k)
+			}
+			seen[id] = true
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if pages != 3 {
+		t.Errorf("expected 3 pages, got: %d", pages)
+	}
+	if len(seen) != 25 {
+		t.Errorf("expected 25 keys, got: %d", len(seen))
+	}
+
+	if keys, next := cache.KeysPage(0, ""); len(keys) != 25 || next != "" {
+		t.Errorf("expected all 25 keys without a cursor, got: %d keys, cursor %q", len(keys), next)
+	}
+	if keys, next := cache.KeysPage(10, "not a cursor!"); keys != nil || next != "" {
+		t.Errorf("expected no keys for a malformed cursor, got: %v, %q", keys, next)
+	}
+}
+
+// TestGetsFromPrefixPage verifies that paging through a prefix returns every matching value
+// exactly once.
+func TestGetsFromPrefixPage(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	for i := 0; i < 25; i++ {
+		cache.Set(i, "group", strconv.Itoa(i))
+		cache.Set(-i, "other", strconv.Itoa(i))
+	}
+
+	seen := make(map[int]bool)
+	var cursor string
+	var pages int
+	for {
+		values, next, err := cache.GetsFromPrefixPage(10, cursor, "group")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		pages++
+		if len(values) > 10 {
+			t.Fatalf("expected at most 10 values per page, got: %d", len(values))
+		}
+		for _, v := range values {
+			if seen[v] {
+				t.Errorf("expected value %d to be returned once", v)
+			}
+			seen[v] = true
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if pages != 3 {
+		t.Errorf("expected 3 pages, got: %d", pages)
+	}
+	for i := 0; i < 25; i++ {
+		if !seen[i] {
+			t.Errorf("expected value %d to be returned", i)
+		}
+	}
+
+	if values, next, err := cache.GetsFromPrefixPage(0, "", "group"); len(values) != 25 || next != "" || err != nil {
+		t.Errorf("expected all 25 values without a cursor, got: %d values, cursor %q, error %v", len(values), next, err)
+	}
+	if _, _, err := cache.GetsFromPrefixPage(10, "", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+	if _, _, err := cache.GetsFromPrefixPage(10, "not a cursor!", "group"); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got: %v", err)
+	}
+}
+
+// TestName verifies that the cache name is returned by Name and included in String.
+func TestName(t *testing.T) {
+	cache := New[string](WithName("users"), WithAutoCleanUp(time.Minute))
+	defer cache.Close()
+	cache.Set("value", "key")
+
+	if name := cache.Name(); name != "users" {
+		t.Errorf("expected name 'users', got: %q", name)
+	}
+	expected := `bmemcache(name="users", entries=1, autoCleanup=true)`
+	if s := cache.String(); s != expected {
+		t.Errorf("expected %s, got: %s", expected, s)
+	}
+
+	unnamed := New[string]()
+	defer unnamed.Close()
+	if name := unnamed.Name(); name != "" {
+		t.Errorf("expected empty name, got: %q", name)
+	}
+}
+
+// TestGetsMap verifies that GetsMap maps each unexpired value to its serialized key.
+func TestGetsMap(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	cache.Set("alice", "user", "1")
+	cache.Set("bob", "user", "2")
+	cache.SetWithExp("temp", 10*time.Millisecond, "temp")
+	time.Sleep(20 * time.Millisecond)
+
+	values := cache.GetsMap()
+	if len(values) != 2 {
+		t.Fatalf("expected 2 values, got: %v", values)
+	}
+	for key, value := range values {
+		var parts []string
+		if err := json.Unmarshal([]byte(key), &parts); err != nil {
+			t.Fatalf("unexpected error decoding key %s: %v", key, err)
+		}
+		data, err := cache.Get(parts...)
+		if err != nil || data != value {
+			t.Errorf("expected key %v to hold %s, got: %s, %v", parts, value, data, err)
+		}
+	}
+	if values[`["user","1"]`] != "alice" {
+		t.Errorf("expected alice, got: %s", values[`["user","1"]`])
+	}
+}
+
+// TestFreeze verifies that a frozen cache rejects writes while reads keep working.
+func TestFreeze(t *testing.T) {
+	cache := New[string](WithAutoCleanUp(time.Minute))
+	defer cache.Close()
+
+	cache.Set("value", "key")
+	cache.Freeze()
+
+	if err := cache.TrySet("other", "other"); err != ErrFrozen {
+		t.Errorf("expected ErrFrozen on TrySet, got: %v", err)
+	}
+	if err := cache.Delete("key"); err != ErrFrozen {
+		t.Errorf("expected ErrFrozen on Delete, got: %v", err)
+	}
+	if err := cache.Update(func(old string) string { return "updated" }, "key"); err != ErrFrozen {
+		t.Errorf("expected ErrFrozen on Update, got: %v", err)
+	}
+	cache.Set("other", "other")
+	cache.Clear()
+
+	if cache.IsExist("other") {
+		t.Error("expected Set to be ignored on a frozen cache")
+	}
+	if data, err := cache.Get("key"); err != nil || data != "value" {
+		t.Errorf("expected value, got: %s, %v", data, err)
+	}
+	if cache.Len() != 1 {
+		t.Errorf("expected 1 entry, got: %d", cache.Len())
+	}
+}
+
+// TestMaxTTL verifies that expirations are clamped to the maximum TTL, including entries set without expiration.
+func TestMaxTTL(t *testing.T) {
+	cache := New[string](WithMaxTTL(time.Minute))
+	defer cache.Close()
+
+	cache.SetWithExp("value", 24*365*time.Hour, "huge")
+	cache.Set("value", "forever")
+	cache.SetWithExpireAt("value", time.Now().Add(time.Hour), "absolute")
+	cache.SetWithExp("value", time.Second, "short")
+
+	for _, key := range []string{"huge", "forever", "absolute"} {
+		ttl, err := cache.TTL(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ttl <= 0 || ttl > time.Minute {
+			t.Errorf("expected the TTL of %s to be clamped to 1m, got: %v", key, ttl)
+		}
+	}
+	if ttl, _ := cache.TTL("short"); ttl > time.Second {
+		t.Errorf("expected a TTL of at most 1s, got: %v", ttl)
+	}
+}
+
+// TestMinTTL verifies that short durations are raised to the minimum TTL while zero still means no expiration.
+func TestMinTTL(t *testing.T) {
+	cache := New[string](WithMinTTL(time.Second))
+	defer cache.Close()
+
+	cache.SetWithExp("value", 10*time.Millisecond, "short")
+	cache.SetWithExp("value", 0, "forever")
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := cache.Get("short"); err != nil {
+		t.Errorf("expected the entry to survive its 10ms TTL, got: %v", err)
+	}
+	if ttl, err := cache.TTL("short"); err != nil || ttl <= 900*time.Millisecond {
+		t.Errorf("expected a TTL close to 1s, got: %v, %v", ttl, err)
+	}
+	if expired, err := cache.IsExpired("forever"); err != nil || expired {
+		t.Errorf("expected the entry without expiration to be kept, got: %v, %v", expired, err)
+	}
+}
+
+// TestMinTTLExceedsMaxTTL verifies that New panics when the minimum TTL exceeds the maximum TTL.
+func TestMinTTLExceedsMaxTTL(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for a minimum TTL exceeding the maximum TTL")
+		}
+	}()
+	New[string](WithMinTTL(time.Minute), WithMaxTTL(time.Second))
+}
+
+// TestGetOrSet verifies that GetOrSet loads missing data once and serves it from the cache afterwards.
+func TestGetOrSet(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	var loads int
+	loader := func() (string, error) {
+		loads++
+		return "loaded", nil
+	}
+	for i := 0; i < 2; i++ {
+		data, err := cache.GetOrSet(loader, "key")
+		if err != nil || data != "loaded" {
+			t.Errorf("expected loaded, got: %s, %v", data, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected the loader to run once, got: %d", loads)
+	}
+
+	loadErr := errors.New("load failed")
+	if _, err := cache.GetOrSetWithExp(func() (string, error) { return "", loadErr }, time.Minute, "failing"); err != loadErr {
+		t.Errorf("expected the loader error, got: %v", err)
+	}
+	if cache.IsExist("failing") {
+		t.Error("expected a failed load not to be cached")
+	}
+}
+
+// TestGetOrSetRechecks verifies that GetOrSet does not load a key stored between its initial
+// lookup and its load.
+func TestGetOrSetRechecks(t *testing.T) {
+	var cache BMemCache[int]
+	cache = New[int](WithOnMiss(func(keys []string, reason error) {
+		// Runs between the initial lookup and the load, as a concurrent load completing would.
+		cache.Set(1, keys...)
+	}))
+	defer cache.Close()
+
+	var loads int64
+	data, err := cache.GetOrSet(func() (int, error) {
+		atomic.AddInt64(&loads, 1)
+		return 2, nil
+	}, "key")
+	if err != nil || data != 1 {
+		t.Errorf("expected the concurrently stored 1, got: %d, %v", data, err)
+	}
+	if n := atomic.LoadInt64(&loads); n != 0 {
+		t.Errorf("expected the loader not to run, got: %d", n)
+	}
+}
+
+// TestGetOrSetCoalesces verifies that concurrent GetOrSet calls on a missing key run the loader once and share its result.
+func TestGetOrSetCoalesces(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	var loads int64
+	release := make(chan struct{})
+	loader := func() (int, error) {
+		atomic.AddInt64(&loads, 1)
+		<-release
+		return 42, errors.New("shared")
+	}
+
+	const callers = 500
+	var started, wg sync.WaitGroup
+	started.Add(callers)
+	wg.Add(callers)
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer wg.Done()
+			started.Done()
+			_, err := cache.GetOrSetWithExp(loader, time.Minute, "key")
+			errs <- err
+		}()
+	}
+	started.Wait()
+	time.Sleep(50 * time.Millisecond) // let the callers pile up on the in-flight load
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	if n := atomic.LoadInt64(&loads); n != 1 {
+		t.Errorf("expected the loader to run once, got: %d", n)
+	}
+	for err := range errs {
+		if err == nil || err.Error() != "shared" {
+			t.Errorf("expected the shared loader error, got: %v", err)
+		}
+	}
+}
+
+// TestKeysFromSuffix verifies that KeysFromSuffix matches the trailing key parts.
+func TestKeysFromSuffix(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	cache.Set("zero")
+	cache.Set("one", "a", "b", "pdf")
+	cache.Set("two", "x", "b", "pdf")
+	cache.Set("three", "a", "c", "png")
+	cache.Set("short", "pdf")
+
+	// Match: suffix pdf
+	suffixMatches := cache.KeysFromSuffix("pdf")
+	if len(suffixMatches) != 3 {
+		t.Errorf("expected 3 suffix matches for pdf, got: %d", len(suffixMatches))
+	}
+	// Match: suffix b|pdf
+	suffixMatches = cache.KeysFromSuffix("b", "pdf")
+	if len(suffixMatches) != 2 {
+		t.Errorf("expected 2 suffix matches for b|pdf, got: %d", len(suffixMatches))
+	}
+	for _, keyParts := range suffixMatches {
+		if _, err := cache.Get(keyParts...); err != nil {
+			t.Errorf("expected key %v to exist, got error: %v", keyParts, err)
+		}
+	}
+
+	// Match: exact full key
+	full := cache.KeysFromSuffix("a", "c", "png")
+	if len(full) != 1 {
+		t.Errorf("expected 1 exact match for a|c|png, got: %d", len(full))
+	}
+
+	// empty: suffix longer than every key
+	empty := cache.KeysFromSuffix("z", "a", "b", "pdf")
+	if len(empty) != 0 {
+		t.Errorf("expected no matches for a suffix longer than the keys, got: %d", len(empty))
+	}
+
+	// Empty suffix
+	suffixMatches = cache.KeysFromSuffix()
+	if len(suffixMatches) != 5 {
+		t.Errorf("expected 5 suffix matches for empty keys, got: %d", len(suffixMatches))
+	}
+}
+
+// TestUpdateExp verifies that UpdateExp extends existing entries and never creates missing ones.
+func TestUpdateExp(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	if err := cache.UpdateExp(time.Minute, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+	if cache.IsExist("missing") {
+		t.Error("expected UpdateExp not to create the entry")
+	}
+
+	cache.SetWithExp("value", 10*time.Millisecond, "key")
+	if err := cache.UpdateExp(time.Minute, "key"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if data, err := cache.Get("key"); err != nil || data != "value" {
+		t.Errorf("expected the extended entry to hold value, got: %s, %v", data, err)
+	}
+	if ttl, _ := cache.TTL("key"); ttl <= 50*time.Second {
+		t.Errorf("expected a TTL close to 1m, got: %v", ttl)
+	}
+}
+
+// TestEagerExpiration verifies that entries are removed close to their exact expiration time.
+func TestEagerExpiration(t *testing.T) {
+	removed := make(chan string, 3)
+	start := time.Now()
+	cache := New[string](WithEagerExpiration(), WithOnExpire(func(keys []string, value string) {
+		removed <- keys[0]
+	}))
+	defer cache.Close()
+
+	ttls := map[string]time.Duration{
+		"c": 150 * time.Millisecond,
+		"a": 50 * time.Millisecond,
+		"b": 100 * time.Millisecond,
+	}
+	for key, ttl := range ttls {
+		cache.SetWithExp("value", ttl, key)
+	}
+	cache.Set("value", "forever")
+
+	for _, want := range []string{"a", "b", "c"} {
+		select {
+		case key := <-removed:
+			if key != want {
+				t.Errorf("expected %s to be removed next, got: %s", want, key)
+			}
+			elapsed := time.Since(start)
+			if elapsed < ttls[key] || elapsed > ttls[key]+40*time.Millisecond {
+				t.Errorf("expected %s to be removed at %v, got: %v", key, ttls[key], elapsed)
+			}
+			if cache.IsExist(key) {
+				t.Errorf("expected %s to be removed from the cache", key)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s to be removed", want)
+		}
+	}
+	if !cache.IsExist("forever") {
+		t.Error("expected the entry without expiration to be kept")
+	}
+}
+
+// TestEagerExpirationReplaced verifies that replacing an entry cancels the removal scheduled for the previous one.
+func TestEagerExpirationReplaced(t *testing.T) {
+	cache := New[string](WithEagerExpiration())
+	defer cache.Close()
+
+	cache.SetWithExp("old", 20*time.Millisecond, "key")
+	cache.SetWithExp("new", time.Minute, "key")
+	time.Sleep(50 * time.Millisecond)
+
+	if data, err := cache.Get("key"); err != nil || data != "new" {
+		t.Errorf("expected new, got: %s, %v", data, err)
+	}
+}
+
+// TestRemaining verifies that Remaining reports the remaining TTL without errors.
+func TestRemaining(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	cache.SetWithExp("value", time.Minute, "ttl")
+	cache.Set("value", "forever")
+	cache.SetWithExp("value", 10*time.Millisecond, "expired")
+	time.Sleep(20 * time.Millisecond)
+
+	if remaining := cache.Remaining("ttl"); remaining <= 0 || remaining > time.Minute {
+		t.Errorf("expected a remaining TTL close to 1m, got: %v", remaining)
+	}
+	if remaining := cache.Remaining("forever"); remaining != -1 {
+		t.Errorf("expected -1 for an entry without expiration, got: %v", remaining)
+	}
+	if remaining := cache.Remaining("expired"); remaining != 0 {
+		t.Errorf("expected 0 for an expired entry, got: %v", remaining)
+	}
+	if remaining := cache.Remaining("missing"); remaining != 0 {
+		t.Errorf("expected 0 for a missing entry, got: %v", remaining)
+	}
+}
+
+// TestPanicOnOverwrite verifies that the overwrite handler fires only when an unexpired entry is replaced.
+func TestPanicOnOverwrite(t *testing.T) {
+	var overwritten [][]string
+	cache := New[string](WithPanicOnOverwrite(func(keys []string) {
+		overwritten = append(overwritten, keys)
+	}))
+	defer cache.Close()
+
+	cache.Set("value", "fresh")
+	cache.SetWithExp("value", 10*time.Millisecond, "expired")
+	time.Sleep(20 * time.Millisecond)
+	cache.Set("value", "expired")
+	if len(overwritten) != 0 {
+		t.Errorf("expected no overwrite to be reported, got: %v", overwritten)
+	}
+
+	cache.SetWithExp("other", time.Minute, "fresh")
+	if !reflect.DeepEqual(overwritten, [][]string{{"fresh"}}) {
+		t.Errorf("expected the overwrite of fresh to be reported, got: %v", overwritten)
+	}
+	if data, _ := cache.Get("fresh"); data != "other" {
+		t.Errorf("expected the write to complete, got: %s", data)
+	}
+}
+
+// TestPanicOnOverwriteNilHandler verifies that overwrites panic without a handler.
+func TestPanicOnOverwriteNilHandler(t *testing.T) {
+	cache := New[string](WithPanicOnOverwrite(nil))
+	defer cache.Close()
+
+	cache.Set("value", "key")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on overwrite")
+		}
+	}()
+	cache.Set("other", "key")
+}
+
+// TestReplaceAll verifies that readers observe either the previous or the new content, never a mix.
+func TestReplaceAll(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	const size = 100
+	generation := func(value string) []KeyValueExp[string] {
+		kvs := make([]KeyValueExp[string], size)
+		for i := range kvs {
+			kvs[i] = KeyValueExp[string]{Keys: []string{strconv.Itoa(i)}, Value: value}
+		}
+		return kvs
+	}
+	cache.ReplaceAll(generation("gen0"))
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				values := cache.GetsMap()
+				if len(values) != size {
+					t.Errorf("expected %d values, got: %d", size, len(values))
+					return
+				}
+				var first string
+				for _, v := range values {
+					if first == "" {
+						first = v
+					} else if v != first {
+						t.Errorf("expected a single generation, got: %s and %s", first, v)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 50; i++ {
+		cache.ReplaceAll(generation("gen" + strconv.Itoa(i)))
+	}
+	close(done)
+	wg.Wait()
+
+	if data, _ := cache.Get("0"); data != "gen50" {
+		t.Errorf("expected gen50, got: %s", data)
+	}
+}
+
+// TestKeyStats verifies that per-entry hits and last access advance on lookups.
+func TestKeyStats(t *testing.T) {
+	cache := New[string](WithKeyStats())
+	defer cache.Close()
+
+	var cacheErr *CacheError
+	_, err := cache.KeyStats("missing")
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &cacheErr) || !reflect.DeepEqual(cacheErr.Keys, []string{"missing"}) {
+		t.Errorf("expected ErrNotFound wrapped in a CacheError, got: %v", err)
+	}
+
+	before := time.Now()
+	cache.SetWithExp("value", time.Minute, "key")
+	stat, err := cache.KeyStats("key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stat.Hits != 0 || !stat.LastAccess.IsZero() || stat.Created.Before(before) {
+		t.Errorf("unexpected stats for a fresh entry: %+v", stat)
+	}
+	if stat.TTL <= 0 || stat.TTL > time.Minute {
+		t.Errorf("expected a TTL close to 1m, got: %v", stat.TTL)
+	}
+
+	var lastAccess time.Time
+	for i := 1; i <= 3; i++ {
+		time.Sleep(time.Millisecond)
+		_, _ = cache.Get("key")
+		stat, _ = cache.KeyStats("key")
+		if stat.Hits != int64(i) {
+			t.Errorf("expected %d hits, got: %d", i, stat.Hits)
+		}
+		if !stat.LastAccess.After(lastAccess) {
+			t.Errorf("expected the last access to advance past %v, got: %v", lastAccess, stat.LastAccess)
+		}
+		lastAccess = stat.LastAccess
+	}
+
+	// Refreshing the expiration preserves the statistics.
+	_, _ = cache.GetAndRefresh(time.Hour, "key")
+	if stat, _ = cache.KeyStats("key"); stat.Hits != 4 {
+		t.Errorf("expected 4 hits, got: %d", stat.Hits)
+	}
+
+	cache.SetWithExp("value", time.Nanosecond, "expired")
+	time.Sleep(time.Millisecond)
+	if _, err := cache.KeyStats("expired"); !errors.Is(err, ErrExpired) || !errors.As(err, &cacheErr) {
+		t.Errorf("expected ErrExpired wrapped in a CacheError, got: %v", err)
+	}
+}
+
+// TestOnSet verifies that the write callback fires with the effective TTL for explicit and loader-driven writes.
+func TestOnSet(t *testing.T) {
+	type write struct {
+		keys  []string
+		value string
+		ttl   time.Duration
+	}
+	var writes []write
+	cache := New[string](WithMaxTTL(time.Hour), WithOnSet(func(keys []string, value string, ttl time.Duration) {
+		writes = append(writes, write{keys: keys, value: value, ttl: ttl})
+	}))
+	defer cache.Close()
+
+	cache.SetWithExp("explicit", time.Minute, "a", "b")
+	_, _ = cache.GetOrSetWithExp(func() (string, error) { return "loaded", nil }, 24*time.Hour, "c")
+	_ = cache.Update(func(old string) string { return "updated" }, "c")
+
+	if len(writes) != 2 {
+		t.Fatalf("expected 2 writes, got: %+v", writes)
+	}
+	if !reflect.DeepEqual(writes[0].keys, []string{"a", "b"}) || writes[0].value != "explicit" {
+		t.Errorf("unexpected explicit write: %+v", writes[0])
+	}
+	if writes[0].ttl <= 59*time.Second || writes[0].ttl > time.Minute {
+		t.Errorf("expected a TTL close to 1m, got: %v", writes[0].ttl)
+	}
+	if !reflect.DeepEqual(writes[1].keys, []string{"c"}) || writes[1].value != "loaded" {
+		t.Errorf("unexpected loader write: %+v", writes[1])
+	}
+	if writes[1].ttl <= 59*time.Minute || writes[1].ttl > time.Hour {
+		t.Errorf("expected the TTL to be capped to 1h, got: %v", writes[1].ttl)
+	}
+}
+
+// TestWaitGet verifies that WaitGet unblocks once the key is set, and gives up when the context is done.
+func TestWaitGet(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cache.Set("value", "key")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if data, err := cache.WaitGet(ctx, "key"); err != nil || data != "value" {
+		t.Errorf("expected value, got: %s, %v", data, err)
+	}
+
+	// A hit returns immediately.
+	if data, err := cache.WaitGet(context.Background(), "key"); err != nil || data != "value" {
+		t.Errorf("expected value, got: %s, %v", data, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := cache.WaitGet(ctx, "missing"); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+	if n := len(cache.(*bmemCache[string]).waiters); n != 0 {
+		t.Errorf("expected no remaining waiters, got: %d", n)
+	}
+}
+
+// TestFilter verifies that Filter and FilterKeys only return the matching unexpired items.
+func TestFilter(t *testing.T) {
+	type session struct {
+		User  string
+		Stale bool
+	}
+	cache := New[session]()
+	defer cache.Close()
+
+	cache.Set(session{User: "alice", Stale: true}, "session", "1")
+	cache.Set(session{User: "bob"}, "session", "2")
+	cache.Set(session{User: "carol", Stale: true}, "session", "3")
+	cache.SetWithExp(session{User: "dave", Stale: true}, 10*time.Millisecond, "session", "4")
+	time.Sleep(20 * time.Millisecond)
+
+	stale := func(keys []string, value session) bool { return value.Stale }
+	values := cache.Filter(stale)
+	if len(values) != 2 {
+		t.Fatalf("expected 2 stale sessions, got: %v", values)
+	}
+	for _, v := range values {
+		if !v.Stale || (v.User != "alice" && v.User != "carol") {
+			t.Errorf("unexpected session: %+v", v)
+		}
+	}
+
+	keys := cache.FilterKeys(func(keys []string, value session) bool { return keys[1] == "2" })
+	if !reflect.DeepEqual(keys, [][]string{{"session", "2"}}) {
+		t.Errorf("expected [[session 2]], got: %v", keys)
+	}
+}
+
+// TestCleanupEveryNWrites verifies that expired entries are removed after the configured number of writes.
+func TestCleanupEveryNWrites(t *testing.T) {
+	cache := New[string](WithCleanupEveryNWrites(5))
+	defer cache.Close()
+
+	cache.SetWithExp("value", 10*time.Millisecond, "expired")
+	time.Sleep(20 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		cache.Set("value", strconv.Itoa(i))
+	}
+	if !cache.IsExist("expired") {
+		t.Error("expected the expired entry to be kept before the fifth write")
+	}
+	cache.Set("value", "3")
+	if cache.IsExist("expired") {
+		t.Error("expected the expired entry to be removed by the fifth write")
+	}
+	if n := cache.Stats().Expirations; n != 1 {
+		t.Errorf("expected 1 expiration, got: %d", n)
+	}
+}
+
+// TestSizeOf verifies that Size tracks the size of the stored values across writes and removals.
+func TestSizeOf(t *testing.T) {
+	cache := NewBytes()
+	defer cache.Close()
+
+	cache.Set(make([]byte, 100), "a")
+	cache.Set(make([]byte, 50), "b")
+	if size := cache.Size(); size != 150 {
+		t.Errorf("expected a size of 150, got: %d", size)
+	}
+	cache.Set(make([]byte, 10), "a")
+	if size := cache.Size(); size != 60 {
+		t.Errorf("expected a size of 60 after overwriting, got: %d", size)
+	}
+	_ = cache.Update(func(old []byte) []byte { return append(old, 1, 2) }, "b")
+	if size := cache.Size(); size != 62 {
+		t.Errorf("expected a size of 62 after updating, got: %d", size)
+	}
+	_ = cache.Delete("a")
+	if size := cache.Size(); size != 52 {
+		t.Errorf("expected a size of 52 after deleting, got: %d", size)
+	}
+	cache.Clear()
+	if size := cache.Size(); size != 0 {
+		t.Errorf("expected a size of 0 after clearing, got: %d", size)
+	}
+
+	strings := NewString()
+	defer strings.Close()
+	strings.Set("hello", "greeting")
+	if size := strings.Size(); size != 5 {
+		t.Errorf("expected a size of 5, got: %d", size)
+	}
+}
+
+// TestWithMaxValueSize verifies that values larger than WithMaxValueSize are rejected without
+// creating or replacing an entry, and that the option requires WithSizeOf.
+func TestWithMaxValueSize(t *testing.T) {
+	cache := NewString(WithMaxValueSize(5))
+	defer cache.Close()
+
+	if err := cache.TrySet("small", "key"); err != nil {
+		t.Fatalf("expected no error for a value at the limit, got: %v", err)
+	}
+	if err := cache.TrySet("too large", "key"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got: %v", err)
+	}
+	if err := cache.TrySetWithExp("too large", time.Minute, "other"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got: %v", err)
+	}
+	cache.Set("too large", "another")
+	if cache.IsExist("other") || cache.IsExist("another") {
+		t.Error("expected oversized values not to create entries")
+	}
+	if v, err := cache.Get("key"); err != nil || v != "small" {
+		t.Errorf("expected the existing value to be kept, got: %v, %v", v, err)
+	}
+	if size := cache.Size(); size != 5 {
+		t.Errorf("expected size 5, got: %v", size)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic without WithSizeOf")
+		}
+	}()
+	New[string](WithMaxValueSize(5))
+}
+
+// TestCacheError verifies that key lookups report the key parts while remaining comparable to the sentinel errors.
+func TestCacheError(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	_, err := cache.Get("user", "42")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+	var cacheErr *CacheError
+	if !errors.As(err, &cacheErr) {
+		t.Fatalf("expected a *CacheError, got: %T", err)
+	}
+	if !reflect.DeepEqual(cacheErr.Keys, []string{"user", "42"}) {
+		t.Errorf("expected keys [user 42], got: %v", cacheErr.Keys)
+	}
+	if msg := err.Error(); msg != `key ["user" "42"]: not found` {
+		t.Errorf("unexpected error message: %s", msg)
+	}
+
+	cache.SetWithExp("value", 10*time.Millisecond, "session")
+	time.Sleep(20 * time.Millisecond)
+	if _, err := cache.TTL("session"); !errors.As(err, &cacheErr) || !errors.Is(err, ErrExpired) || cacheErr.Keys[0] != "session" {
+		t.Errorf("expected an expired *CacheError for session, got: %v", err)
+	}
+	if err := cache.Delete("missing"); !errors.As(err, &cacheErr) || !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a not found *CacheError, got: %v", err)
+	}
+	if _, err := cache.IsExpired("missing"); !errors.As(err, &cacheErr) || !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a not found *CacheError, got: %v", err)
+	}
+}
+
+// TestValueTTLFunc verifies that Set derives the expiration from the value while SetWithExp overrides it.
+func TestValueTTLFunc(t *testing.T) {
+	type token struct {
+		ValidUntil time.Time
+	}
+	cache := New[token](WithValueTTLFunc(func(v token) time.Duration {
+		if v.ValidUntil.IsZero() {
+			return 0
+		}
+		return time.Until(v.ValidUntil)
+	}))
+	defer cache.Close()
+
+	now := time.Now()
+	cache.Set(token{ValidUntil: now.Add(20 * time.Millisecond)}, "short")
+	cache.Set(token{ValidUntil: now.Add(time.Minute)}, "long")
+	cache.Set(token{}, "forever")
+	cache.Set(token{ValidUntil: now.Add(-time.Minute)}, "stale")
+	cache.SetWithExp(token{ValidUntil: now.Add(20 * time.Millisecond)}, time.Minute, "explicit")
+	time.Sleep(40 * time.Millisecond)
+
+	if _, err := cache.Get("short"); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected short to expire per its value, got: %v", err)
+	}
+	if _, err := cache.Get("stale"); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected stale to be stored already expired, got: %v", err)
+	}
+	if ttl, err := cache.TTL("long"); err != nil || ttl <= 50*time.Second {
+		t.Errorf("expected long to expire in about 1m, got: %v, %v", ttl, err)
+	}
+	if ttl, err := cache.TTL("forever"); err != nil || ttl != -1 {
+		t.Errorf("expected forever not to expire, got: %v, %v", ttl, err)
+	}
+	if _, err := cache.Get("explicit"); err != nil {
+		t.Errorf("expected the explicit duration to override the value, got: %v", err)
+	}
+}
+
+// TestPeekKeepsAccessMetadata verifies that Peek does not update the per-entry access metadata.
+func TestPeekKeepsAccessMetadata(t *testing.T) {
+	cache := New[string](WithKeyStats())
+	defer cache.Close()
+
+	cache.Set("value", "cold")
+	for i := 0; i < 3; i++ {
+		if data, err := cache.Peek("cold"); err != nil || data != "value" {
+			t.Errorf("expected value, got: %s, %v", data, err)
+		}
+	}
+	stat, _ := cache.KeyStats("cold")
+	if stat.Hits != 0 || !stat.LastAccess.IsZero() {
+		t.Errorf("expected Peek to leave the access metadata untouched, got: %+v", stat)
+	}
+	if hits := cache.Stats().Hits; hits != 3 {
+		t.Errorf("expected Peek to count in Stats, got: %d hits", hits)
+	}
+}
+
+// TestMetricsCallback verifies that the metrics callback receives each operation once with its outcome.
+func TestMetricsCallback(t *testing.T) {
+	type call struct {
+		op  string
+		hit bool
+	}
+	var calls []call
+	cache := New[string](WithMetricsCallback(func(op string, hit bool, durationNs int64) {
+		if durationNs < 0 {
+			t.Errorf("expected a non-negative duration for %s, got: %d", op, durationNs)
+		}
+		calls = append(calls, call{op: op, hit: hit})
+	}))
+	defer cache.Close()
+
+	cache.Set("value", "key")
+	_, _ = cache.Get("key")
+	_, _ = cache.Get("missing")
+	_, _ = cache.GetOrSet(func() (string, error) { return "loaded", nil }, "loaded")
+	_ = cache.Delete("missing")
+	_ = cache.Delete("key")
+	cache.Set("value", "a")
+	cache.Set("value", "b")
+	cache.DeleteMany([][]string{{"a"}, {"b"}})
+	cache.DeleteMany([][]string{{"a"}, {"missing"}})
+	_, _ = cache.Gets()
+
+	expected := []call{
+		{op: "Set", hit: true},
+		{op: "Get", hit: true},
+		{op: "Get", hit: false},
+		{op: "GetOrSet", hit: true},
+		{op: "Delete", hit: false},
+		{op: "Delete", hit: true},
+		{op: "Set", hit: true},
+		{op: "Set", hit: true},
+		{op: "DeleteMany", hit: true},
+		{op: "DeleteMany", hit: false},
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected %v, got: %v", expected, calls)
+	}
+}
+
+// TestGetSet verifies that GetSet returns the replaced value, treating expired entries as absent.
+func TestGetSet(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	if old, existed := cache.GetSet(1, "key"); existed || old != 0 {
+		t.Errorf("expected no previous value, got: %d, %v", old, existed)
+	}
+	cache.SetWithExp(1, time.Minute, "key")
+	if old, existed := cache.GetSet(2, "key"); !existed || old != 1 {
+		t.Errorf("expected previous value 1, got: %d, %v", old, existed)
+	}
+	if ttl, _ := cache.TTL("key"); ttl != -1 {
+		t.Errorf("expected the expiration to be reset, got: %v", ttl)
+	}
+
+	cache.SetWithExp(3, 10*time.Millisecond, "expired")
+	time.Sleep(20 * time.Millisecond)
+	if old, existed := cache.GetSet(4, "expired"); existed || old != 0 {
+		t.Errorf("expected the expired value to be reported as absent, got: %d, %v", old, existed)
+	}
+	if data, err := cache.Get("expired"); err != nil || data != 4 {
+		t.Errorf("expected 4, got: %d, %v", data, err)
+	}
+}
+
+// TestGetSetHooks verifies that GetSet and SwapWithExp go through the write hooks like Set does.
+func TestGetSetHooks(t *testing.T) {
+	var sets, overwrites []string
+	var observed []Op
+	cache := New[int](
+		WithOnSet(func(keys []string, value int, ttl time.Duration) { sets = append(sets, keys[0]) }),
+		WithPanicOnOverwrite(func(keys []string) { overwrites = append(overwrites, keys[0]) }),
+		WithObserver(func(ev Observation[int]) { observed = append(observed, ev.Op) }),
+	)
+	defer cache.Close()
+
+	cache.GetSet(1, "key")
+	cache.SwapWithExp(2, time.Minute, "key")
+	if len(sets) != 2 {
+		t.Errorf("expected WithOnSet to fire twice, got: %v", sets)
+	}
+	if len(overwrites) != 1 {
+		t.Errorf("expected one overwrite to be reported, got: %v", overwrites)
+	}
+	if len(observed) != 2 || observed[0] != OpSet || observed[1] != OpSet {
+		t.Errorf("expected two OpSet observations, got: %v", observed)
+	}
+}
+
+// TestSetIfNewerHooks verifies that versioned writes go through the write hooks, while rejected
+// ones do not.
+func TestSetIfNewerHooks(t *testing.T) {
+	var sets []string
+	var overwrites int
+	cache := New[string](
+		WithOnSet(func(keys []string, value string, ttl time.Duration) { sets = append(sets, value) }),
+		WithPanicOnOverwrite(func(keys []string) { overwrites++ }),
+		WithCleanupEveryNWrites(3),
+		WithLazyDeleteOnGet(false),
+	)
+	defer cache.Close()
+
+	cache.SetWithExp("expired", time.Nanosecond, "expired")
+	time.Sleep(time.Millisecond)
+	cache.SetIfNewer("v1", 1, "key")
+	cache.SetIfNewer("v0", 0, "key")
+	cache.SetIfNewer("v2", 2, "key")
+	if len(sets) != 3 || sets[1] != "v1" || sets[2] != "v2" {
+		t.Errorf("expected WithOnSet to see expired, v1 and v2, got: %v", sets)
+	}
+	if overwrites != 1 {
+		t.Errorf("expected one overwrite to be reported, got: %d", overwrites)
+	}
+	if _, _, err := cache.GetStale("expired"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the versioned writes to trigger the cleanup, got: %v", err)
+	}
+}
+
+// TestSwapWithExp verifies that SwapWithExp returns the replaced value and sets the new expiration.
+func TestSwapWithExp(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	if old, existed := cache.SwapWithExp(1, time.Minute, "key"); existed || old != 0 {
+		t.Errorf("expected no previous value, got: %d, %v", old, existed)
+	}
+	if ttl, _ := cache.TTL("key"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected a TTL of up to a minute, got: %v", ttl)
+	}
+	if old, existed := cache.SwapWithExp(2, time.Hour, "key"); !existed || old != 1 {
+		t.Errorf("expected previous value 1, got: %d, %v", old, existed)
+	}
+	if ttl, _ := cache.TTL("key"); ttl <= time.Minute || ttl > time.Hour {
+		t.Errorf("expected a TTL of up to an hour, got: %v", ttl)
+	}
+	if old, existed := cache.SwapWithExp(3, 0, "key"); !existed || old != 2 {
+		t.Errorf("expected previous value 2, got: %d, %v", old, existed)
+	}
+	if ttl, _ := cache.TTL("key"); ttl != -1 {
+		t.Errorf("expected no expiration, got: %v", ttl)
+	}
+
+	cache.SetWithExp(4, 10*time.Millisecond, "expired")
+	time.Sleep(20 * time.Millisecond)
+	if old, existed := cache.SwapWithExp(5, time.Minute, "expired"); existed || old != 0 {
+		t.Errorf("expected the expired value to be reported as absent, got: %d, %v", old, existed)
+	}
+	if data, err := cache.Get("expired"); err != nil || data != 5 {
+		t.Errorf("expected 5, got: %d, %v", data, err)
+	}
+}
+
+// TestAllowEmptyKeys verifies that keys without any part are rejected only when disallowed.
+func TestAllowEmptyKeys(t *testing.T) {
+	allowed := New[string]()
+	defer allowed.Close()
+	if err := allowed.TrySet("value"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if data, err := allowed.Get(); err != nil || data != "value" {
+		t.Errorf("expected value, got: %s, %v", data, err)
+	}
+
+	disallowed := New[string](WithAllowEmptyKeys(false))
+	defer disallowed.Close()
+	if err := disallowed.TrySet("value"); err != ErrEmptyKey {
+		t.Errorf("expected ErrEmptyKey on TrySet, got: %v", err)
+	}
+	if _, err := disallowed.Get(); err != ErrEmptyKey {
+		t.Errorf("expected ErrEmptyKey on Get, got: %v", err)
+	}
+	disallowed.Set("value", "key")
+	if keys := disallowed.KeysFromPrefix(); len(keys) != 1 {
+		t.Errorf("expected an empty prefix to match every key, got: %v", keys)
+	}
+}
+
+// TestGetStale verifies that GetStale serves expired data flagged as stale without removing it.
+func TestGetStale(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	if _, _, err := cache.GetStale("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+
+	cache.SetWithExp("old", 10*time.Millisecond, "key")
+	if data, stale, err := cache.GetStale("key"); err != nil || stale || data != "old" {
+		t.Errorf("expected fresh old, got: %s, %v, %v", data, stale, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if data, stale, err := cache.GetStale("key"); err != nil || !stale || data != "old" {
+			t.Errorf("expected stale old, got: %s, %v, %v", data, stale, err)
+		}
+	}
+	if !cache.IsExist("key") {
+		t.Error("expected GetStale not to remove the expired entry")
+	}
+}
+
+// TestWriteThroughReplay verifies that replaying the write-ahead log restores the content of the logged cache.
+func TestWriteThroughReplay(t *testing.T) {
+	var log bytes.Buffer
+	cache := New[int](WithWriteThrough(&log, time.Hour))
+
+	cache.Set(1, "a")
+	cache.SetWithExp(2, time.Minute, "b", "c")
+	cache.Set(3, "deleted")
+	_ = cache.Delete("deleted")
+	_ = cache.Update(func(old int) int { return old + 10 }, "a")
+	cache.SetWithExp(4, 10*time.Millisecond, "expiring")
+	cache.Close() // flushes the log
+	time.Sleep(20 * time.Millisecond)
+
+	replayed := New[int]()
+	defer replayed.Close()
+	if err := replayed.Replay(&log); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]int{`["a"]`: 11, `["b","c"]`: 2}
+	if values := replayed.GetsMap(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got: %v", expected, values)
+	}
+	if ttl, _ := replayed.TTL("b", "c"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the expiration to be restored, got: %v", ttl)
+	}
+
+	if err := replayed.Replay(strings.NewReader("not json")); err == nil {
+		t.Error("expected an error for a malformed log")
+	}
+}
+
+// TestSetManyWithExp verifies that SetManyWithExp stores every item with its own expiration.
+func TestSetManyWithExp(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	cache.SetManyWithExp([]EntryWithExp[string]{
+		{Keys: []string{"forever"}, Data: "a"},
+		{Keys: []string{"short"}, Data: "b", Duration: 20 * time.Millisecond},
+		{Keys: []string{"long"}, Data: "c", Duration: time.Hour},
+	})
+
+	if ttl, err := cache.TTL("forever"); err != nil || ttl != -1 {
+		t.Errorf("expected no expiration, got: %v, %v", ttl, err)
+	}
+	if ttl, err := cache.TTL("short"); err != nil || ttl <= 0 || ttl > 20*time.Millisecond {
+		t.Errorf("expected a TTL of at most 20ms, got: %v, %v", ttl, err)
+	}
+	if ttl, err := cache.TTL("long"); err != nil || ttl <= 20*time.Millisecond || ttl > time.Hour {
+		t.Errorf("expected a TTL of at most 1h, got: %v, %v", ttl, err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := cache.Get("short"); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got: %v", err)
+	}
+	for key, expected := range map[string]string{"forever": "a", "long": "c"} {
+		if data, err := cache.Get(key); err != nil || data != expected {
+			t.Errorf("expected %q, got: %q, %v", expected, data, err)
+		}
+	}
+}
+
+// TestSetManyWithExpMetrics verifies that a batch with discarded items is reported as failed.
+func TestSetManyWithExpMetrics(t *testing.T) {
+	var hits []bool
+	cache := New[string](WithMaxKeyParts(1), WithMetricsCallback(func(op string, hit bool, durationNs int64) {
+		if op == "SetManyWithExp" {
+			hits = append(hits, hit)
+		}
+	}))
+	defer cache.Close()
+
+	cache.SetManyWithExp([]EntryWithExp[string]{{Keys: []string{"a"}, Data: "a"}})
+	cache.SetManyWithExp([]EntryWithExp[string]{
+		{Keys: []string{"b"}, Data: "b"},
+		{Keys: []string{"too", "deep"}, Data: "c"},
+	})
+	if !reflect.DeepEqual(hits, []bool{true, false}) {
+		t.Errorf("expected the second batch to be reported as failed, got: %v", hits)
+	}
+	if !cache.IsExist("b") {
+		t.Error("expected the valid items of the batch to be stored")
+	}
+
+	err := cache.(*bmemCache[string]).setMany([]EntryWithExp[string]{{Keys: []string{"too", "deep"}}})
+	var cacheErr *CacheError
+	if !errors.Is(err, ErrTooManyKeyParts) || !errors.As(err, &cacheErr) || !reflect.DeepEqual(cacheErr.Keys, []string{"too", "deep"}) {
+		t.Errorf("expected ErrTooManyKeyParts wrapped in a CacheError, got: %v", err)
+	}
+}
+
+// TestKeysSorted verifies that KeysSorted orders keys part by part, shorter keys first.
+func TestKeysSorted(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	expected := [][]string{{"a"}, {"a", "a"}, {"a", "b"}, {"a", "b", "a"}, {"ab"}, {"b"}}
+	for _, i := range []int{4, 1, 5, 0, 3, 2} {
+		cache.Set(i, expected[i]...)
+	}
+	for run := 0; run < 10; run++ {
+		if keys := cache.KeysSorted(); !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("expected %v, got: %v", expected, keys)
+		}
+	}
+}
+
+// TestWithExpiredRetention verifies that expired entries remain available to GetStale during the retention period.
+func TestWithExpiredRetention(t *testing.T) {
+	var expirations int32
+	cache := New[string](
+		WithExpiredRetention(50*time.Millisecond),
+		WithOnExpire(func(keys []string, value string) { atomic.AddInt32(&expirations, 1) }),
+	)
+	defer cache.Close()
+
+	cache.SetWithExp("value", 10*time.Millisecond, "key")
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := cache.Get("key"); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got: %v", err)
+	}
+	if data, stale, err := cache.GetStale("key"); err != nil || !stale || data != "value" {
+		t.Errorf("expected the stale value to be retained, got: %q, %t, %v", data, stale, err)
+	}
+	if n := atomic.LoadInt32(&expirations); n != 0 {
+		t.Errorf("expected no expiration to be reported during retention, got: %d", n)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := cache.Get("key"); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got: %v", err)
+	}
+	if _, _, err := cache.GetStale("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the entry to be removed after retention, got: %v", err)
+	}
+	if n := atomic.LoadInt32(&expirations); n != 1 {
+		t.Errorf("expected 1 expiration, got: %d", n)
+	}
+}
+
+// TestReset verifies that Reset empties the cache and replaces its configuration.
+func TestReset(t *testing.T) {
+	cache := New[int](WithMaxEntriesReject(1))
+	defer cache.Close()
+	cache.Set(1, "a")
+
+	cache.Reset(WithMaxKeyParts(1), WithMinTTL(time.Minute))
+
+	if n := cache.Len(); n != 0 {
+		t.Errorf("expected an empty cache, got: %d entries", n)
+	}
+	if err := cache.TrySet(2, "a", "b"); !errors.Is(err, ErrTooManyKeyParts) {
+		t.Errorf("expected ErrTooManyKeyParts, got: %v", err)
+	}
+	cache.SetWithExp(3, time.Millisecond, "b")
+	if ttl, err := cache.TTL("b"); err != nil || ttl <= time.Millisecond {
+		t.Errorf("expected the TTL to be raised to WithMinTTL, got: %v, %v", ttl, err)
+	}
+	// The entry limit is no longer configured.
+	if err := cache.TrySet(4, "c"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// TestResetConcurrent verifies that Reset is safe to call concurrently with other operations.
+func TestResetConcurrent(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := strconv.Itoa(j)
+				cache.SetWithExp(i, time.Minute, key)
+				_, _ = cache.Get(key)
+			}
+		}(i)
+	}
+	for i := 0; i < 100; i++ {
+		cache.Reset(WithMaxTTL(time.Duration(i+1) * time.Minute))
+	}
+	wg.Wait()
+}
+
+// TestSyncMapBackend verifies that lookups through the sync.Map index follow every kind of write.
+func TestSyncMapBackend(t *testing.T) {
+	cache := New[int](WithSyncMapBackend())
+	defer cache.Close()
+
+	cache.Set(1, "a")
+	cache.SetWithExp(2, time.Hour, "b")
+	if data, err := cache.Get("a"); err != nil || data != 1 {
+		t.Errorf("expected 1, got: %d, %v", data, err)
+	}
+	if ttl, err := cache.TTL("b"); err != nil || ttl <= 0 {
+		t.Errorf("expected a positive TTL, got: %v, %v", ttl, err)
+	}
+
+	_ = cache.Delete("a")
+	if cache.IsExist("a") {
+		t.Errorf("expected the deleted item to be gone")
+	}
+
+	cache.ReplaceAll([]KeyValueExp[int]{{Keys: []string{"c"}, Value: 3}})
+	if cache.IsExist("b") {
+		t.Errorf("expected the replaced item to be gone")
+	}
+	if data, err := cache.Get("c"); err != nil || data != 3 {
+		t.Errorf("expected 3, got: %d, %v", data, err)
+	}
+
+	cache.Clear()
+	if _, err := cache.Get("c"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after Clear, got: %v", err)
+	}
+
+	cache.Set(4, "d")
+	cache.Reset(WithSyncMapBackend())
+	if cache.IsExist("d") {
+		t.Errorf("expected the item to be gone after Reset")
+	}
+}
+
+// TestWithBloomFilter verifies that stored keys are always found and that keys never stored
+// are mostly ruled out by the filter.
+func TestWithBloomFilter(t *testing.T) {
+	const n = 1000
+	cache := New[int](WithBloomFilter(n, 0.01)).(*bmemCache[int])
+	defer cache.Close()
+
+	for i := 0; i < n; i++ {
+		cache.Set(i, "present", strconv.Itoa(i))
+	}
+	for i := 0; i < n; i++ {
+		if data, err := cache.Get("present", strconv.Itoa(i)); err != nil || data != i {
+			t.Fatalf("expected %d, got: %d, %v", i, data, err)
+		}
+	}
+
+	var passed int
+	for i := 0; i < n; i++ {
+		keys := []string{"absent", strconv.Itoa(i)}
+		if _, err := cache.Get(keys...); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+		if cache.bloom.Load().mayContain(serializeKey(keys)) {
+			passed++
+		}
+	}
+	if passed > n/20 {
+		t.Errorf("expected about 1%% of absent keys to pass the filter, got: %d of %d", passed, n)
+	}
+
+	var loads int
+	_, _ = cache.GetOrSet(func() (int, error) { loads++; return 1, nil }, "loaded")
+	if loads != 1 || !cache.IsExist("loaded") {
+		t.Errorf("expected GetOrSet to load a key never stored, got %d loads", loads)
+	}
+
+	cache.ReplaceAll([]KeyValueExp[int]{{Keys: []string{"replaced"}, Value: 1}})
+	if !cache.IsExist("replaced") || cache.bloom.Load().mayContain(serializeKey([]string{"present", "0"})) {
+		t.Error("expected ReplaceAll to rebuild the filter from the new content")
+	}
+}
+
+// BenchmarkParallelGet compares parallel reads of the default storage and WithSyncMapBackend.
+func BenchmarkParallelGet(b *testing.B) {
+	for _, tc := range []struct {
+		name    string
+		options []Option
+	}{
+		{name: "mutex"},
+		{name: "sync.Map", options: []Option{WithSyncMapBackend()}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			cache := New[int](tc.options...)
+			defer cache.Close()
+			keys := make([]string, 1000)
+			for i := range keys {
+				keys[i] = strconv.Itoa(i)
+				cache.Set(i, keys[i])
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var i int
+				for pb.Next() {
+					_, _ = cache.Get(keys[i%len(keys)])
+					i++
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkGetByType compares Get across value types. Values are stored as T rather than
+// boxed in an interface, so allocations do not depend on the value type.
+func BenchmarkGetByType(b *testing.B) {
+	keys := []string{"user", "42"}
+	b.Run("[]byte", func(b *testing.B) {
+		cache := New[[]byte]()
+		defer cache.Close()
+		cache.Set([]byte("value"), keys...)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, _ = cache.Get(keys...)
+		}
+	})
+	b.Run("string", func(b *testing.B) {
+		cache := New[string]()
+		defer cache.Close()
+		cache.Set("value", keys...)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, _ = cache.Get(keys...)
+		}
+	})
+	b.Run("int", func(b *testing.B) {
+		cache := New[int]()
+		defer cache.Close()
+		cache.Set(42, keys...)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, _ = cache.Get(keys...)
+		}
+	})
+}
+
+// TestWithRefreshAhead verifies that reading an entry about to expire reloads it in the background, once.
+func TestWithRefreshAhead(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	cache := New[string](WithRefreshAhead(time.Minute, func(keys []string) (string, time.Duration, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return "refreshed", time.Hour, nil
+	}))
+	defer cache.Close()
+
+	cache.SetWithExp("stale", time.Hour, "outside")
+	cache.SetWithExp("stale", 30*time.Second, "inside")
+
+	if data, err := cache.Get("outside"); err != nil || data != "stale" {
+		t.Errorf("expected %q, got: %q, %v", "stale", data, err)
+	}
+	for i := 0; i < 3; i++ {
+		// The reload is blocked, so Get must not wait for it.
+		if data, err := cache.Get("inside"); err != nil || data != "stale" {
+			t.Errorf("expected %q, got: %q, %v", "stale", data, err)
+		}
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if data, _ := cache.Peek("inside"); data == "refreshed" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the entry to be refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if ttl, err := cache.TTL("inside"); err != nil || ttl <= time.Minute {
+		t.Errorf("expected the refreshed TTL, got: %v, %v", ttl, err)
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("expected 1 reload, got: %d", n)
+	}
+}
+
+// gobPoint is a concrete type stored in a cache of interface values by TestExportGob.
+type gobPoint struct {
+	X, Y int
+}
+
+// TestExportGob verifies that a snapshot of interface values round-trips through encoding/gob
+// once their concrete types are registered.
+func TestExportGob(t *testing.T) {
+	gob.Register(gobPoint{})
+	src := New[any]()
+	defer src.Close()
+	src.Set(gobPoint{X: 1, Y: 2}, "point")
+	src.Set("text", "string")
+	src.SetWithExp(42, time.Hour, "int")
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(src.Export()); err != nil {
+		t.Fatalf("unexpected error on encode: %v", err)
+	}
+	var kvs []KeyValueExp[any]
+	if err := gob.NewDecoder(&buf).Decode(&kvs); err != nil {
+		t.Fatalf("unexpected error on decode: %v", err)
+	}
+	dst := New[any]()
+	defer dst.Close()
+	dst.Import(kvs, true)
+
+	for _, key := range []string{"point", "string", "int"} {
+		expected, _ := src.Get(key)
+		if value, err := dst.Get(key); err != nil || value != expected {
+			t.Errorf("expected %#v, got: %#v, %v", expected, value, err)
+		}
+	}
+	if ttl, _ := dst.TTL("int"); ttl <= 0 {
+		t.Errorf("expected the expiration to be preserved, got: %v", ttl)
+	}
+}
+
+// TestMerge verifies that Merge copies the items of another cache, keeping their expiration.
+func TestMerge(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		overwrite bool
+		expected  map[string]string
+	}{
+		{name: "keep", overwrite: false, expected: map[string]string{`["a"]`: "dst", `["b"]`: "dst", `["c"]`: "src"}},
+		{name: "overwrite", overwrite: true, expected: map[string]string{`["a"]`: "dst", `["b"]`: "src", `["c"]`: "src"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := New[string]()
+			defer dst.Close()
+			src := New[string]()
+			defer src.Close()
+			dst.Set("dst", "a")
+			dst.Set("dst", "b")
+			src.Set("src", "b")
+			src.SetWithExp("src", time.Hour, "c")
+			src.SetWithExp("src", time.Nanosecond, "expired")
+			time.Sleep(time.Millisecond)
+
+			dst.Merge(src, tt.overwrite)
+
+			if values := dst.GetsMap(); !reflect.DeepEqual(values, tt.expected) {
+				t.Errorf("expected %v, got: %v", tt.expected, values)
+			}
+			if ttl, err := dst.TTL("c"); err != nil || ttl <= 0 || ttl > time.Hour {
+				t.Errorf("expected the expiration to be kept, got: %v, %v", ttl, err)
+			}
+			if n := src.Len(); n != 3 {
+				t.Errorf("expected the source to be left unchanged, got: %d entries", n)
+			}
+		})
+	}
+}
+
+// TestMergeBothWays verifies that caches can be merged into each other concurrently.
+func TestMergeBothWays(t *testing.T) {
+	a, b := New[int](), New[int]()
+	defer a.Close()
+	defer b.Close()
+	a.Set(1, "a")
+	b.Set(2, "b")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() { defer wg.Done(); a.Merge(b, true) }()
+		go func() { defer wg.Done(); b.Merge(a, true) }()
+	}
+	wg.Wait()
+	if a.Len() != 2 || b.Len() != 2 {
+		t.Errorf("expected both caches to hold 2 entries, got: %d and %d", a.Len(), b.Len())
+	}
+}
+
+// BenchmarkGetExpiryCheck compares Get on entries with and without an expiration. Entries
+// without one skip reading the clock, so a cache that never uses TTLs pays no expiry check.
+func BenchmarkGetExpiryCheck(b *testing.B) {
+	for _, bm := range []struct {
+		name     string
+		duration time.Duration
+	}{
+		{name: "no expiration"},
+		{name: "expiration", duration: time.Hour},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			cache := New[int]()
+			defer cache.Close()
+			cache.SetWithExp(1, bm.duration, "key")
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = cache.Get("key")
+			}
+		})
+	}
+}
+
+// TestExpiredCount verifies that ExpiredCount counts the expired items not removed yet.
+func TestExpiredCount(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	cache.Set(1, "forever")
+	cache.SetWithExp(2, time.Hour, "long")
+	cache.SetWithExp(3, time.Nanosecond, "expired", "1")
+	cache.SetWithExp(4, time.Nanosecond, "expired", "2")
+	time.Sleep(time.Millisecond)
+
+	if n := cache.Len(); n != 4 {
+		t.Errorf("expected 4 items, got: %d", n)
+	}
+	if n := cache.ExpiredCount(); n != 2 {
+		t.Errorf("expected 2 expired items, got: %d", n)
+	}
+
+	_, _ = cache.Get("expired", "1") // removes the expired entry
+	if n := cache.ExpiredCount(); n != 1 {
+		t.Errorf("expected 1 expired item, got: %d", n)
+	}
+}
+
+// TestWithTimeToIdle verifies that entries expire once idle, and at their TTL even when read.
+func TestWithTimeToIdle(t *testing.T) {
+	cache := New[int](WithTimeToIdle(50 * time.Millisecond))
+	defer cache.Close()
+
+	cache.Set(1, "idle")
+	cache.SetWithExp(2, 150*time.Millisecond, "busy")
+	deadline := time.Now().Add(300 * time.Millisecond)
+	var hardExpired bool
+	for time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		if _, err := cache.Get("busy"); err != nil {
+			hardExpired = true
+			break
+		}
+	}
+	if !hardExpired {
+		t.Error("expected the continuously read item to expire at its TTL")
+	}
+	if _, err := cache.Get("idle"); !errors.Is(err, ErrExpired) && !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the unread item to expire once idle, got: %v", err)
+	}
+
+	cache.Set(3, "read")
+	time.Sleep(30 * time.Millisecond)
+	_, _ = cache.Get("read")
+	time.Sleep(30 * time.Millisecond)
+	if data, err := cache.Get("read"); err != nil || data != 3 {
+		t.Errorf("expected the read to extend the idle deadline, got: %d, %v", data, err)
+	}
+	if ttl, _ := cache.TTL("read"); ttl <= 0 || ttl > 50*time.Millisecond {
+		t.Errorf("expected the TTL to report the idle deadline, got: %v", ttl)
+	}
+}
+
+// TestAge verifies that Age reports the time since the item was stored, regardless of its expiration.
+func TestAge(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	if _, err := cache.Age("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+	cache.Set(1, "key")
+	time.Sleep(50 * time.Millisecond)
+	_ = cache.UpdateExp(time.Hour, "key")
+	_ = cache.Update(func(old int) int { return old + 1 }, "key")
+	age, err := cache.Age("key")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if age < 50*time.Millisecond || age > time.Second {
+		t.Errorf("expected an age of about 50ms, got: %v", age)
+	}
+
+	cache.Set(2, "key")
+	if age, _ := cache.Age("key"); age >= 50*time.Millisecond {
+		t.Errorf("expected the age to be reset by Set, got: %v", age)
+	}
+}
+
+// TestGetFirst verifies that GetFirst falls through to the first key holding a live item.
+func TestGetFirst(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	specific := []string{"cfg", "tenant", "key"}
+	fallback := []string{"cfg", "default", "key"}
+	cache.Set("default", fallback...)
+	data, matched, err := cache.GetFirst(specific, fallback)
+	if err != nil || data != "default" || !reflect.DeepEqual(matched, fallback) {
+		t.Errorf("expected default from %v, got: %s from %v, %v", fallback, data, matched, err)
+	}
+
+	cache.SetWithExp("expired", time.Nanosecond, specific...)
+	time.Sleep(time.Millisecond)
+	if data, _, _ := cache.GetFirst(specific, fallback); data != "default" {
+		t.Errorf("expected the expired override to be skipped, got: %s", data)
+	}
+
+	cache.Set("override", specific...)
+	data, matched, err = cache.GetFirst(specific, fallback)
+	if err != nil || data != "override" || !reflect.DeepEqual(matched, specific) {
+		t.Errorf("expected override from %v, got: %s from %v, %v", specific, data, matched, err)
+	}
+
+	if _, _, err := cache.GetFirst([]string{"missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+	var cacheErr *CacheError
+	_, _, err = cache.GetFirst([]string{"missing"}, []string{"other"})
+	if !errors.As(err, &cacheErr) || !reflect.DeepEqual(cacheErr.Keys, []string{"missing"}) {
+		t.Errorf("expected a CacheError for the first key, got: %v", err)
+	}
+}
+
+// TestTTLHistogram verifies that live items are counted under the smallest bucket covering
+// their remaining time.
+func TestTTLHistogram(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	cache.Set(1, "forever")
+	cache.SetWithExp(2, 30*time.Second, "short", "1")
+	cache.SetWithExp(3, 50*time.Second, "short", "2")
+	cache.SetWithExp(4, 30*time.Minute, "medium")
+	cache.SetWithExp(5, 48*time.Hour, "long")
+	cache.SetWithExp(6, time.Nanosecond, "expired")
+	time.Sleep(time.Millisecond)
+
+	hist := cache.TTLHistogram([]time.Duration{time.Hour, time.Minute, 10 * time.Second})
+	expected := map[time.Duration]int{
+		-1:               1,
+		10 * time.Second: 0,
+		time.Minute:      2,
+		time.Hour:        1,
+		math.MaxInt64:    1,
+	}
+	if !reflect.DeepEqual(hist, expected) {
+		t.Errorf("expected histogram: %v, got: %v", expected, hist)
+	}
+}
+
+// TestGetAs verifies that GetAs converts hits and passes errors through.
+func TestGetAs(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+	cache := New[user]()
+	defer cache.Close()
+	cache.Set(user{Name: "alice", Age: 30}, "user", "1")
+
+	age := func(u user) int { return u.Age }
+	if data, err := GetAs(cache, age, "user", "1"); err != nil || data != 30 {
+		t.Errorf("expected 30, got: %v, %v", data, err)
+	}
+	if data, err := GetAs(cache, age, "user", "2"); !errors.Is(err, ErrNotFound) || data != 0 {
+		t.Errorf("expected ErrNotFound and the zero value, got: %v, %v", data, err)
+	}
+}
+
+// TestEvictFraction verifies that EvictFraction removes the requested share of the items, least recently used first.
+func TestEvictFraction(t *testing.T) {
+	cache := New[int](WithKeyStats())
+	defer cache.Close()
+	for i := 0; i < 10; i++ {
+		cache.Set(i, strconv.Itoa(i))
+	}
+	// Read the odd items, so that the even ones are the least recently used.
+	for i := 1; i < 10; i += 2 {
+		_, _ = cache.Get(strconv.Itoa(i))
+	}
+
+	if n := cache.EvictFraction(0.5); n != 5 {
+		t.Errorf("expected 5 evictions, got: %d", n)
+	}
+	if n := cache.Len(); n != 5 {
+		t.Errorf("expected 5 remaining items, got: %d", n)
+	}
+	for i := 1; i < 10; i += 2 {
+		if !cache.IsExist(strconv.Itoa(i)) {
+			t.Errorf("expected recently used item %d to remain", i)
+		}
+	}
+	if evictions := cache.Stats().Evictions; evictions != 5 {
+		t.Errorf("expected 5 evictions in Stats, got: %d", evictions)
+	}
+
+	if n := cache.EvictFraction(0); n != 0 {
+		t.Errorf("expected no eviction, got: %d", n)
+	}
+	if n := cache.EvictFraction(2); n != 5 || cache.Len() != 0 {
+		t.Errorf("expected every item to be evicted, got: %d evictions, %d remaining", n, cache.Len())
+	}
+}
+
+// TestExpiresAt verifies that ExpiresAt returns the exact expiration time of an item.
+func TestExpiresAt(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	cache.SetWithExpireAt(1, exp, "expiring")
+	cache.Set(2, "forever")
+	cache.SetWithExp(3, time.Nanosecond, "expired")
+	time.Sleep(time.Millisecond)
+
+	if got, ok, err := cache.ExpiresAt("expiring"); err != nil || !ok || !got.Equal(exp) {
+		t.Errorf("expected %v, got: %v, %t, %v", exp, got, ok, err)
+	}
+	if got, ok, err := cache.ExpiresAt("forever"); err != nil || ok || !got.IsZero() {
+		t.Errorf("expected no expiration, got: %v, %t, %v", got, ok, err)
+	}
+	if _, _, err := cache.ExpiresAt("expired"); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got: %v", err)
+	}
+	if _, _, err := cache.ExpiresAt("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+// TestRangeUnlocked verifies that RangeUnlocked visits the unexpired items and stops when fn returns false.
+func TestRangeUnlocked(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+	for i := 0; i < 10; i++ {
+		cache.Set(i, strconv.Itoa(i))
+	}
+	cache.SetWithExp(-1, time.Nanosecond, "expired")
+	time.Sleep(time.Millisecond)
+
+	visited := make(map[string]int)
+	cache.RangeUnlocked(func(keys []string, value int) bool {
+		visited[keys[0]] = value
+		return true
+	})
+	if len(visited) != 10 {
+		t.Errorf("expected 10 items, got: %v", visited)
+	}
+	for key, value := range visited {
+		if key != strconv.Itoa(value) {
+			t.Errorf("expected key %q to hold %d, got: %d", key, value, value)
+		}
+	}
+
+	var calls int
+	cache.RangeUnlocked(func([]string, int) bool {
+		calls++
+		return calls < 3
+	})
+	if calls != 3 {
+		t.Errorf("expected the iteration to stop after 3 items, got: %d", calls)
+	}
+}
+
+// TestRangeUnlockedConcurrentWrites verifies that RangeUnlocked tolerates concurrent writes.
+func TestRangeUnlockedConcurrentWrites(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+	for i := 0; i < 100; i++ {
+		cache.Set(i, strconv.Itoa(i))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			key := strconv.Itoa(i % 200)
+			cache.Set(i, key)
+			_ = cache.Delete(strconv.Itoa((i + 50) % 200))
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		cache.RangeUnlocked(func(keys []string, value int) bool {
+			// fn runs without the lock, so it may call back into the cache.
+			_, _ = cache.Peek(keys...)
+			return true
+		})
+	}
+	<-done
+}
+
+// TestWithOnMiss verifies that the miss callback receives the key parts and reason of each miss.
+func TestWithOnMiss(t *testing.T) {
+	type miss struct {
+		keys   []string
+		reason error
+	}
+	var misses []miss
+	cache := New[int](WithOnMiss(func(keys []string, reason error) {
+		misses = append(misses, miss{keys: keys, reason: reason})
+	}))
+	defer cache.Close()
+	cache.Set(1, "present")
+	cache.SetWithExp(2, time.Nanosecond, "expired", "key")
+	time.Sleep(time.Millisecond)
+
+	_, _ = cache.Get("present")
+	_, _ = cache.Get("missing")
+	_, _ = cache.Get("expired", "key")
+	_, _ = cache.GetAndRefresh(time.Minute, "present")
+	_, _ = cache.GetAndRefresh(time.Minute, "missing")
+
+	expected := []miss{
+		{keys: []string{"missing"}, reason: ErrNotFound},
+		{keys: []string{"expired", "key"}, reason: ErrExpired},
+		{keys: []string{"missing"}, reason: ErrNotFound},
+	}
+	if !reflect.DeepEqual(misses, expected) {
+		t.Errorf("expected %v, got: %v", expected, misses)
+	}
+}
+
+// TestSetIfNewer verifies that SetIfNewer only keeps the data with the highest version.
+func TestSetIfNewer(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	for _, tt := range []struct {
+		data    string
+		version int64
+		stored  bool
+	}{
+		{data: "v2", version: 2, stored: true},
+		{data: "v1", version: 1, stored: false},
+		{data: "v3", version: 3, stored: true},
+		{data: "v3 again", version: 3, stored: false},
+		{data: "v0", version: 0, stored: false},
+	} {
+		if stored := cache.SetIfNewer(tt.data, tt.version, "key"); stored != tt.stored {
+			t.Errorf("expected SetIfNewer(%q, %d) to return %t, got: %t", tt.data, tt.version, tt.stored, stored)
+		}
+	}
+	if data, err := cache.Get("key"); err != nil || data != "v3" {
+		t.Errorf("expected %q, got: %q, %v", "v3", data, err)
+	}
+
+	_ = cache.Update(func(old string) string { return old + " updated" }, "key")
+	if cache.SetIfNewer("v3 stale", 3, "key") {
+		t.Error("expected Update to preserve the version")
+	}
+
+	cache.SetWithExp("expired", time.Nanosecond, "expired")
+	time.Sleep(time.Millisecond)
+	if !cache.SetIfNewer("v1", 1, "expired") {
+		t.Error("expected an expired item to count as absent")
+	}
+}
+
+// TestLoadFrom verifies that LoadFrom stores every item received until the channel is closed.
+func TestLoadFrom(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	ch := make(chan EntryWithExp[int])
+	go func() {
+		defer close(ch)
+		for i := 0; i < 1000; i++ {
+			ch <- EntryWithExp[int]{Keys: []string{strconv.Itoa(i)}, Data: i, Duration: time.Duration(i%2) * time.Hour}
+		}
+	}()
+	if err := cache.LoadFrom(context.Background(), ch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := cache.Len(); n != 1000 {
+		t.Errorf("expected 1000 items, got: %d", n)
+	}
+	if ttl, err := cache.TTL("1"); err != nil || ttl <= 0 {
+		t.Errorf("expected the item to expire, got: %v, %v", ttl, err)
+	}
+}
+
+// TestLoadFromCancel verifies that cancelling the context stops LoadFrom.
+func TestLoadFromCancel(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	ch := make(chan EntryWithExp[int], 1)
+	ch <- EntryWithExp[int]{Keys: []string{"first"}, Data: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() { errCh <- cache.LoadFrom(ctx, ch) }()
+
+	// Wait for the first item to be consumed before cancelling.
+	deadline := time.Now().Add(time.Second)
+	for !cache.IsExist("first") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+
+	ch <- EntryWithExp[int]{Keys: []string{"second"}, Data: 2}
+	if cache.IsExist("second") {
+		t.Error("expected no item to be consumed after cancellation")
+	}
+}
+
+// TestWithLoaderTimeout verifies that coalesced callers of a hung loader time out, and that the next call loads again.
+func TestWithLoaderTimeout(t *testing.T) {
+	cache := New[int](WithLoaderTimeout(20 * time.Millisecond))
+	defer cache.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	hung := func() (int, error) {
+		<-release
+		return 1, nil
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = cache.GetOrSet(hung, "key")
+		}(i)
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the callers to time out promptly, took: %v", elapsed)
+	}
+	for _, err := range errs {
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+		}
+	}
+
+	data, err := cache.GetOrSet(func() (int, error) { return 2, nil }, "key")
+	if err != nil || data != 2 {
+		t.Errorf("expected a new load to return 2, got: %v, %v", data, err)
+	}
+}
+
+// TestGetOrSetCtx verifies that GetOrSetCtx cancels the context of a timed out loader, and that
+// the late result of that loader is not stored.
+func TestGetOrSetCtx(t *testing.T) {
+	stored := make(chan int, 1)
+	cache := New[int](
+		WithLoaderTimeout(20*time.Millisecond),
+		WithOnSet(func(_ []string, value int, _ time.Duration) { stored <- value }),
+	)
+	defer cache.Close()
+
+	returned := make(chan error, 1)
+	_, err := cache.GetOrSetCtx(func(ctx context.Context) (int, error) {
+		defer func() { returned <- ctx.Err() }()
+		<-ctx.Done()
+		return 1, nil
+	}, "key")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+	if err := <-returned; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the loader context to be cancelled, got: %v", err)
+	}
+	select {
+	case value := <-stored:
+		t.Errorf("expected the late result not to be stored, got: %v", value)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	data, err := cache.GetOrSetCtx(func(ctx context.Context) (int, error) {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 2, nil
+	}, "key")
+	if err != nil || data != 2 {
+		t.Errorf("expected a new load to return 2, got: %v, %v", data, err)
+	}
+	if value := <-stored; value != 2 {
+		t.Errorf("expected 2 to be stored, got: %v", value)
+	}
+}
+
+// TestCompact verifies that Compact keeps the live items and removes the expired ones.
+func TestCompact(t *testing.T) {
+	var expirations int32
+	cache := New[int](WithOnExpire(func([]string, int) { atomic.AddInt32(&expirations, 1) }))
+	defer cache.Close()
+	for i := 0; i < 10000; i++ {
+		cache.Set(i, strconv.Itoa(i))
+	}
+	for i := 100; i < 10000; i++ {
+		_ = cache.Delete(strconv.Itoa(i))
+	}
+	cache.SetWithExp(-1, time.Nanosecond, "expired")
+	time.Sleep(time.Millisecond)
+
+	cache.Compact()
+
+	if n := cache.Len(); n != 100 {
+		t.Errorf("expected 100 items, got: %d", n)
+	}
+	if n := atomic.LoadInt32(&expirations); n != 1 {
+		t.Errorf("expected 1 expiration, got: %d", n)
+	}
+	for i := 0; i < 100; i++ {
+		if data, err := cache.Get(strconv.Itoa(i)); err != nil || data != i {
+			t.Fatalf("expected %d, got: %v, %v", i, data, err)
+		}
+	}
+}
+
+// TestGetOrDefault verifies that GetOrDefault returns the default on a miss or an expired item.
+func TestGetOrDefault(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+	cache.Set("cached", "hit")
+	cache.SetWithExp("stale", time.Nanosecond, "expired")
+	time.Sleep(time.Millisecond)
+
+	for _, tt := range []struct {
+		key      string
+		expected string
+	}{
+		{key: "hit", expected: "cached"},
+		{key: "missing", expected: "default"},
+		{key: "expired", expected: "default"},
+	} {
+		if data := cache.GetOrDefault("default", tt.key); data != tt.expected {
+			t.Errorf("expected %q for key %q, got: %q", tt.expected, tt.key, data)
+		}
+	}
+}
+
+// TestTryGet verifies that TryGet reports hits with true, and misses and expired items with
+// false and the zero value.
+func TestTryGet(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+	cache.Set("cached", "hit")
+	cache.SetWithExp("stale", time.Nanosecond, "expired")
+	time.Sleep(time.Millisecond)
+
+	for _, tt := range []struct {
+		key      string
+		expected string
+		ok       bool
+	}{
+		{key: "hit", expected: "cached", ok: true},
+		{key: "missing", expected: "", ok: false},
+		{key: "expired", expected: "", ok: false},
+	} {
+		if data, ok := cache.TryGet(tt.key); data != tt.expected || ok != tt.ok {
+			t.Errorf("expected %q, %v for key %q, got: %q, %v", tt.expected, tt.ok, tt.key, data, ok)
+		}
+	}
+}
+
+// TestCleanupYieldsLock verifies that the cleanup of a large expired set releases the lock
+// between chunks, so that other operations can run during the sweep.
+func TestCleanupYieldsLock(t *testing.T) {
+	const n = 10 * cleanupChunkSize
+	cache := New[int]().(*bmemCache[int])
+	defer cache.Close()
+	entries := make([]EntryWithExp[int], n)
+	for i := range entries {
+		entries[i] = EntryWithExp[int]{Keys: []string{strconv.Itoa(i)}, Data: i, Duration: time.Nanosecond}
+	}
+	cache.SetManyWithExp(entries)
+	cache.Set(-1, "live")
+	time.Sleep(time.Millisecond)
+
+	var yields int
+	cache.cleanupYield = func() {
+		yields++
+		if !cache.mu.TryLock() {
+			t.Errorf("expected the lock to be released after chunk %d", yields)
+			return
+		}
+		cache.mu.Unlock()
+		if data, err := cache.Peek("live"); err != nil || data != -1 {
+			t.Errorf("expected reads to proceed between chunks, got: %v, %v", data, err)
+		}
+	}
+	cache.cleanup()
+
+	if n := cache.Len(); n != 1 {
+		t.Errorf("expected 1 item after the cleanup, got: %d", n)
+	}
+	if want := (n + 1) / cleanupChunkSize; yields != want {
+		t.Errorf("expected the lock to be released %d times, got: %d", want, yields)
+	}
+}
+
+// TestKeysRoundTrip verifies that Keys returns the exact key parts given to Set, whatever characters they contain.
+func TestKeysRoundTrip(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	expected := [][]string{
+		{"a:b", "c"},
+		{"a", "b:c"},
+		{"a::b", "::", ":"},
+		{`"quoted"`, `back\slash`, "[bracket]", "comma,", ""},
+		{"unicode ключ", "🔑"},
+	}
+	for i, keys := range expected {
+		cache.Set(i, keys...)
+	}
+	if keys := cache.KeysSorted(); !reflect.DeepEqual(keys, sortedKeys(expected)) {
+		t.Errorf("expected %q, got: %q", expected, keys)
+	}
+	for i, keys := range expected {
+		if data, err := cache.Get(keys...); err != nil || data != i {
+			t.Errorf("expected %d for %q, got: %v, %v", i, keys, data, err)
+		}
+	}
+}
+
+// TestKeyStrings verifies that KeyStrings returns the JSON-serialized form of every stored key.
+func TestKeyStrings(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	cache.Set(1, "user", "42")
+	cache.Set(2, "a:b", "c")
+	cache.Set(3, "a", "b:c")
+	expected := []string{`["a","b:c"]`, `["a:b","c"]`, `["user","42"]`}
+	keys := cache.KeyStrings()
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %q, got: %q", expected, keys)
+	}
+	for _, key := range keys {
+		var parts []string
+		if err := json.Unmarshal([]byte(key), &parts); err != nil || !cache.IsExist(parts...) {
+			t.Errorf("expected %s to decode to stored key parts, got: %q, %v", key, parts, err)
+		}
+	}
+}
+
+// TestSetAndKey verifies that SetAndKey and SetWithExpAndKey return the key listed by KeyStrings.
+func TestSetAndKey(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+
+	key := cache.SetAndKey(1, "user", "42")
+	if keys := cache.KeyStrings(); len(keys) != 1 || keys[0] != key {
+		t.Errorf("expected %q, got: %q", key, keys)
+	}
+	_ = cache.Delete("user", "42")
+
+	key = cache.SetWithExpAndKey(2, time.Hour, "a:b", "c")
+	if keys := cache.KeyStrings(); len(keys) != 1 || keys[0] != key {
+		t.Errorf("expected %q, got: %q", key, keys)
+	}
+	if ttl, _ := cache.TTL("a:b", "c"); ttl <= 0 {
+		t.Errorf("expected an expiration, got: %v", ttl)
+	}
+}
+
+// sortedKeys returns a copy of keys in the order of KeysSorted.
+func sortedKeys(keys [][]string) [][]string {
+	sorted := append([][]string(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool { return lessKey(sorted[i], sorted[j]) })
+	return sorted
+}
+
+// TestAll verifies that All and AllKeys iterate over the unexpired items and stop on break.
+func TestAll(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+	for i := 0; i < 10; i++ {
+		cache.Set(i, "key", strconv.Itoa(i))
+	}
+	cache.SetWithExp(-1, time.Nanosecond, "expired")
+	time.Sleep(time.Millisecond)
+
+	values := make(map[string]int)
+	for keys, value := range cache.All() {
+		values[keys[1]] = value
+	}
+	if len(values) != 10 {
+		t.Errorf("expected 10 items, got: %v", values)
+	}
+	for key, value := range values {
+		if key != strconv.Itoa(value) {
+			t.Errorf("expected key %q to hold %d, got: %d", key, value, value)
+		}
+	}
+
+	var keys [][]string
+	for k := range cache.AllKeys() {
+		keys = append(keys, k)
+	}
+	if len(keys) != 10 {
+		t.Errorf("expected 10 keys, got: %v", keys)
+	}
+
+	var n int
+	for range cache.All() {
+		if n++; n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Errorf("expected the iteration to stop after 3 items, got: %d", n)
+	}
+}
+
+// TestGetTimeoutReporting verifies that GetTimeout reports its hits and misses like Get does.
+func TestGetTimeoutReporting(t *testing.T) {
+	var misses []error
+	var observed []Op
+	cache := New[string](
+		WithOnMiss(func(keys []string, reason error) { misses = append(misses, reason) }),
+		WithObserver(func(ev Observation[string]) { observed = append(observed, ev.Op) }),
+	)
+	defer cache.Close()
+	cache.Set("value", "key")
+	cache.SetWithExp("stale", time.Nanosecond, "expired")
+	time.Sleep(time.Millisecond)
+	observed = nil
+
+	_, _ = cache.GetTimeout(time.Second, "key")
+	_, _ = cache.GetTimeout(time.Second, "missing")
+	_, _ = cache.GetTimeout(time.Second, "expired")
+	if len(misses) != 2 || misses[0] != ErrNotFound || misses[1] != ErrExpired {
+		t.Errorf("expected ErrNotFound and ErrExpired misses, got: %v", misses)
+	}
+	if len(observed) != 3 || observed[0] != OpGet || observed[1] != OpMiss || observed[2] != OpMiss {
+		t.Errorf("expected a hit and two misses to be observed, got: %v", observed)
+	}
+}
+
+// TestGetTimeout verifies that GetTimeout gives up while the write lock is held, and reads normally otherwise.
+func TestGetTimeout(t *testing.T) {
+	cache := New[int]()
+	defer cache.Close()
+	cache.Set(1, "key")
+
+	if data, err := cache.GetTimeout(time.Second, "key"); err != nil || data != 1 {
+		t.Errorf("expected 1, got: %v, %v", data, err)
+	}
+	if _, err := cache.GetTimeout(time.Second, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+
+	mu := &cache.(*bmemCache[int]).mu
+	mu.Lock()
+	start := time.Now()
+	_, err := cache.GetTimeout(20*time.Millisecond, "key")
+	elapsed := time.Since(start)
+	mu.Unlock()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+	if elapsed < 20*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected to give up after about 20ms, took: %v", elapsed)
+	}
+}
+
+// TestWithObserver verifies that the observer receives an observation with the expected fields for each operation.
+func TestWithObserver(t *testing.T) {
+	var observations []Observation[int]
+	cache := New[int](WithObserver(func(ev Observation[int]) {
+		ev.TTL = ev.TTL.Round(time.Hour) // the remaining TTL decreases while the test runs
+		observations = append(observations, ev)
+	}))
+	defer cache.Close()
+
+	cache.SetWithExp(1, time.Hour, "a")
+	_, _ = cache.Get("a")
+	_, _ = cache.Get("missing")
+	_ = cache.Delete("a")
+	cache.SetWithExp(2, time.Nanosecond, "b")
+	time.Sleep(time.Millisecond)
+	_, _ = cache.Get("b")
+	cache.Set(3, "c")
+	cache.EvictFraction(1)
+	cache.Clear()
+
+	expected := []Observation[int]{
+		{Op: OpSet, Keys: []string{"a"}, Value: 1, TTL: time.Hour},
+		{Op: OpGet, Keys: []string{"a"}, Value: 1, TTL: time.Hour},
+		{Op: OpMiss, Keys: []string{"missing"}, Err: ErrNotFound},
+		{Op: OpDelete, Keys: []string{"a"}, Value: 1},
+		{Op: OpSet, Keys: []string{"b"}, Value: 2},
+		{Op: OpExpire, Keys: []string{"b"}, Value: 2},
+		{Op: OpMiss, Keys: []string{"b"}, Err: ErrExpired},
+		{Op: OpSet, Keys: []string{"c"}, Value: 3},
+		{Op: OpEvict, Keys: []string{"c"}, Value: 3},
+		{Op: OpClear},
+	}
+	if !reflect.DeepEqual(observations, expected) {
+		t.Errorf("expected %+v, got: %+v", expected, observations)
+	}
+	if s := OpEvict.String(); s != "Evict" {
+		t.Errorf("expected %q, got: %q", "Evict", s)
+	}
+}
+
+// TestEntriesFromPrefix verifies that EntriesFromPrefix pairs each matching value with its key parts.
+func TestEntriesFromPrefix(t *testing.T) {
+	cache := New[string]()
+	defer cache.Close()
+
+	if _, err := cache.EntriesFromPrefix("user"); !errors.Is(err, ErrEmpty) {
+		t.Errorf("expected ErrEmpty, got: %v", err)
+	}
+	cache.Set("alice", "user", "1")
+	cache.Set("bob", "user", "2")
+	cache.Set("admin", "group", "1")
+
+	entries, err := cache.EntriesFromPrefix("user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return lessKey(entries[i].Keys, entries[j].Keys) })
+	expected := []PrefixEntry[string]{
+		{Keys: []string{"user", "1"}, Value: "alice"},
+		{Keys: []string{"user", "2"}, Value: "bob"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %v, got: %v", expected, entries)
+	}
+	if _, err := cache.EntriesFromPrefix("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+// TestWithLoaderConcurrency verifies that refresh-ahead reloads beyond the limit are skipped.
+func TestWithLoaderConcurrency(t *testing.T) {
+	const limit = 2
+	var running, maxRunning int32
+	release := make(chan struct{})
+	cache := New[int](
+		WithLoaderConcurrency(limit),
+		WithRefreshAhead(time.Minute, func(keys []string) (int, time.Duration, error) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				if m := atomic.LoadInt32(&maxRunning); n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			<-release
+			atomic.AddInt32(&running, -1)
+			return 0, time.Hour, nil
+		}),
+	)
+	defer cache.Close()
+
+	for i := 0; i < 20; i++ {
+		cache.SetWithExp(i, 30*time.Second, strconv.Itoa(i))
+	}
+	for i := 0; i < 20; i++ {
+		_, _ = cache.Get(strconv.Itoa(i))
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&running); n != limit {
+		t.Errorf("expected %d running reloads, got: %d", limit, n)
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&running) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&maxRunning); n > limit {
+		t.Errorf("expected at most %d concurrent reloads, got: %d", limit, n)
+	}
+}
+
+// TestWithIndex verifies that LookupByIndex finds items by an indexed field, and that the index
+// follows overwrites, deletions and expirations.
+func TestWithIndex(t *testing.T) {
+	type user struct {
+		ID    string
+		Email string
+	}
+	cache := New[user](WithIndex("email", func(u user) string { return u.Email }))
+	defer cache.Close()
+
+	cache.Set(user{ID: "1", Email: "a@example.com"}, "user", "1")
+	cache.Set(user{ID: "2", Email: "b@example.com"}, "user", "2")
+	cache.Set(user{ID: "3", Email: "b@example.com"}, "user", "3")
+
+	users, err := cache.LookupByIndex("email", "b@example.com")
+	if err != nil || len(users) != 2 || users[0].ID != "2" || users[1].ID != "3" {
+		t.Errorf("expected users 2 and 3, got: %v, %v", users, err)
+	}
+
+	cache.Set(user{ID: "2", Email: "c@example.com"}, "user", "2")
+	if users, err := cache.LookupByIndex("email", "b@example.com"); err != nil || len(users) != 1 || users[0].ID != "3" {
+		t.Errorf("expected user 3 after overwrite, got: %v, %v", users, err)
+	}
+	if users, err := cache.LookupByIndex("email", "c@example.com"); err != nil || len(users) != 1 || users[0].ID != "2" {
+		t.Errorf("expected user 2 under its new email, got: %v, %v", users, err)
+	}
+
+	_ = cache.Delete("user", "1")
+	if _, err := cache.LookupByIndex("email", "a@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got: %v", err)
+	}
+
+	cache.SetWithExp(user{ID: "4", Email: "d@example.com"}, time.Millisecond, "user", "4")
+	time.Sleep(5 * time.Millisecond)
+	if _, err := cache.LookupByIndex("email", "d@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after expiration, got: %v", err)
+	}
+	cache.Compact()
+	index := cache.(*bmemCache[user]).secondaryIndex("email")
+	if _, ok := index.keys["d@example.com"]; ok {
+		t.Error("expected the expired item to be removed from the index")
+	}
+	if _, ok := index.keys["a@example.com"]; ok {
+		t.Error("expected the deleted item to be removed from the index")
+	}
+
+	if _, err := cache.LookupByIndex("name", "x"); !errors.Is(err, ErrUnknownIndex) {
+		t.Errorf("expected ErrUnknownIndex, got: %v", err)
+	}
+}
@@ -0,0 +1,642 @@
+// Package bmemcacheotel instruments a bmemcache.BMemCache with OpenTelemetry spans and metrics.
+//
+// It lives in its own module so the core bmemcache package stays free of dependencies.
+package bmemcacheotel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"time"
+
+	"github.com/bearaujus/bmemcache"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// spanPrefix prefixes the name of every span, e.g. "bmemcache.Get".
+	spanPrefix = "bmemcache."
+
+	// durationMetric is the name of the histogram recording operation latencies.
+	durationMetric = "bmemcache.operation.duration"
+)
+
+var (
+	// keyDepthKey is the span attribute holding the number of key parts of an operation.
+	// Key values themselves are never recorded to avoid high cardinality and leaking PII.
+	keyDepthKey = attribute.Key("bmemcache.key_depth")
+
+	// hitKey is the span attribute reporting whether a lookup found an unexpired item.
+	hitKey = attribute.Key("bmemcache.hit")
+
+	// operationKey is the metric attribute holding the operation name.
+	operationKey = attribute.Key("bmemcache.operation")
+
+	// nameKey is the span and metric attribute holding the cache name set with bmemcache.WithName.
+	nameKey = attribute.Key("bmemcache.name")
+)
+
+// NewInstrumented wraps a cache so that every operation emits a span and records its
+// latency in the "bmemcache.operation.duration" histogram. Only the accessors Len, Size,
+// Stats, ResetStats, Name and String are left uninstrumented.
+//
+// Spans are named after the operation (e.g. "bmemcache.Get") and carry the key depth and,
+// for lookups, whether the item was found. When the cache has a name, both spans and
+// latencies carry it as the "bmemcache.name" attribute. Since BMemCache methods take no
+// context, spans are started from context.Background() and therefore appear as root spans.
+// The spans of All and AllKeys cover the iteration rather than the creation of the iterator.
+//
+// Every call, including Close, is delegated to the wrapped cache.
+//
+// Parameters:
+//   - c: The cache to instrument.
+//   - tracer: The tracer used to start spans.
+//   - meter: The meter used to create the latency histogram.
+//
+// Returns:
+//   - A BMemCache instance instrumenting c.
+func NewInstrumented[T any](c bmemcache.BMemCache[T], tracer trace.Tracer, meter metric.Meter) bmemcache.BMemCache[T] {
+	duration, err := meter.Float64Histogram(
+		durationMetric,
+		metric.WithDescription("Duration of bmemcache operations."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		// Latencies are best effort: tracing keeps working without them.
+		duration = noop.Float64Histogram{}
+	}
+	var attrs []attribute.KeyValue
+	if name := c.Name(); name != "" {
+		attrs = append(attrs, nameKey.String(name))
+	}
+	return &instrumented[T]{BMemCache: c, tracer: tracer, duration: duration, attrs: attrs}
+}
+
+// instrumented embeds the wrapped cache so that every method is delegated, including
+// methods it does not instrument explicitly.
+type instrumented[T any] struct {
+	bmemcache.BMemCache[T]
+	tracer   trace.Tracer
+	duration metric.Float64Histogram
+	attrs    []attribute.KeyValue // Attributes shared by every span and latency.
+}
+
+// observe runs fn inside a span named after op and records its duration.
+func (c *instrumented[T]) observe(op string, depth int, fn func(span trace.Span)) {
+	ctx, span := c.tracer.Start(context.Background(), spanPrefix+op,
+		trace.WithAttributes(c.attrs...), trace.WithAttributes(keyDepthKey.Int(depth)))
+	start := time.Now()
+	fn(span)
+	c.duration.Record(ctx, time.Since(start).Seconds(),
+		metric.WithAttributes(c.attrs...), metric.WithAttributes(operationKey.String(op)))
+	span.End()
+}
+
+// lookup records the outcome of a lookup on span: misses are not errors, anything else is.
+func lookup(span trace.Span, err error) {
+	span.SetAttributes(hitKey.Bool(err == nil))
+	if err != nil && !errors.Is(err, bmemcache.ErrNotFound) && !errors.Is(err, bmemcache.ErrExpired) && !errors.Is(err, bmemcache.ErrEmpty) {
+		fail(span, err)
+	}
+}
+
+// fail marks span as failed with err, if any.
+func fail(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (c *instrumented[T]) Set(data T, keys ...string) {
+	c.observe("Set", len(keys), func(trace.Span) {
+		c.BMemCache.Set(data, keys...)
+	})
+}
+
+func (c *instrumented[T]) SetAndKey(data T, keys ...string) (key string) {
+	c.observe("SetAndKey", len(keys), func(trace.Span) {
+		key = c.BMemCache.SetAndKey(data, keys...)
+	})
+	return key
+}
+
+func (c *instrumented[T]) TrySet(data T, keys ...string) (err error) {
+	c.observe("TrySet", len(keys), func(span trace.Span) {
+		err = c.BMemCache.TrySet(data, keys...)
+		fail(span, err)
+	})
+	return err
+}
+
+func (c *instrumented[T]) Get(keys ...string) (data T, err error) {
+	c.observe("Get", len(keys), func(span trace.Span) {
+		data, err = c.BMemCache.Get(keys...)
+		lookup(span, err)
+	})
+	return data, err
+}
+
+func (c *instrumented[T]) GetOrDefault(def T, keys ...string) (data T) {
+	c.observe("GetOrDefault", len(keys), func(span trace.Span) {
+		// TryGet fails in the same cases as GetOrDefault, but also tells whether it was a hit.
+		var ok bool
+		if data, ok = c.BMemCache.TryGet(keys...); !ok {
+			data = def
+		}
+		span.SetAttributes(hitKey.Bool(ok))
+	})
+	return data
+}
+
+func (c *instrumented[T]) TryGet(keys ...string) (data T, ok bool) {
+	c.observe("TryGet", len(keys), func(span trace.Span) {
+		data, ok = c.BMemCache.TryGet(keys...)
+		span.SetAttributes(hitKey.Bool(ok))
+	})
+	return data, ok
+}
+
+func (c *instrumented[T]) GetTimeout(timeout time.Duration, keys ...string) (data T, err error) {
+	c.observe("GetTimeout", len(keys), func(span trace.Span) {
+		data, err = c.BMemCache.GetTimeout(timeout, keys...)
+		lookup(span, err)
+	})
+	return data, err
+}
+
+func (c *instrumented[T]) GetFirst(keyGroups ...[]string) (data T, matched []string, err error) {
+	// The key groups may differ in depth, so none is recorded.
+	c.observe("GetFirst", 0, func(span trace.Span) {
+		data, matched, err = c.BMemCache.GetFirst(keyGroups...)
+		lookup(span, err)
+	})
+	return data, matched, err
+}
+
+func (c *instrumented[T]) Peek(keys ...string) (data T, err error) {
+	c.observe("Peek", len(keys), func(span trace.Span) {
+		data, err = c.BMemCache.Peek(keys...)
+		lookup(span, err)
+	})
+	return data, err
+}
+
+func (c *instrumented[T]) GetStale(keys ...string) (data T, stale bool, err error) {
+	c.observe("GetStale", len(keys), func(span trace.Span) {
+		data, stale, err = c.BMemCache.GetStale(keys...)
+		lookup(span, err)
+	})
+	return data, stale, err
+}
+
+func (c *instrumented[T]) GetAndRefresh(duration time.Duration, keys ...string) (data T, err error) {
+	c.observe("GetAndRefresh", len(keys), func(span trace.Span) {
+		data, err = c.BMemCache.GetAndRefresh(duration, keys...)
+		lookup(span, err)
+	})
+	return data, err
+}
+
+func (c *instrumented[T]) GetOrSet(loader func() (T, error), keys ...string) (data T, err error) {
+	c.observe("GetOrSet", len(keys), func(span trace.Span) {
+		data, err = c.BMemCache.GetOrSet(loader, keys...)
+		fail(span, err)
+	})
+	return data, err
+}
+
+func (c *instrumented[T]) GetOrSetWithExp(loader func() (T, error), duration time.Duration, keys ...string) (data T, err error) {
+	c.observe("GetOrSetWithExp", len(keys), func(span trace.Span) {
+		data, err = c.BMemCache.GetOrSetWithExp(loader, duration, keys...)
+		fail(span, err)
+	})
+	return data, err
+}
+
+func (c *instrumented[T]) GetOrSetCtx(loader func(ctx context.Context) (T, error), keys ...string) (data T, err error) {
+	c.observe("GetOrSetCtx", len(keys), func(span trace.Span) {
+		data, err = c.BMemCache.GetOrSetCtx(loader, keys...)
+		fail(span, err)
+	})
+	return data, err
+}
+
+func (c *instrumented[T]) WaitGet(ctx context.Context, keys ...string) (data T, err error) {
+	c.observe("WaitGet", len(keys), func(span trace.Span) {
+		data, err = c.BMemCache.WaitGet(ctx, keys...)
+		lookup(span, err)
+	})
+	return data, err
+}
+
+func (c *instrumented[T]) Gets() (data []T, err error) {
+	c.observe("Gets", 0, func(span trace.Span) {
+		data, err = c.BMemCache.Gets()
+		lookup(span, err)
+	})
+	return data, err
+}
+
+func (c *instrumented[T]) GetsFromPrefix(keys ...string) (data []T, err error) {
+	c.observe("GetsFromPrefix", len(keys), func(span trace.Span) {
+		data, err = c.BMemCache.GetsFromPrefix(keys...)
+		lookup(span, err)
+	})
+	return data, err
+}
+
+func (c *instrumented[T]) GetsFromPrefixPage(limit int, cursor string, keys ...string) (values []T, nextCursor string, err error) {
+	c.observe("GetsFromPrefixPage", len(keys), func(span trace.Span) {
+		values, nextCursor, err = c.BMemCache.GetsFromPrefixPage(limit, cursor, keys...)
+		lookup(span, err)
+	})
+	return values, nextCursor, err
+}
+
+func (c *instrumented[T]) EntriesFromPrefix(keys ...string) (entries []bmemcache.PrefixEntry[T], err error) {
+	c.observe("EntriesFromPrefix", len(keys), func(span trace.Span) {
+		entries, err = c.BMemCache.EntriesFromPrefix(keys...)
+		lookup(span, err)
+	})
+	return entries, err
+}
+
+func (c *instrumented[T]) GetsMap() (data map[string]T) {
+	c.observe("GetsMap", 0, func(trace.Span) {
+		data = c.BMemCache.GetsMap()
+	})
+	return data
+}
+
+func (c *instrumented[T]) LookupByIndex(name, fieldValue string) (data []T, err error) {
+	c.observe("LookupByIndex", 0, func(span trace.Span) {
+		data, err = c.BMemCache.LookupByIndex(name, fieldValue)
+		lookup(span, err)
+	})
+	return data, err
+}
+
+func (c *instrumented[T]) Filter(pred func(keys []string, value T) bool) (data []T) {
+	c.observe("Filter", 0, func(trace.Span) {
+		data = c.BMemCache.Filter(pred)
+	})
+	return data
+}
+
+func (c *instrumented[T]) FilterKeys(pred func(keys []string, value T) bool) (keys [][]string) {
+	c.observe("FilterKeys", 0, func(trace.Span) {
+		keys = c.BMemCache.FilterKeys(pred)
+	})
+	return keys
+}
+
+func (c *instrumented[T]) RangeUnlocked(fn func(keys []string, value T) bool) {
+	c.observe("RangeUnlocked", 0, func(trace.Span) {
+		c.BMemCache.RangeUnlocked(fn)
+	})
+}
+
+func (c *instrumented[T]) All() iter.Seq2[[]string, T] {
+	all := c.BMemCache.All()
+	return func(yield func([]string, T) bool) {
+		c.observe("All", 0, func(trace.Span) {
+			all(yield)
+		})
+	}
+}
+
+func (c *instrumented[T]) AllKeys() iter.Seq[[]string] {
+	all := c.BMemCache.AllKeys()
+	return func(yield func([]string) bool) {
+		c.observe("AllKeys", 0, func(trace.Span) {
+			all(yield)
+		})
+	}
+}
+
+func (c *instrumented[T]) GetSet(data T, keys ...string) (old T, existed bool) {
+	c.observe("GetSet", len(keys), func(span trace.Span) {
+		old, existed = c.BMemCache.GetSet(data, keys...)
+		span.SetAttributes(hitKey.Bool(existed))
+	})
+	return old, existed
+}
+
+func (c *instrumented[T]) SwapWithExp(data T, duration time.Duration, keys ...string) (old T, existed bool) {
+	c.observe("SwapWithExp", len(keys), func(span trace.Span) {
+		old, existed = c.BMemCache.SwapWithExp(data, duration, keys...)
+		span.SetAttributes(hitKey.Bool(existed))
+	})
+	return old, existed
+}
+
+func (c *instrumented[T]) SetIfNewer(data T, version int64, keys ...string) (stored bool) {
+	c.observe("SetIfNewer", len(keys), func(trace.Span) {
+		stored = c.BMemCache.SetIfNewer(data, version, keys...)
+	})
+	return stored
+}
+
+func (c *instrumented[T]) Update(fn func(old T) T, keys ...string) (err error) {
+	c.observe("Update", len(keys), func(span trace.Span) {
+		err = c.BMemCache.Update(fn, keys...)
+		lookup(span, err)
+	})
+	return err
+}
+
+func (c *instrumented[T]) Delete(keys ...string) (err error) {
+	c.observe("Delete", len(keys), func(span trace.Span) {
+		err = c.BMemCache.Delete(keys...)
+		lookup(span, err)
+	})
+	return err
+}
+
+func (c *instrumented[T]) DeleteMany(keyGroups [][]string) (n int) {
+	c.observe("DeleteMany", 0, func(trace.Span) {
+		n = c.BMemCache.DeleteMany(keyGroups)
+	})
+	return n
+}
+
+func (c *instrumented[T]) Keys() (keys [][]string) {
+	c.observe("Keys", 0, func(trace.Span) {
+		keys = c.BMemCache.Keys()
+	})
+	return keys
+}
+
+func (c *instrumented[T]) KeysFromPrefix(keys ...string) (matches [][]string) {
+	c.observe("KeysFromPrefix", len(keys), func(trace.Span) {
+		matches = c.BMemCache.KeysFromPrefix(keys...)
+	})
+	return matches
+}
+
+func (c *instrumented[T]) KeysFromSuffix(keys ...string) (matches [][]string) {
+	c.observe("KeysFromSuffix", len(keys), func(trace.Span) {
+		matches = c.BMemCache.KeysFromSuffix(keys...)
+	})
+	return matches
+}
+
+func (c *instrumented[T]) KeyStrings() (keys []string) {
+	c.observe("KeyStrings", 0, func(trace.Span) {
+		keys = c.BMemCache.KeyStrings()
+	})
+	return keys
+}
+
+func (c *instrumented[T]) KeysSorted() (keys [][]string) {
+	c.observe("KeysSorted", 0, func(trace.Span) {
+		keys = c.BMemCache.KeysSorted()
+	})
+	return keys
+}
+
+func (c *instrumented[T]) KeysPage(limit int, cursor string) (keys [][]string, nextCursor string) {
+	c.observe("KeysPage", 0, func(trace.Span) {
+		keys, nextCursor = c.BMemCache.KeysPage(limit, cursor)
+	})
+	return keys, nextCursor
+}
+
+func (c *instrumented[T]) SetWithExp(data T, duration time.Duration, keys ...string) {
+	c.observe("SetWithExp", len(keys), func(trace.Span) {
+		c.BMemCache.SetWithExp(data, duration, keys...)
+	})
+}
+
+func (c *instrumented[T]) SetWithExpAndKey(data T, duration time.Duration, keys ...string) (key string) {
+	c.observe("SetWithExpAndKey", len(keys), func(trace.Span) {
+		key = c.BMemCache.SetWithExpAndKey(data, duration, keys...)
+	})
+	return key
+}
+
+func (c *instrumented[T]) SetManyWithExp(entries []bmemcache.EntryWithExp[T]) {
+	c.observe("SetManyWithExp", 0, func(trace.Span) {
+		c.BMemCache.SetManyWithExp(entries)
+	})
+}
+
+func (c *instrumented[T]) LoadFrom(ctx context.Context, ch <-chan bmemcache.EntryWithExp[T]) (err error) {
+	c.observe("LoadFrom", 0, func(span trace.Span) {
+		err = c.BMemCache.LoadFrom(ctx, ch)
+		fail(span, err)
+	})
+	return err
+}
+
+func (c *instrumented[T]) Export() (kvs []bmemcache.KeyValueExp[T]) {
+	c.observe("Export", 0, func(trace.Span) {
+		kvs = c.BMemCache.Export()
+	})
+	return kvs
+}
+
+func (c *instrumented[T]) Import(kvs []bmemcache.KeyValueExp[T], overwrite bool) {
+	c.observe("Import", 0, func(trace.Span) {
+		c.BMemCache.Import(kvs, overwrite)
+	})
+}
+
+func (c *instrumented[T]) Merge(other bmemcache.BMemCache[T], overwrite bool) {
+	c.observe("Merge", 0, func(trace.Span) {
+		c.BMemCache.Merge(other, overwrite)
+	})
+}
+
+func (c *instrumented[T]) ReplaceAll(kvs []bmemcache.KeyValueExp[T]) {
+	c.observe("ReplaceAll", 0, func(trace.Span) {
+		c.BMemCache.ReplaceAll(kvs)
+	})
+}
+
+func (c *instrumented[T]) Replay(r io.Reader) (err error) {
+	c.observe("Replay", 0, func(span trace.Span) {
+		err = c.BMemCache.Replay(r)
+		fail(span, err)
+	})
+	return err
+}
+
+func (c *instrumented[T]) TransformAll(fn func(keys []string, old T) T) {
+	c.observe("TransformAll", 0, func(trace.Span) {
+		c.BMemCache.TransformAll(fn)
+	})
+}
+
+func (c *instrumented[T]) TrySetWithExp(data T, duration time.Duration, keys ...string) (err error) {
+	c.observe("TrySetWithExp", len(keys), func(span trace.Span) {
+		err = c.BMemCache.TrySetWithExp(data, duration, keys...)
+		fail(span, err)
+	})
+	return err
+}
+
+func (c *instrumented[T]) TouchPrefix(duration time.Duration, keys ...string) (n int) {
+	c.observe("TouchPrefix", len(keys), func(trace.Span) {
+		n = c.BMemCache.TouchPrefix(duration, keys...)
+	})
+	return n
+}
+
+func (c *instrumented[T]) SetWithExpireAt(data T, t time.Time, keys ...string) {
+	c.observe("SetWithExpireAt", len(keys), func(trace.Span) {
+		c.BMemCache.SetWithExpireAt(data, t, keys...)
+	})
+}
+
+func (c *instrumented[T]) ExpireAt(t time.Time, keys ...string) (err error) {
+	c.observe("ExpireAt", len(keys), func(span trace.Span) {
+		err = c.BMemCache.ExpireAt(t, keys...)
+		lookup(span, err)
+	})
+	return err
+}
+
+func (c *instrumented[T]) UpdateExp(duration time.Duration, keys ...string) (err error) {
+	c.observe("UpdateExp", len(keys), func(span trace.Span) {
+		err = c.BMemCache.UpdateExp(duration, keys...)
+		lookup(span, err)
+	})
+	return err
+}
+
+func (c *instrumented[T]) IsExist(keys ...string) (ok bool) {
+	c.observe("IsExist", len(keys), func(span trace.Span) {
+		ok = c.BMemCache.IsExist(keys...)
+		span.SetAttributes(hitKey.Bool(ok))
+	})
+	return ok
+}
+
+func (c *instrumented[T]) IsValid(keys ...string) (ok bool) {
+	c.observe("IsValid", len(keys), func(span trace.Span) {
+		ok = c.BMemCache.IsValid(keys...)
+		span.SetAttributes(hitKey.Bool(ok))
+	})
+	return ok
+}
+
+func (c *instrumented[T]) IsExpired(keys ...string) (expired bool, err error) {
+	c.observe("IsExpired", len(keys), func(span trace.Span) {
+		expired, err = c.BMemCache.IsExpired(keys...)
+		lookup(span, err)
+	})
+	return expired, err
+}
+
+func (c *instrumented[T]) TTL(keys ...string) (ttl time.Duration, err error) {
+	c.observe("TTL", len(keys), func(span trace.Span) {
+		ttl, err = c.BMemCache.TTL(keys...)
+		lookup(span, err)
+	})
+	return ttl, err
+}
+
+func (c *instrumented[T]) ExpiresAt(keys ...string) (exp time.Time, ok bool, err error) {
+	c.observe("ExpiresAt", len(keys), func(span trace.Span) {
+		exp, ok, err = c.BMemCache.ExpiresAt(keys...)
+		lookup(span, err)
+	})
+	return exp, ok, err
+}
+
+func (c *instrumented[T]) Age(keys ...string) (age time.Duration, err error) {
+	c.observe("Age", len(keys), func(span trace.Span) {
+		age, err = c.BMemCache.Age(keys...)
+		lookup(span, err)
+	})
+	return age, err
+}
+
+func (c *instrumented[T]) Remaining(keys ...string) (ttl time.Duration) {
+	c.observe("Remaining", len(keys), func(span trace.Span) {
+		ttl = c.BMemCache.Remaining(keys...)
+		span.SetAttributes(hitKey.Bool(ttl != 0))
+	})
+	return ttl
+}
+
+func (c *instrumented[T]) KeyStats(keys ...string) (stat bmemcache.KeyStat, err error) {
+	c.observe("KeyStats", len(keys), func(span trace.Span) {
+		stat, err = c.BMemCache.KeyStats(keys...)
+		lookup(span, err)
+	})
+	return stat, err
+}
+
+func (c *instrumented[T]) ExpiredCount() (n int) {
+	c.observe("ExpiredCount", 0, func(trace.Span) {
+		n = c.BMemCache.ExpiredCount()
+	})
+	return n
+}
+
+func (c *instrumented[T]) TTLHistogram(buckets []time.Duration) (histogram map[time.Duration]int) {
+	c.observe("TTLHistogram", 0, func(trace.Span) {
+		histogram = c.BMemCache.TTLHistogram(buckets)
+	})
+	return histogram
+}
+
+func (c *instrumented[T]) EvictFraction(f float64) (n int) {
+	c.observe("EvictFraction", 0, func(trace.Span) {
+		n = c.BMemCache.EvictFraction(f)
+	})
+	return n
+}
+
+func (c *instrumented[T]) Healthy() (ok bool, err error) {
+	c.observe("Healthy", 0, func(span trace.Span) {
+		ok, err = c.BMemCache.Healthy()
+		fail(span, err)
+	})
+	return ok, err
+}
+
+func (c *instrumented[T]) Drain() (entries []bmemcache.EntryWithExp[T]) {
+	c.observe("Drain", 0, func(trace.Span) {
+		entries = c.BMemCache.Drain()
+	})
+	return entries
+}
+
+func (c *instrumented[T]) Compact() {
+	c.observe("Compact", 0, func(trace.Span) {
+		c.BMemCache.Compact()
+	})
+}
+
+func (c *instrumented[T]) Reset(options ...bmemcache.Option) {
+	c.observe("Reset", 0, func(trace.Span) {
+		c.BMemCache.Reset(options...)
+	})
+}
+
+func (c *instrumented[T]) Freeze() {
+	c.observe("Freeze", 0, func(trace.Span) {
+		c.BMemCache.Freeze()
+	})
+}
+
+func (c *instrumented[T]) Clear() {
+	c.observe("Clear", 0, func(trace.Span) {
+		c.BMemCache.Clear()
+	})
+}
+
+func (c *instrumented[T]) Close() {
+	c.observe("Close", 0, func(trace.Span) {
+		c.BMemCache.Close()
+	})
+}
@@ -0,0 +1,397 @@
+package bmemcacheotel
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bearaujus/bmemcache"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestInstrumented verifies that operations emit named spans with key depth and hit attributes and record latencies.
+func TestInstrumented(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	reader := sdkmetric.NewManualReader()
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	cache := NewInstrumented(bmemcache.New[string](), tracerProvider.Tracer("test"), meterProvider.Meter("test"))
+	cache.Set("value", "a", "b")
+	if value, err := cache.Get("a", "b"); err != nil || value != "value" {
+		t.Errorf("unexpected get result: %v, %v", value, err)
+	}
+	if _, err := cache.Get("missing"); !errors.Is(err, bmemcache.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+	cache.Close()
+
+	spans := exporter.GetSpans()
+	wantNames := []string{"bmemcache.Set", "bmemcache.Get", "bmemcache.Get", "bmemcache.Close"}
+	if len(spans) != len(wantNames) {
+		t.Fatalf("expected %d spans, got: %d", len(wantNames), len(spans))
+	}
+	for i, want := range wantNames {
+		if spans[i].Name != want {
+			t.Errorf("expected span %d to be %s, got: %s", i, want, spans[i].Name)
+		}
+	}
+	wantAttrs := []map[attribute.Key]attribute.Value{
+		{keyDepthKey: attribute.IntValue(2)},
+		{keyDepthKey: attribute.IntValue(2), hitKey: attribute.BoolValue(true)},
+		{keyDepthKey: attribute.IntValue(1), hitKey: attribute.BoolValue(false)},
+	}
+	for i, want := range wantAttrs {
+		got := make(map[attribute.Key]attribute.Value)
+		for _, kv := range spans[i].Attributes {
+			got[kv.Key] = kv.Value
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("expected span %d attribute %s to be %v, got: %v", i, k, v.Emit(), got[k].Emit())
+			}
+		}
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rm.ScopeMetrics) != 1 || len(rm.ScopeMetrics[0].Metrics) != 1 || rm.ScopeMetrics[0].Metrics[0].Name != durationMetric {
+		t.Fatalf("expected the %s histogram, got: %+v", durationMetric, rm.ScopeMetrics)
+	}
+	histogram, ok := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[float64])
+	if !ok {
+		t.Fatalf("expected a float64 histogram, got: %T", rm.ScopeMetrics[0].Metrics[0].Data)
+	}
+	var count uint64
+	for _, dp := range histogram.DataPoints {
+		count += dp.Count
+	}
+	if count != 4 {
+		t.Errorf("expected 4 recorded durations, got: %d", count)
+	}
+}
+
+// TestInstrumentedName verifies that the cache name is attached to spans and latencies.
+func TestInstrumentedName(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	reader := sdkmetric.NewManualReader()
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	cache := NewInstrumented(bmemcache.New[string](bmemcache.WithName("users")), tracerProvider.Tracer("test"), meterProvider.Meter("test"))
+	cache.Set("value", "key")
+	cache.Close()
+
+	for _, span := range exporter.GetSpans() {
+		var found bool
+		for _, kv := range span.Attributes {
+			if kv.Key == nameKey && kv.Value.AsString() == "users" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected span %s to carry the cache name, got: %v", span.Name, span.Attributes)
+		}
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	histogram := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[float64])
+	for _, dp := range histogram.DataPoints {
+		if name, ok := dp.Attributes.Value(nameKey); !ok || name.AsString() != "users" {
+			t.Errorf("expected latencies to carry the cache name, got: %v", dp.Attributes)
+		}
+	}
+}
+
+// newTestInstrumented returns an instrumented cache recording its spans in the returned exporter.
+func newTestInstrumented(t *testing.T) (bmemcache.BMemCache[string], *tracetest.InMemoryExporter) {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewManualReader()))
+	cache := NewInstrumented(bmemcache.New[string](), tracerProvider.Tracer("test"), meterProvider.Meter("test"))
+	t.Cleanup(cache.Close)
+	return cache, exporter
+}
+
+// checkHits verifies that the recorded spans have the given names and hit attributes.
+func checkHits(t *testing.T, exporter *tracetest.InMemoryExporter, names []string, hits []bool) {
+	t.Helper()
+	spans := exporter.GetSpans()
+	if len(spans) != len(names) {
+		t.Fatalf("expected %d spans, got: %d", len(names), len(spans))
+	}
+	for i, span := range spans {
+		if span.Name != names[i] {
+			t.Errorf("expected span %d to be %s, got: %s", i, names[i], span.Name)
+		}
+		var hit attribute.Value
+		for _, kv := range span.Attributes {
+			if kv.Key == hitKey {
+				hit = kv.Value
+			}
+		}
+		if hit != attribute.BoolValue(hits[i]) {
+			t.Errorf("expected span %s to have hit %v, got: %v", span.Name, hits[i], hit.Emit())
+		}
+	}
+}
+
+// TestInstrumentedTryGet verifies that TryGet emits a span reporting whether the item was found.
+func TestInstrumentedTryGet(t *testing.T) {
+	cache, exporter := newTestInstrumented(t)
+	cache.Set("value", "key")
+	exporter.Reset()
+
+	if value, ok := cache.TryGet("key"); !ok || value != "value" {
+		t.Errorf("unexpected TryGet result: %v, %v", value, ok)
+	}
+	if _, ok := cache.TryGet("missing"); ok {
+		t.Error("expected a miss")
+	}
+	checkHits(t, exporter, []string{"bmemcache.TryGet", "bmemcache.TryGet"}, []bool{true, false})
+}
+
+// TestInstrumentedGetOrDefault verifies that GetOrDefault emits a span reporting whether the
+// item was found.
+func TestInstrumentedGetOrDefault(t *testing.T) {
+	cache, exporter := newTestInstrumented(t)
+	cache.Set("value", "key")
+	exporter.Reset()
+
+	if value := cache.GetOrDefault("default", "key"); value != "value" {
+		t.Errorf("expected the cached value, got: %v", value)
+	}
+	if value := cache.GetOrDefault("default", "missing"); value != "default" {
+		t.Errorf("expected the default value, got: %v", value)
+	}
+	checkHits(t, exporter, []string{"bmemcache.GetOrDefault", "bmemcache.GetOrDefault"}, []bool{true, false})
+}
+
+// TestInstrumentedWrites verifies that the writes returning data report whether they replaced
+// an item.
+func TestInstrumentedWrites(t *testing.T) {
+	cache, exporter := newTestInstrumented(t)
+
+	cache.GetSet("first", "key")
+	cache.GetSet("second", "key")
+	cache.SwapWithExp("third", time.Minute, "key")
+	cache.SwapWithExp("first", time.Minute, "other")
+	checkHits(t, exporter,
+		[]string{"bmemcache.GetSet", "bmemcache.GetSet", "bmemcache.SwapWithExp", "bmemcache.SwapWithExp"},
+		[]bool{false, true, true, false})
+}
+
+// TestInstrumentedSetIfNewer verifies that SetIfNewer emits a span whether or not it stores the data.
+func TestInstrumentedSetIfNewer(t *testing.T) {
+	cache, exporter := newTestInstrumented(t)
+
+	if !cache.SetIfNewer("v2", 2, "key") || cache.SetIfNewer("v1", 1, "key") {
+		t.Error("expected only the newer version to be stored")
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[0].Name != "bmemcache.SetIfNewer" || spans[1].Name != "bmemcache.SetIfNewer" {
+		t.Errorf("expected 2 SetIfNewer spans, got: %v", spans)
+	}
+}
+
+// TestInstrumentedSetAndKey verifies that SetAndKey and SetWithExpAndKey emit spans and return
+// the cache key.
+func TestInstrumentedSetAndKey(t *testing.T) {
+	cache, exporter := newTestInstrumented(t)
+
+	if key := cache.SetAndKey("value", "a"); key != `["a"]` {
+		t.Errorf("unexpected key: %s", key)
+	}
+	if key := cache.SetWithExpAndKey("value", time.Minute, "b"); key != `["b"]` {
+		t.Errorf("unexpected key: %s", key)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[0].Name != "bmemcache.SetAndKey" || spans[1].Name != "bmemcache.SetWithExpAndKey" {
+		t.Errorf("expected SetAndKey and SetWithExpAndKey spans, got: %v", spans)
+	}
+}
+
+// TestInstrumentedGetTimeout verifies that GetTimeout emits a span with the hit attribute.
+func TestInstrumentedGetTimeout(t *testing.T) {
+	cache, exporter := newTestInstrumented(t)
+	cache.Set("value", "key")
+	exporter.Reset()
+
+	_, _ = cache.GetTimeout(time.Second, "key")
+	_, _ = cache.GetTimeout(time.Second, "missing")
+	checkHits(t, exporter, []string{"bmemcache.GetTimeout", "bmemcache.GetTimeout"}, []bool{true, false})
+}
+
+// TestInstrumentedGetFirst verifies that GetFirst emits a span with the hit attribute.
+func TestInstrumentedGetFirst(t *testing.T) {
+	cache, exporter := newTestInstrumented(t)
+	cache.Set("value", "fallback")
+	exporter.Reset()
+
+	_, _, _ = cache.GetFirst([]string{"key"}, []string{"fallback"})
+	_, _, _ = cache.GetFirst([]string{"key"}, []string{"missing"})
+	checkHits(t, exporter, []string{"bmemcache.GetFirst", "bmemcache.GetFirst"}, []bool{true, false})
+}
+
+// TestInstrumentedExpiresAt verifies that ExpiresAt emits a span with the hit attribute.
+func TestInstrumentedExpiresAt(t *testing.T) {
+	cache, exporter := newTestInstrumented(t)
+	cache.SetWithExp("value", time.Minute, "key")
+	exporter.Reset()
+
+	if _, ok, err := cache.ExpiresAt("key"); err != nil || !ok {
+		t.Errorf("expected an expiration, got: %v, %v", ok, err)
+	}
+	_, _, _ = cache.ExpiresAt("missing")
+	checkHits(t, exporter, []string{"bmemcache.ExpiresAt", "bmemcache.ExpiresAt"}, []bool{true, false})
+}
+
+// TestInstrumentedAge verifies that Age emits a span with the hit attribute.
+func TestInstrumentedAge(t *testing.T) {
+	cache, exporter := newTestInstrumented(t)
+	cache.Set("value", "key")
+	exporter.Reset()
+
+	if _, err := cache.Age("key"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _ = cache.Age("missing")
+	checkHits(t, exporter, []string{"bmemcache.Age", "bmemcache.Age"}, []bool{true, false})
+}
+
+// TestInstrumentedKeyStats verifies that KeyStats emits a span with the hit attribute.
+func TestInstrumentedKeyStats(t *testing.T) {
+	cache, exporter := newTestInstrumented(t)
+	cache.Set("value", "key")
+	exporter.Reset()
+
+	if _, err := cache.KeyStats("key"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _ = cache.KeyStats("missing")
+	checkHits(t, exporter, []string{"bmemcache.KeyStats", "bmemcache.KeyStats"}, []bool{true, false})
+}
+
+// TestInstrumentedDeleteMany verifies that DeleteMany emits a single span for the batch.
+func TestInstrumentedDeleteMany(t *testing.T) {
+	cache, exporter := newTestInstrumented(t)
+	cache.Set("value", "a")
+	cache.Set("value", "b")
+	exporter.Reset()
+
+	if n := cache.DeleteMany([][]string{{"a"}, {"b"}, {"missing"}}); n != 2 {
+		t.Errorf("expected 2 items removed, got: %d", n)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "bmemcache.DeleteMany" {
+		t.Errorf("expected a DeleteMany span, got: %v", spans)
+	}
+}
+
+// TestInstrumentedSetManyWithExp verifies that SetManyWithExp emits a single span for the batch.
+func TestInstrumentedSetManyWithExp(t *testing.T) {
+	cache, exporter := newTestInstrumented(t)
+
+	cache.SetManyWithExp([]bmemcache.EntryWithExp[string]{
+		{Keys: []string{"a"}, Data: "a"},
+		{Keys: []string{"b"}, Data: "b", Duration: time.Minute},
+	})
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "bmemcache.SetManyWithExp" {
+		t.Errorf("expected a SetManyWithExp span, got: %v", spans)
+	}
+	if !cache.IsExist("a") || !cache.IsExist("b") {
+		t.Error("expected the batch to be stored")
+	}
+}
+
+// TestInstrumentedBulk verifies that the bulk, listing and maintenance operations emit spans,
+// and that the spans of the iterators cover their iteration.
+func TestInstrumentedBulk(t *testing.T) {
+	cache, exporter := newTestInstrumented(t)
+	other := bmemcache.New[string]()
+	defer other.Close()
+	other.Set("value", "other")
+	ch := make(chan bmemcache.EntryWithExp[string])
+	close(ch)
+
+	cache.Import([]bmemcache.KeyValueExp[string]{{Keys: []string{"a", "1"}, Value: "value"}}, true)
+	cache.Merge(other, true)
+	_ = cache.LoadFrom(context.Background(), ch)
+	cache.TransformAll(func(keys []string, old string) string { return old })
+	_ = cache.GetsMap()
+	_ = cache.Filter(func([]string, string) bool { return true })
+	_ = cache.FilterKeys(func([]string, string) bool { return true })
+	cache.RangeUnlocked(func([]string, string) bool { return true })
+	all := cache.All()
+	for range all {
+	}
+	for range cache.AllKeys() {
+	}
+	_, _, _ = cache.GetsFromPrefixPage(10, "", "a")
+	_, _ = cache.EntriesFromPrefix("a")
+	_, _ = cache.LookupByIndex("missing", "value")
+	_ = cache.KeyStrings()
+	_ = cache.KeysSorted()
+	_, _ = cache.KeysPage(10, "")
+	_ = cache.ExpiredCount()
+	_ = cache.TTLHistogram([]time.Duration{time.Minute})
+	_, _ = cache.Healthy()
+	_ = cache.Export()
+	cache.ReplaceAll(nil)
+	_ = cache.Replay(strings.NewReader(""))
+	_ = cache.EvictFraction(0.5)
+	_ = cache.Drain()
+	cache.Compact()
+	cache.Reset()
+	cache.Freeze()
+
+	names := []string{
+		"Import", "Merge", "LoadFrom", "TransformAll", "GetsMap", "Filter", "FilterKeys",
+		"RangeUnlocked", "All", "AllKeys", "GetsFromPrefixPage", "EntriesFromPrefix",
+		"LookupByIndex", "KeyStrings", "KeysSorted", "KeysPage", "ExpiredCount", "TTLHistogram",
+		"Healthy", "Export", "ReplaceAll", "Replay", "EvictFraction", "Drain", "Compact", "Reset",
+		"Freeze",
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != len(names) {
+		t.Fatalf("expected %d spans, got: %d", len(names), len(spans))
+	}
+	for i, span := range spans {
+		if want := spanPrefix + names[i]; span.Name != want {
+			t.Errorf("expected span %d to be %s, got: %s", i, want, span.Name)
+		}
+	}
+}
+
+// TestInstrumentedGetOrSetCtx verifies that GetOrSetCtx emits a span and passes the context to
+// the loader.
+func TestInstrumentedGetOrSetCtx(t *testing.T) {
+	cache, exporter := newTestInstrumented(t)
+
+	value, err := cache.GetOrSetCtx(func(ctx context.Context) (string, error) {
+		if ctx == nil {
+			return "", errors.New("nil context")
+		}
+		return "loaded", nil
+	}, "key")
+	if err != nil || value != "loaded" {
+		t.Errorf("unexpected GetOrSetCtx result: %v, %v", value, err)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "bmemcache.GetOrSetCtx" {
+		t.Errorf("expected a GetOrSetCtx span, got: %v", spans)
+	}
+}
@@ -0,0 +1,105 @@
+// Package bmemcacheprom exports bmemcache usage metrics to Prometheus.
+//
+// It lives in its own module so the core bmemcache package stays free of dependencies.
+package bmemcacheprom
+
+import (
+	"github.com/bearaujus/bmemcache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Source is the part of bmemcache.BMemCache the collector reads metrics from.
+// Any BMemCache[T] satisfies it regardless of T.
+type Source interface {
+	// Name returns the name of the cache, used as the "cache" label when not empty.
+	Name() string
+	// Len returns the number of items currently stored.
+	Len() int
+	// Stats returns a snapshot of the cache usage counters.
+	Stats() bmemcache.Stats
+}
+
+// nameLabel is the constant label holding the cache name.
+const nameLabel = "cache"
+
+// NewCollector creates a prometheus.Collector exposing the metrics of the given cache.
+//
+// It exports the number of stored items as the bmemcache_entries gauge, and the hits, misses,
+// expirations and evictions counted by Stats as the bmemcache_hits_total,
+// bmemcache_misses_total, bmemcache_expirations_total and bmemcache_evictions_total counters.
+//
+// When the cache has a name (see bmemcache.WithName), every metric carries it in the "cache"
+// label, which allows registering a collector for each of several caches.
+//
+// Parameters:
+//   - src: The cache to read metrics from.
+//
+// Returns:
+//   - A prometheus.Collector to be registered, e.g. with prometheus.MustRegister.
+//
+// Example usage:
+//
+//	cache := bmemcache.New[string]()
+//	prometheus.MustRegister(bmemcacheprom.NewCollector(cache))
+func NewCollector(src Source) prometheus.Collector {
+	var labels prometheus.Labels
+	if name := src.Name(); name != "" {
+		labels = prometheus.Labels{nameLabel: name}
+	}
+	return &collector{
+		src: src,
+		entriesDesc: prometheus.NewDesc(
+			"bmemcache_entries",
+			"Number of items currently stored in the cache, including expired items not removed yet.",
+			nil, labels,
+		),
+		hitsDesc: prometheus.NewDesc(
+			"bmemcache_hits_total",
+			"Number of lookups that found an unexpired item.",
+			nil, labels,
+		),
+		missesDesc: prometheus.NewDesc(
+			"bmemcache_misses_total",
+			"Number of lookups that found no item or an expired one.",
+			nil, labels,
+		),
+		expirationsDesc: prometheus.NewDesc(
+			"bmemcache_expirations_total",
+			"Number of items removed because their TTL lapsed.",
+			nil, labels,
+		),
+		evictionsDesc: prometheus.NewDesc(
+			"bmemcache_evictions_total",
+			"Number of live items removed to free room or memory.",
+			nil, labels,
+		),
+	}
+}
+
+type collector struct {
+	src             Source
+	entriesDesc     *prometheus.Desc
+	hitsDesc        *prometheus.Desc
+	missesDesc      *prometheus.Desc
+	expirationsDesc *prometheus.Desc
+	evictionsDesc   *prometheus.Desc
+}
+
+// Describe sends the descriptors of the cache metrics.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.entriesDesc
+	ch <- c.hitsDesc
+	ch <- c.missesDesc
+	ch <- c.expirationsDesc
+	ch <- c.evictionsDesc
+}
+
+// Collect sends the current values of the cache metrics.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.src.Stats()
+	ch <- prometheus.MustNewConstMetric(c.entriesDesc, prometheus.GaugeValue, float64(c.src.Len()))
+	ch <- prometheus.MustNewConstMetric(c.hitsDesc, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.missesDesc, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.expirationsDesc, prometheus.CounterValue, float64(stats.Expirations))
+	ch <- prometheus.MustNewConstMetric(c.evictionsDesc, prometheus.CounterValue, float64(stats.Evictions))
+}
@@ -0,0 +1,78 @@
+package bmemcacheprom
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bearaujus/bmemcache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestCollector verifies that the collector exposes the cache metric families with their current values.
+func TestCollector(t *testing.T) {
+	cache := bmemcache.New[string]()
+	defer cache.Close()
+
+	cache.Set("value", "key1")
+	cache.Set("value", "key2")
+	_, _ = cache.Get("key1")
+	_, _ = cache.Get("missing")
+	cache.Set("value", "key3")
+	if n := cache.EvictFraction(0.34); n != 1 {
+		t.Fatalf("expected 1 eviction, got: %d", n)
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(NewCollector(cache)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `
+# HELP bmemcache_entries Number of items currently stored in the cache, including expired items not removed yet.
+# TYPE bmemcache_entries gauge
+bmemcache_entries 2
+# HELP bmemcache_evictions_total Number of live items removed to free room or memory.
+# TYPE bmemcache_evictions_total counter
+bmemcache_evictions_total 1
+# HELP bmemcache_expirations_total Number of items removed because their TTL lapsed.
+# TYPE bmemcache_expirations_total counter
+bmemcache_expirations_total 0
+# HELP bmemcache_hits_total Number of lookups that found an unexpired item.
+# TYPE bmemcache_hits_total counter
+bmemcache_hits_total 1
+# HELP bmemcache_misses_total Number of lookups that found no item or an expired one.
+# TYPE bmemcache_misses_total counter
+bmemcache_misses_total 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected metrics: %v", err)
+	}
+}
+
+// TestCollectorName verifies that collectors of named caches carry the cache label and can be registered together.
+func TestCollectorName(t *testing.T) {
+	users := bmemcache.New[string](bmemcache.WithName("users"))
+	defer users.Close()
+	sessions := bmemcache.New[string](bmemcache.WithName("sessions"))
+	defer sessions.Close()
+	users.Set("value", "key")
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(NewCollector(users)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := registry.Register(NewCollector(sessions)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `
+# HELP bmemcache_entries Number of items currently stored in the cache, including expired items not removed yet.
+# TYPE bmemcache_entries gauge
+bmemcache_entries{cache="sessions"} 0
+bmemcache_entries{cache="users"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "bmemcache_entries"); err != nil {
+		t.Errorf("unexpected metrics: %v", err)
+	}
+}
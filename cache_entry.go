@@ -1,16 +1,95 @@
 package bmemcache
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
+)
 
 type cacheEntry[T any] struct {
 	Data T
-	Exp  time.Time
+	// Raw holds the encoded data when a value codec is configured, in which case Data is unused.
+	Raw []byte
+	Exp time.Time
+	// Created holds the time the entry was stored. Update, reloads of WithRefreshAhead and
+	// changes of the expiration preserve it. It costs 24 bytes per entry.
+	Created time.Time
+	// Size holds the size of the data as measured by WithSizeOf, or zero if it is not configured.
+	Size int64
+	// Version holds the version the data was stored with by SetIfNewer, or zero. Update and
+	// changes of the expiration preserve it.
+	Version int64
+	// Stats holds the access statistics when WithKeyStats is configured. It is shared by the
+	// copies of the entry so that they are preserved when only the expiration changes.
+	Stats *entryStats
+	// Idle holds the time, in Unix nanoseconds, after which the entry expires unless it is read
+	// when WithTimeToIdle is configured. Like Stats, it is shared by the copies of the entry.
+	Idle *atomic.Int64
+	// Fields holds the field values extracted from the data by each WithIndex function, in the
+	// order of the indexes of the cache, or nil without indexes.
+	Fields []string
+}
+
+// entryStats holds the access statistics of an entry.
+type entryStats struct {
+	// Counters are accessed atomically and kept first to guarantee 64-bit alignment.
+	hits       int64
+	lastAccess int64 // Unix nanoseconds, zero if never accessed
+}
+
+// recordAccess counts a lookup that found the entry unexpired.
+func (s *entryStats) recordAccess() {
+	atomic.AddInt64(&s.hits, 1)
+	atomic.StoreInt64(&s.lastAccess, time.Now().UnixNano())
 }
 
 func (ce *cacheEntry[T]) isExpired() bool {
-	return !ce.Exp.IsZero() && time.Now().After(ce.Exp)
+	exp := ce.expiresAt()
+	return !exp.IsZero() && time.Now().After(exp)
+}
+
+// isRemovable reports whether the entry has been expired for longer than the given retention,
+// after which it may be removed from the cache.
+func (ce *cacheEntry[T]) isRemovable(retention time.Duration) bool {
+	exp := ce.expiresAt()
+	return !exp.IsZero() && time.Now().After(exp.Add(retention))
+}
+
+// expiresAt returns the time at which the entry expires: the earliest of its expiration and
+// its idle deadline, or the zero time if it has neither.
+func (ce *cacheEntry[T]) expiresAt() time.Time {
+	if ce.Idle == nil {
+		return ce.Exp
+	}
+	idle := time.Unix(0, ce.Idle.Load())
+	if ce.Exp.IsZero() || idle.Before(ce.Exp) {
+		return idle
+	}
+	return ce.Exp
+}
+
+// lastUsed returns the time of the last access in Unix nanoseconds, or the creation time if the
+// entry was never accessed or access statistics are not tracked.
+func (ce *cacheEntry[T]) lastUsed() int64 {
+	if ce.Stats != nil {
+		if lastAccess := atomic.LoadInt64(&ce.Stats.lastAccess); lastAccess != 0 {
+			return lastAccess
+		}
+	}
+	return ce.Created.UnixNano()
+}
+
+// hits returns the number of lookups that found the entry unexpired, or zero if access
+// statistics are not tracked.
+func (ce *cacheEntry[T]) hits() int64 {
+	if ce.Stats == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&ce.Stats.hits)
 }
 
-func (ce *cacheEntry[T]) flush() {
-	ce.Data = generateEmptyData[T]()
+// withExp returns a copy of the entry with the given expiration.
+func (ce *cacheEntry[T]) withExp(exp time.Time) *cacheEntry[T] {
+	entry := *ce
+	entry.Exp = exp
+	return &entry
 }
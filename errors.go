@@ -1,14 +1,89 @@
 package bmemcache
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
-	// ErrEmpty is returned when cache is empty.
+	// ErrEmpty is returned by aggregate reads when the cache holds no unexpired entries.
 	ErrEmpty = errors.New("empty")
 
-	// ErrNotFound is returned when a cache entry is not found.
+	// ErrNotFound is returned when a cache entry is not found, or when an aggregate read
+	// matches nothing in a non-empty cache.
 	ErrNotFound = errors.New("not found")
 
 	// ErrExpired is returned when a cache entry has expired.
 	ErrExpired = errors.New("expired")
+
+	// ErrFull is returned when a new entry cannot be stored because the cache is full.
+	ErrFull = errors.New("full")
+
+	// ErrTooManyKeyParts is returned when a key has more parts than allowed by WithMaxKeyParts.
+	ErrTooManyKeyParts = errors.New("too many key parts")
+
+	// ErrEmptyKey is returned when a key has no parts while WithAllowEmptyKeys(false) is
+	// configured.
+	ErrEmptyKey = errors.New("empty key")
+
+	// ErrTooLarge is returned when a value is larger than allowed by WithMaxValueSize.
+	ErrTooLarge = errors.New("too large")
+
+	// ErrInvalidCursor is returned when a paging cursor was not returned by a previous call.
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrCleanupDisabled is returned by Healthy when WithAutoCleanUp is not configured.
+	ErrCleanupDisabled = errors.New("auto-cleanup disabled")
+
+	// ErrCleanupStalled is returned by Healthy when auto-cleanup has not completed a sweep for
+	// several intervals.
+	ErrCleanupStalled = errors.New("auto-cleanup stalled")
+
+	// ErrClosed is returned by Healthy once the cache has been closed or frozen.
+	ErrClosed = errors.New("closed")
+
+	// ErrUnknownIndex is returned by LookupByIndex when no index was registered under the given
+	// name with WithIndex.
+	ErrUnknownIndex = errors.New("unknown index")
+
+	// ErrFrozen is returned when a write is attempted on a cache made read-only by Freeze.
+	ErrFrozen = errors.New("frozen")
 )
+
+// errNotNewer is returned internally by SetIfNewer when the stored item is not older.
+var errNotNewer = errors.New("not newer")
+
+// CacheError reports a failed operation on a given key.
+//
+// It wraps one of the sentinel errors above, so errors.Is(err, ErrNotFound) keeps working,
+// while errors.As gives access to the key parts. Every operation on a single key, whether a
+// lookup or an update, returns its ErrNotFound and ErrExpired errors wrapped in a *CacheError.
+type CacheError struct {
+	// Keys holds the parts of the key the operation failed on.
+	Keys []string
+	// Err is the underlying error, such as ErrNotFound or ErrExpired.
+	Err error
+}
+
+// newCacheError wraps err with a copy of the given key parts.
+func newCacheError(keys []string, err error) *CacheError {
+	return &CacheError{Keys: append([]string{}, keys...), Err: err}
+}
+
+// firstKeys returns the first of the given key groups, or nil if there is none.
+func firstKeys(keyGroups [][]string) []string {
+	if len(keyGroups) == 0 {
+		return nil
+	}
+	return keyGroups[0]
+}
+
+// Error returns the underlying error message prefixed with the key parts.
+func (e *CacheError) Error() string {
+	return fmt.Sprintf("key %q: %v", e.Keys, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *CacheError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,203 @@
+package bmemcache
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+func (c *bmemCache[T]) EvictFraction(f float64) int {
+	c.mu.Lock()
+	if c.frozen || f <= 0 || len(c.items) == 0 {
+		c.mu.Unlock()
+		return 0
+	}
+	if f > 1 {
+		f = 1
+	}
+	type candidate struct {
+		key   string
+		entry *cacheEntry[T]
+	}
+	candidates := make([]candidate, 0, len(c.items))
+	for key, entry := range c.items {
+		candidates = append(candidates, candidate{key: key, entry: entry})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return evictsBefore(candidates[i].entry, candidates[j].entry)
+	})
+	n := int(f*float64(len(candidates)) + 0.5)
+	victims := candidates[:n]
+	for _, victim := range victims {
+		c.removeLocked(victim.key)
+	}
+	c.mu.Unlock()
+	atomic.AddInt64(&c.evictions, int64(n))
+	if c.observer != nil {
+		for _, victim := range victims {
+			c.observeEntry(OpEvict, deserializeKey(victim.key), victim.entry)
+		}
+	}
+	return n
+}
+
+// evictsBefore reports whether EvictFraction selects a before b: expired entries go first,
+// followed by the least recently used ones when access statistics are tracked.
+func evictsBefore[T any](a, b *cacheEntry[T]) bool {
+	if expiredA, expiredB := a.isExpired(), b.isExpired(); expiredA != expiredB {
+		return expiredA
+	}
+	if a.Stats == nil || b.Stats == nil {
+		return false
+	}
+	return a.lastUsed() < b.lastUsed()
+}
+
+// OverflowPolicy selects what happens to a write of a new key once the cache holds the maximum
+// number of entries set with WithCapacity. Expired entries are always reclaimed first.
+type OverflowPolicy int
+
+const (
+	// RejectNew rejects the write: TrySet and TrySetWithExp return ErrFull, while Set and
+	// SetWithExp silently discard the data.
+	RejectNew OverflowPolicy = iota
+	// EvictLRU evicts the least recently read entry, where an entry never read counts as read
+	// when it was stored.
+	EvictLRU
+	// EvictLFU evicts the least frequently read entry, the least recently read one among ties.
+	EvictLFU
+	// EvictSoonestExpiring evicts the entry closest to its expiration. Entries without expiration
+	// are only evicted once no other entry is left, the least recently read one first.
+	EvictSoonestExpiring
+)
+
+// String returns the name of the policy, e.g. "EvictLRU".
+func (p OverflowPolicy) String() string {
+	switch p {
+	case RejectNew:
+		return "RejectNew"
+	case EvictLRU:
+		return "EvictLRU"
+	case EvictLFU:
+		return "EvictLFU"
+	case EvictSoonestExpiring:
+		return "EvictSoonestExpiring"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+}
+
+// tracksAccess reports whether the policy needs the access statistics of the entries.
+func (p OverflowPolicy) tracksAccess() bool {
+	return p == EvictLRU || p == EvictLFU
+}
+
+// removals holds the entries storeLocked removed to make room, to be reported once the lock
+// is released.
+type removals[T any] struct {
+	expired map[string]*cacheEntry[T]
+	evicted map[string]*cacheEntry[T]
+}
+
+// merge adds the entries of other to r.
+func (r *removals[T]) merge(other removals[T]) {
+	if r.expired == nil {
+		r.expired = make(map[string]*cacheEntry[T])
+	}
+	for key, entry := range other.expired {
+		r.expired[key] = entry
+	}
+	if r.evicted == nil {
+		r.evicted = make(map[string]*cacheEntry[T])
+	}
+	for key, entry := range other.evicted {
+		r.evicted[key] = entry
+	}
+}
+
+// evictLocked removes the entry the policy selects among those whose serialized key satisfies
+// match, or among every entry if match is nil, to make room for a new one. Selecting it scans
+// every entry. It must be called with the write lock held.
+//
+// Returns:
+//   - The serialized key and the entry removed, or false if the policy rejects new entries
+//     instead, or if no entry matches.
+func (c *bmemCache[T]) evictLocked(policy OverflowPolicy, match func(key string) bool) (string, *cacheEntry[T], bool) {
+	if policy == RejectNew {
+		return "", nil, false
+	}
+	var victimKey string
+	var victim *cacheEntry[T]
+	for key, entry := range c.items {
+		if match != nil && !match(key) {
+			continue
+		}
+		if victim == nil || overflowsBefore(policy, entry, victim) {
+			victimKey, victim = key, entry
+		}
+	}
+	if victim == nil {
+		return "", nil, false
+	}
+	c.removeLocked(victimKey)
+	return victimKey, victim, true
+}
+
+// pendingVictim selects the entry the policy evicts among items whose serialized key satisfies
+// match, or among every item if match is nil, like evictLocked does among the stored entries.
+// Ties are broken by order, the sequence in which the items were stored, oldest first.
+//
+// Returns:
+//   - The serialized key of the entry selected, or false if the policy rejects new entries
+//     instead, or if no item matches.
+func pendingVictim[T any](policy OverflowPolicy, items map[string]*cacheEntry[T], order map[string]int, match func(key string) bool) (string, bool) {
+	if policy == RejectNew {
+		return "", false
+	}
+	var victimKey string
+	var victim *cacheEntry[T]
+	for key, entry := range items {
+		if match != nil && !match(key) {
+			continue
+		}
+		if victim == nil || overflowsBefore(policy, entry, victim) ||
+			(!overflowsBefore(policy, victim, entry) && order[key] < order[victimKey]) {
+			victimKey, victim = key, entry
+		}
+	}
+	return victimKey, victim != nil
+}
+
+// overflowsBefore reports whether the policy evicts a before b.
+func overflowsBefore[T any](policy OverflowPolicy, a, b *cacheEntry[T]) bool {
+	switch policy {
+	case EvictLFU:
+		if hitsA, hitsB := a.hits(), b.hits(); hitsA != hitsB {
+			return hitsA < hitsB
+		}
+	case EvictSoonestExpiring:
+		expA, expB := a.expiresAt(), b.expiresAt()
+		if expA.IsZero() != expB.IsZero() {
+			return !expA.IsZero()
+		}
+		if !expA.Equal(expB) {
+			return expA.Before(expB)
+		}
+	}
+	return a.lastUsed() < b.lastUsed()
+}
+
+// notifyRemovals reports the entries removed to make room: expired ones like the cleanup does,
+// and evicted ones like EvictFraction does. It must be called without holding the lock.
+func (c *bmemCache[T]) notifyRemovals(r removals[T]) {
+	c.notifyExpireAll(r.expired)
+	if len(r.evicted) == 0 {
+		return
+	}
+	atomic.AddInt64(&c.evictions, int64(len(r.evicted)))
+	if c.observer != nil {
+		for key, entry := range r.evicted {
+			c.observeEntry(OpEvict, deserializeKey(key), entry)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package bmemcache
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiryItem schedules the removal of an entry at its expiration time, delayed by the
+// retention configured with WithExpiredRetention.
+type expiryItem[T any] struct {
+	key   string
+	entry *cacheEntry[T]
+}
+
+// expiryHeap is a min-heap of scheduled removals ordered by expiration time.
+//
+// Items are never removed when their entry is replaced or deleted. Instead, an item is only
+// acted upon if its entry is still the one stored under its key once it is due.
+type expiryHeap[T any] []expiryItem[T]
+
+func (h expiryHeap[T]) Len() int           { return len(h) }
+func (h expiryHeap[T]) Less(i, j int) bool { return h[i].entry.Exp.Before(h[j].entry.Exp) }
+func (h expiryHeap[T]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap[T]) Push(x any) {
+	*h = append(*h, x.(expiryItem[T]))
+}
+
+func (h *expiryHeap[T]) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = expiryItem[T]{}
+	*h = old[:len(old)-1]
+	return item
+}
+
+// scheduleLocked schedules the removal of the given entry at its expiration time when eager
+// expiration is enabled. It must be called with the write lock held.
+func (c *bmemCache[T]) scheduleLocked(key string, entry *cacheEntry[T]) {
+	if !c.eagerExpiration || entry.Exp.IsZero() {
+		return
+	}
+	if len(c.expiries) > 2*len(c.items)+64 {
+		c.compactExpiriesLocked()
+	}
+	heap.Push(&c.expiries, expiryItem[T]{key: key, entry: entry})
+	if c.expiries[0].entry == entry {
+		c.wakeExpirer()
+	}
+}
+
+// wakeExpirer makes the expirer reset its timer after the nearest expiration changed.
+func (c *bmemCache[T]) wakeExpirer() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// newExpiryHeap schedules the removal of every given entry that has an expiration, for
+// storage that is about to replace the current one. It returns nil when eager expiration is
+// disabled.
+func (c *bmemCache[T]) newExpiryHeap(items map[string]*cacheEntry[T]) expiryHeap[T] {
+	if !c.eagerExpiration {
+		return nil
+	}
+	h := make(expiryHeap[T], 0, len(items))
+	for key, entry := range items {
+		if !entry.Exp.IsZero() {
+			h = append(h, expiryItem[T]{key: key, entry: entry})
+		}
+	}
+	heap.Init(&h)
+	return h
+}
+
+// compactExpiriesLocked drops the scheduled removals of entries that have since been replaced
+// or deleted, bounding the size of the heap. It must be called with the write lock held.
+func (c *bmemCache[T]) compactExpiriesLocked() {
+	live := c.expiries[:0]
+	for _, item := range c.expiries {
+		if c.items[item.key] == item.entry {
+			live = append(live, item)
+		}
+	}
+	for i := len(live); i < len(c.expiries); i++ {
+		c.expiries[i] = expiryItem[T]{}
+	}
+	c.expiries = live
+	heap.Init(&c.expiries)
+}
+
+// eagerExpire removes each entry as soon as it expires, until the cache is closed.
+func (c *bmemCache[T]) eagerExpire() {
+	for {
+		var timer *time.Timer
+		var fire <-chan time.Time
+		c.mu.RLock()
+		if len(c.expiries) > 0 {
+			timer = time.NewTimer(time.Until(c.expiries[0].entry.Exp.Add(c.settings().expiredRetention)))
+			fire = timer.C
+		}
+		c.mu.RUnlock()
+		select {
+		case <-fire:
+			c.expireDue()
+		case <-c.wake:
+		case <-c.doneChan:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		select {
+		case <-c.doneChan:
+			return
+		default:
+		}
+	}
+}
+
+// expireDue removes every entry whose scheduled removal is due and reports each of them to
+// the expiration callback.
+func (c *bmemCache[T]) expireDue() {
+	expired := make(map[string]*cacheEntry[T])
+	c.mu.Lock()
+	if c.frozen {
+		c.mu.Unlock()
+		return
+	}
+	// Entries are due once they have been expired for the retention period.
+	due := time.Now().Add(-c.settings().expiredRetention)
+	for len(c.expiries) > 0 && !c.expiries[0].entry.Exp.After(due) {
+		item := heap.Pop(&c.expiries).(expiryItem[T])
+		if c.items[item.key] == item.entry {
+			c.removeLocked(item.key)
+			expired[item.key] = item.entry
+		}
+	}
+	c.mu.Unlock()
+	c.notifyExpireAll(expired)
+}
@@ -0,0 +1,107 @@
+package bmemcache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// flight is a load in progress for a single cache key, shared by every caller waiting on it.
+type flight[T any] struct {
+	wg   sync.WaitGroup
+	data T
+	err  error
+}
+
+// flightGroup coalesces concurrent loads of the same key so that the loader runs once and
+// every caller shares its result.
+type flightGroup[T any] struct {
+	mu      sync.Mutex
+	flights map[string]*flight[T]
+}
+
+// do runs fn for the given serialized key, unless a load of the same key is already in
+// progress, in which case it waits for that load and returns its result instead.
+//
+// If fn panics, the panic is propagated to the caller that ran it, while the callers waiting
+// on it receive an error. If timeout is positive and fn has not returned by then, every
+// caller receives an error wrapping context.DeadlineExceeded, and the load is forgotten so
+// that later calls load again, while fn keeps running in the background.
+func (g *flightGroup[T]) do(key string, timeout time.Duration, fn func() (T, error)) (T, error) {
+	g.mu.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		f.wg.Wait()
+		return f.data, f.err
+	}
+	if g.flights == nil {
+		g.flights = make(map[string]*flight[T])
+	}
+	f := &flight[T]{}
+	f.wg.Add(1)
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	if timeout > 0 {
+		return g.doWithTimeout(key, f, timeout, fn)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			f.err = fmt.Errorf("bmemcache: loader panicked: %v", r)
+			g.finish(key, f)
+			panic(r)
+		}
+		g.finish(key, f)
+	}()
+	f.data, f.err = fn()
+	return f.data, f.err
+}
+
+// finish releases the callers waiting on f and forgets it, so that later calls load again.
+func (g *flightGroup[T]) finish(key string, f *flight[T]) {
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	f.wg.Done()
+}
+
+// doWithTimeout runs fn for the flight f in its own goroutine, giving up on it once timeout has
+// elapsed. A panic of fn is propagated to the caller unless it happens after the timeout, in
+// which case it is dropped.
+func (g *flightGroup[T]) doWithTimeout(key string, f *flight[T], timeout time.Duration, fn func() (T, error)) (T, error) {
+	type result struct {
+		data     T
+		err      error
+		panicked bool
+		r        any
+	}
+	done := make(chan result, 1)
+	go func() {
+		res := result{panicked: true}
+		defer func() {
+			if res.panicked {
+				res.r = recover()
+			}
+			done <- res
+		}()
+		res.data, res.err = fn()
+		res.panicked = false
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		if res.panicked {
+			f.err = fmt.Errorf("bmemcache: loader panicked: %v", res.r)
+			g.finish(key, f)
+			panic(res.r)
+		}
+		f.data, f.err = res.data, res.err
+	case <-timer.C:
+		f.err = fmt.Errorf("bmemcache: loader timed out after %v: %w", timeout, context.DeadlineExceeded)
+	}
+	g.finish(key, f)
+	return f.data, f.err
+}
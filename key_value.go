@@ -0,0 +1,31 @@
+package bmemcache
+
+import "time"
+
+// KeyValueExp is a transport-neutral representation of a cached item.
+type KeyValueExp[T any] struct {
+	// Keys holds the parts of the cache key.
+	Keys []string
+	// Value holds the cached data.
+	Value T
+	// ExpiresAt is the time at which the item expires, or the zero time if it never expires.
+	ExpiresAt time.Time
+}
+
+// EntryWithExp is an item to be stored with SetManyWithExp, or an item returned by Drain.
+type EntryWithExp[T any] struct {
+	// Keys holds the parts of the cache key.
+	Keys []string
+	// Data holds the data to cache.
+	Data T
+	// Duration is the duration after which the data expires, or zero if it never expires.
+	Duration time.Duration
+}
+
+// PrefixEntry is an item returned by EntriesFromPrefix.
+type PrefixEntry[T any] struct {
+	// Keys holds the parts of the cache key.
+	Keys []string
+	// Value holds the cached data.
+	Value T
+}
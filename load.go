@@ -0,0 +1,42 @@
+package bmemcache
+
+import "context"
+
+// loadBatchSize is the maximum number of items LoadFrom stores under a single lock.
+const loadBatchSize = 256
+
+func (c *bmemCache[T]) LoadFrom(ctx context.Context, ch <-chan EntryWithExp[T]) error {
+	return loadFrom(ctx, ch, func(batch []EntryWithExp[T]) { _ = c.setMany(batch) })
+}
+
+// loadFrom consumes ch until it is closed or ctx is done, passing the received items to store
+// in batches. Each batch holds the items already waiting in the channel, so that items are
+// stored as soon as they arrive while bursts are stored together.
+func loadFrom[T any](ctx context.Context, ch <-chan EntryWithExp[T], store func(batch []EntryWithExp[T])) error {
+	batch := make([]EntryWithExp[T], 0, loadBatchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case entry, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			batch = append(batch[:0], entry)
+		}
+	drain:
+		for len(batch) < loadBatchSize {
+			select {
+			case entry, ok := <-ch:
+				if !ok {
+					store(batch)
+					return nil
+				}
+				batch = append(batch, entry)
+			default:
+				break drain
+			}
+		}
+		store(batch)
+	}
+}
@@ -0,0 +1,83 @@
+package bmemcache
+
+import (
+	"strconv"
+	"time"
+)
+
+// Op identifies the operation reported by an Observation.
+type Op int
+
+const (
+	// OpSet reports data stored by the Set family, SetManyWithExp, LoadFrom or GetOrSet.
+	OpSet Op = iota + 1
+	// OpGet reports a Get that found an unexpired item.
+	OpGet
+	// OpMiss reports a Get that found no unexpired item.
+	OpMiss
+	// OpDelete reports an item removed by Delete.
+	OpDelete
+	// OpExpire reports an item removed because its TTL lapsed.
+	OpExpire
+	// OpEvict reports a live item removed by EvictFraction or by a WithCapacity policy.
+	OpEvict
+	// OpClear reports the removal of every item by Clear.
+	OpClear
+)
+
+// String returns the name of the operation, e.g. "Set".
+func (op Op) String() string {
+	switch op {
+	case OpSet:
+		return "Set"
+	case OpGet:
+		return "Get"
+	case OpMiss:
+		return "Miss"
+	case OpDelete:
+		return "Delete"
+	case OpExpire:
+		return "Expire"
+	case OpEvict:
+		return "Evict"
+	case OpClear:
+		return "Clear"
+	default:
+		return "Op(" + strconv.Itoa(int(op)) + ")"
+	}
+}
+
+// Observation describes an operation reported to the observer configured with WithObserver.
+type Observation[T any] struct {
+	// Op is the operation.
+	Op Op
+	// Keys holds the key parts of the item. It is nil for OpClear.
+	Keys []string
+	// Value holds the data stored by OpSet, read by OpGet, or held by the item removed by
+	// OpDelete, OpExpire and OpEvict. It is the zero value for OpMiss and OpClear.
+	Value T
+	// TTL is the remaining time before the item expires for OpSet and OpGet: 0 if it does not
+	// expire, or a negative duration if it was stored already expired. It is zero for the other
+	// operations.
+	TTL time.Duration
+	// Err is the reason of an OpMiss: ErrNotFound or ErrExpired. It is nil for the other
+	// operations.
+	Err error
+}
+
+// observe reports the operation to the observer, if any. It must be called without holding the
+// lock.
+func (c *bmemCache[T]) observe(ev Observation[T]) {
+	if c.observer != nil {
+		c.observer(ev)
+	}
+}
+
+// observeEntry reports an operation on the given entry to the observer, if any. It must be
+// called without holding the lock.
+func (c *bmemCache[T]) observeEntry(op Op, keys []string, entry *cacheEntry[T]) {
+	if c.observer != nil {
+		data, _ := c.value(entry) // the value is best effort if it cannot be decoded
+		c.observer(Observation[T]{Op: op, Keys: keys, Value: data})
+	}
+}
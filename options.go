@@ -1,6 +1,10 @@
 package bmemcache
 
-import "time"
+import (
+	"fmt"
+	"io"
+	"time"
+)
 
 // Option defines a function that configures cache options.
 type Option interface {
@@ -10,12 +14,89 @@ type Option interface {
 
 // option holds configuration settings for the cache.
 type option struct {
+	// Name identifies the cache in logs and metrics.
+	Name string
 	// AutoCleanup enables the background cleanup of expired cache entries.
 	AutoCleanup bool
 	// AutoCleanupInterval defines the interval between automatic cleanup operations.
 	AutoCleanupInterval time.Duration
+	// CleanupEveryNWrites is the number of writes triggering a cleanup. Zero disables it.
+	CleanupEveryNWrites int
+	// WALWriter is the writer the write-ahead log is appended to. Nil disables it.
+	WALWriter io.Writer
+	// WALInterval is the interval between flushes of the write-ahead log.
+	WALInterval time.Duration
+	// EagerExpiration enables the removal of each entry as soon as it expires.
+	EagerExpiration bool
+	// BloomExpectedN is the number of keys the bloom filter is sized for. Zero disables it.
+	BloomExpectedN uint
+	// BloomFPRate is the false positive rate of the bloom filter at BloomExpectedN keys.
+	BloomFPRate float64
+	// SyncMapBackend mirrors the entries in a sync.Map so that lookups do not take the lock.
+	SyncMapBackend bool
 	// CacheKeySeparator is the string used to separate keys when generating the cache key.
 	CacheKeySeparator string
+	// InitialCapacity is the number of entries the cache storage is presized for.
+	InitialCapacity int
+	// MaxEntries is the maximum number of entries the cache accepts. Zero means unlimited.
+	MaxEntries int
+	// OverflowPolicy selects what happens to new entries once MaxEntries is reached.
+	OverflowPolicy OverflowPolicy
+	// PrefixLimits caps the number of entries under each registered key prefix.
+	PrefixLimits []prefixLimit
+	// MaxValueSize is the maximum size of a value, as measured by SizeOf. Zero means unlimited.
+	MaxValueSize int64
+	// MinTTL is the minimum time an entry set with a positive duration lives.
+	MinTTL time.Duration
+	// MaxTTL is the maximum time an entry may live. Zero means unlimited.
+	MaxTTL time.Duration
+	// TimeToIdle is how long an entry lives without being read. Zero means unlimited.
+	TimeToIdle time.Duration
+	// MaxKeyParts is the maximum number of parts a key may have. Zero means unlimited.
+	MaxKeyParts int
+	// DisallowEmptyKeys rejects keys without any part.
+	DisallowEmptyKeys bool
+	// KeyValidator is called with the key parts of every write and single-key lookup.
+	KeyValidator func(parts []string) error
+	// ExpiredRetention is how long expired entries are kept before being removed.
+	ExpiredRetention time.Duration
+	// DisableLazyDeleteOnGet prevents Get from removing the expired entries it encounters.
+	DisableLazyDeleteOnGet bool
+	// KeyStats enables the tracking of per-entry access statistics.
+	KeyStats bool
+	// MetricsCallback is called after each instrumented operation.
+	MetricsCallback func(op string, hit bool, durationNs int64)
+	// OnOverwrite is called with the key parts of every Set that replaces an unexpired entry.
+	OnOverwrite func(keys []string)
+	// OnMiss is called with the key parts and the reason of every missed lookup.
+	OnMiss func(keys []string, reason error)
+	// Observer holds a func(ev Observation[T]) invoked after each observed operation.
+	Observer any
+	// OnExpire holds a func(keys []string, value T) invoked when an entry lapses due to its TTL.
+	OnExpire any
+	// OnSet holds a func(keys []string, value T, ttl time.Duration) invoked after each store.
+	OnSet any
+	// LoaderTimeout bounds how long GetOrSet waits for its loader. Zero means no limit.
+	LoaderTimeout time.Duration
+	// LoaderConcurrency caps the concurrent reloads of RefreshFunc. Zero means no limit.
+	LoaderConcurrency int
+	// RefreshLead is how long before their expiration entries are reloaded by RefreshFunc.
+	RefreshLead time.Duration
+	// RefreshFunc holds a func(keys []string) (T, time.Duration, error) reloading the entries
+	// about to expire.
+	RefreshFunc any
+	// ValueTTLFunc holds a func(T) time.Duration deriving the duration Set stores values with.
+	ValueTTLFunc any
+	// SizeOf holds a func(T) int64 used to measure the size of values.
+	SizeOf any
+	// Indexes holds the func(T) string extracting the indexed field of values, by index name.
+	Indexes map[string]any
+	// CopyOnGet holds a func(T) T used to clone values before returning them to callers.
+	CopyOnGet any
+	// ValueEncoder holds a func(T) ([]byte, error) used to encode values before storing them.
+	ValueEncoder any
+	// ValueDecoder holds a func([]byte) (T, error) used to decode stored values.
+	ValueDecoder any
 }
 
 // WithAutoCleanUp enables auto-cleanup and sets the cleanup interval.
@@ -41,3 +122,1011 @@ func (w *withAutoCleanUp) Apply(o *option) {
 		o.AutoCleanupInterval = time.Minute
 	}
 }
+
+// WithCleanupEveryNWrites removes the expired entries after every n successful writes.
+//
+// This adapts the cleanup to the activity of the cache: a busy cache is swept promptly, while
+// an idle one does not spend any CPU on it. Writes of the Set family, including the values
+// stored by GetOrSet, are counted, and the write reaching the count performs the sweep before
+// returning, reporting the removed entries to WithOnExpire. It can be used on its own or
+// combined with WithAutoCleanUp.
+//
+// Parameters:
+//   - n: The number of writes between sweeps. A value of 0 or less disables it.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithCleanupEveryNWrites(n int) Option {
+	return &withCleanupEveryNWrites{n: n}
+}
+
+type withCleanupEveryNWrites struct {
+	n int
+}
+
+// Apply sets the number of writes between sweeps.
+func (w *withCleanupEveryNWrites) Apply(o *option) {
+	o.CleanupEveryNWrites = w.n
+}
+
+// WithWriteThrough appends every change of the cache content to a write-ahead log, which
+// Replay can apply to a fresh cache, e.g. on startup.
+//
+// Writes do not block on the log: changes are queued and written to w by a background
+// goroutine on every interval, as JSON lines, so a crash loses at most an interval of changes.
+// Close stops the goroutine after a final flush. Every change is logged, including removals of
+// expired entries and the changes made by Update, Import and ReplaceAll. Values are encoded
+// with encoding/json, so T must round-trip through it; values that fail to encode and write
+// errors are dropped.
+//
+// Parameters:
+//   - w: The writer to append the log to, such as a file opened with os.O_APPEND.
+//   - interval: The interval between flushes. Zero defaults to one second.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithWriteThrough(w io.Writer, interval time.Duration) Option {
+	return &withWriteThrough{w: w, interval: interval}
+}
+
+type withWriteThrough struct {
+	w        io.Writer
+	interval time.Duration
+}
+
+// Apply sets the write-ahead log options.
+func (w *withWriteThrough) Apply(o *option) {
+	o.WALWriter = w.w
+	o.WALInterval = w.interval
+	if w.interval == 0 {
+		o.WALInterval = time.Second
+	}
+}
+
+// WithEagerExpiration enables the removal of each entry as soon as it expires.
+//
+// Auto-cleanup removes expired entries on a fixed interval, so an entry may linger for up to
+// a whole interval after it expired. With eager expiration, entries with an expiration are
+// kept in a min-heap and a single timer, set to the nearest expiration, removes them at their
+// exact expiration time, reporting them to WithOnExpire. This costs a heap operation on every
+// write of an entry with an expiration.
+//
+// Like auto-cleanup, eager expiration runs in a goroutine stopped by Close, and it can be
+// combined with it.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithEagerExpiration() Option {
+	return &withEagerExpiration{}
+}
+
+type withEagerExpiration struct{}
+
+// Apply enables eager expiration.
+func (w *withEagerExpiration) Apply(o *option) {
+	o.EagerExpiration = true
+}
+
+// WithSyncMapBackend optimizes the cache for read-mostly workloads, e.g. a mostly static set
+// of entries read from many goroutines.
+//
+// By default, every lookup takes the read lock of the cache, which contends between cores
+// under heavy parallel reads. With this option, the entries are also indexed in a sync.Map, so
+// that lookups (Get, Peek, IsExist, TTL, ...) do not take any lock.
+//
+// This comes at a cost:
+//   - Every write also updates the sync.Map, which is slower than a plain map.
+//   - The index takes additional memory per entry, although the entries themselves are shared.
+//   - Clear, ReplaceAll and Reset rebuild the index as a whole.
+//
+// Operations iterating over the entries (Keys, the cleanup, ...) still use the regular storage
+// under the lock, so they are not affected.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithSyncMapBackend() Option {
+	return &withSyncMapBackend{}
+}
+
+type withSyncMapBackend struct{}
+
+// Apply enables the sync.Map index.
+func (w *withSyncMapBackend) Apply(o *option) {
+	o.SyncMapBackend = true
+}
+
+// WithBloomFilter speeds up lookups of keys that were never stored, e.g. when the cache fronts
+// a large backing store and most lookups miss.
+//
+// Every key written to the cache is recorded in a bloom filter, which lookups (Get, Peek,
+// IsExist, TTL, ...) consult before taking the lock. A key the filter has never seen is
+// reported as not found right away. The filter has no false negatives, but may let through
+// keys that were never stored, at about the given rate once expectedN keys are recorded, and
+// more often beyond.
+//
+// The filter cannot forget keys: once a key is removed by Delete, expiration or eviction, its
+// lookups take the regular path again, so removals make the filter more conservative over
+// time. Clear, ReplaceAll, Drain and Reset rebuild it from the remaining keys.
+//
+// GetOrSet still runs the loader for keys the filter rules out, since they are missing from
+// the cache rather than from the data it caches.
+//
+// Parameters:
+//   - expectedN: The number of distinct keys the filter is sized for. Zero disables it.
+//   - fpRate: The false positive rate at expectedN keys, between 0 and 1 exclusive. Other
+//     values mean 1%.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithBloomFilter(expectedN uint, fpRate float64) Option {
+	return &withBloomFilter{expectedN: expectedN, fpRate: fpRate}
+}
+
+type withBloomFilter struct {
+	expectedN uint
+	fpRate    float64
+}
+
+// Apply sets the size and false positive rate of the bloom filter.
+func (w *withBloomFilter) Apply(o *option) {
+	o.BloomExpectedN = w.expectedN
+	o.BloomFPRate = w.fpRate
+}
+
+// WithInitialCapacity presizes the cache storage for the given number of entries.
+//
+// This avoids repeated growth of the underlying map while bulk loading a known
+// number of entries. It does not limit how many entries the cache can hold.
+//
+// Parameters:
+//   - n: The expected number of entries. Zero or negative values keep the default sizing.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithInitialCapacity(n int) Option {
+	return &withInitialCapacity{n: n}
+}
+
+type withInitialCapacity struct {
+	n int
+}
+
+// Apply sets the initial capacity.
+func (w *withInitialCapacity) Apply(o *option) {
+	o.InitialCapacity = 0
+	if w.n > 0 {
+		o.InitialCapacity = w.n
+	}
+}
+
+// WithMaxEntriesReject limits the number of entries the cache can hold, rejecting new
+// entries once the limit is reached instead of evicting existing ones.
+//
+// When the cache is full, expired entries are reclaimed first. If the cache is still full,
+// TrySet and TrySetWithExp return ErrFull, while Set and SetWithExp silently discard the
+// data. Overwriting an existing key is always allowed.
+//
+// It is equivalent to WithCapacity(n, RejectNew).
+//
+// Parameters:
+//   - n: The maximum number of entries. Zero or negative values mean unlimited.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithMaxEntriesReject(n int) Option {
+	return &withMaxEntriesReject{n: n}
+}
+
+type withMaxEntriesReject struct {
+	n int
+}
+
+// Apply sets the maximum number of entries.
+func (w *withMaxEntriesReject) Apply(o *option) {
+	o.MaxEntries = 0
+	if w.n > 0 {
+		o.MaxEntries = w.n
+	}
+	o.OverflowPolicy = RejectNew
+}
+
+// WithCapacity limits the number of entries the cache can hold, handling writes of new keys
+// once the limit is reached according to the given policy.
+//
+// When the cache is full, expired entries are reclaimed first. If the cache is still full,
+// the policy either rejects the new entry, like WithMaxEntriesReject, or evicts an existing
+// one to make room. Evicted entries are counted in Stats.Evictions and reported to
+// WithObserver as OpEvict, but not to WithOnExpire. Overwriting an existing key never evicts.
+//
+// Selecting the entry to evict scans every entry, so each write of a new key to a full cache
+// costs O(n). EvictLRU and EvictLFU also track the access statistics of every entry, like
+// WithKeyStats does.
+//
+// Parameters:
+//   - n: The maximum number of entries. Zero or negative values mean unlimited.
+//   - policy: What to do with a new key once the cache is full.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithCapacity(n int, policy OverflowPolicy) Option {
+	return &withCapacity{n: n, policy: policy}
+}
+
+type withCapacity struct {
+	n      int
+	policy OverflowPolicy
+}
+
+// Apply sets the maximum number of entries and the overflow policy.
+func (w *withCapacity) Apply(o *option) {
+	o.MaxEntries = 0
+	if w.n > 0 {
+		o.MaxEntries = w.n
+	}
+	o.OverflowPolicy = w.policy
+}
+
+// WithMaxKeyParts limits the number of parts a cache key may have.
+//
+// TrySet and TrySetWithExp return ErrTooManyKeyParts for keys with more than n parts, while
+// Set and SetWithExp discard the data. Single-key lookups such as Get, Delete and TTL apply
+// the same limit and return ErrTooManyKeyParts, so a lookup never silently differs from the
+// corresponding write. Prefix matching is not validated: since no stored key can have more
+// than n parts, a prefix with more than n parts simply matches nothing.
+//
+// Parameters:
+//   - n: The maximum number of key parts. Zero or negative values mean unlimited.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithMaxKeyParts(n int) Option {
+	return &withMaxKeyParts{n: n}
+}
+
+type withMaxKeyParts struct {
+	n int
+}
+
+// Apply sets the maximum number of key parts.
+func (w *withMaxKeyParts) Apply(o *option) {
+	o.MaxKeyParts = 0
+	if w.n > 0 {
+		o.MaxKeyParts = w.n
+	}
+}
+
+// WithAllowEmptyKeys controls whether keys without any part, as in Set(data) or Get(), are
+// valid.
+//
+// By default (allowed), calling a method without key parts addresses a single entry stored
+// under the empty key. When disallowed, the writes and single-key lookups given no key parts
+// fail with ErrEmptyKey instead, through the error-returning variants such as TrySet and Get.
+// Prefix and suffix matching are unaffected: KeysFromPrefix() and GetsFromPrefix() without
+// parts still match every key.
+//
+// Parameters:
+//   - allowed: Whether keys without any part are valid.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithAllowEmptyKeys(allowed bool) Option {
+	return &withAllowEmptyKeys{allowed: allowed}
+}
+
+type withAllowEmptyKeys struct {
+	allowed bool
+}
+
+// Apply sets whether keys without any part are valid.
+func (w *withAllowEmptyKeys) Apply(o *option) {
+	o.DisallowEmptyKeys = !w.allowed
+}
+
+// WithKeyValidator sets a function enforcing invariants on cache keys.
+//
+// The validator is called with the key parts of every write and single-key lookup, after
+// the WithMaxKeyParts check. When it returns an error, TrySet and TrySetWithExp return that
+// error and Set and SetWithExp discard the data, while lookups such as Get, Delete and TTL
+// return the error without touching the cache. Prefix matching is not validated.
+//
+// Parameters:
+//   - fn: The function returning a non-nil error for invalid key parts.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithKeyValidator(fn func(parts []string) error) Option {
+	return &withKeyValidator{fn: fn}
+}
+
+type withKeyValidator struct {
+	fn func(parts []string) error
+}
+
+// Apply sets the key validator.
+func (w *withKeyValidator) Apply(o *option) {
+	o.KeyValidator = w.fn
+}
+
+// WithLazyDeleteOnGet controls whether Get removes the expired entries it encounters.
+//
+// By default (enabled), Get upgrades to the write lock to remove an expired entry, which
+// reclaims its memory immediately but makes the read contend with writers. When disabled,
+// Get only ever takes the read lock and returns ErrExpired without touching the entry,
+// leaving it in memory until the auto-cleanup removes it. Disabling it is therefore only
+// advisable together with WithAutoCleanUp. Peek offers the same behavior per call.
+//
+// Parameters:
+//   - enabled: Whether Get removes expired entries.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithLazyDeleteOnGet(enabled bool) Option {
+	return &withLazyDeleteOnGet{enabled: enabled}
+}
+
+type withLazyDeleteOnGet struct {
+	enabled bool
+}
+
+// Apply sets whether Get removes expired entries.
+func (w *withLazyDeleteOnGet) Apply(o *option) {
+	o.DisableLazyDeleteOnGet = !w.enabled
+}
+
+// WithExpiredRetention keeps expired entries in the cache for a grace period before removing
+// them, so that recently expired values can still be inspected, e.g. while debugging.
+//
+// During the retention period, an expired entry behaves as expired for every operation (Get
+// returns ErrExpired, IsValid returns false, and so on) except GetStale, which still returns
+// its value flagged as stale. Once the period has elapsed, it is removed by Get, the
+// auto-cleanup or the eager expiration as usual, and only then reported to WithOnExpire.
+//
+// Retained entries keep occupying memory, so a long retention on a cache with many
+// short-lived entries grows it accordingly. When WithMaxEntriesReject or WithCapacity is
+// configured, retained entries are reclaimed early rather than making the cache reject writes
+// or evict live entries.
+//
+// Parameters:
+//   - retention: How long expired entries are retained. Zero removes them right away.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithExpiredRetention(retention time.Duration) Option {
+	return &withExpiredRetention{retention: retention}
+}
+
+type withExpiredRetention struct {
+	retention time.Duration
+}
+
+// Apply sets the retention period of expired entries.
+func (w *withExpiredRetention) Apply(o *option) {
+	o.ExpiredRetention = w.retention
+}
+
+// WithName sets the name of the cache, returned by Name() and reported by String().
+//
+// Naming caches makes them distinguishable in logs and in the metrics exported by the
+// bmemcacheprom and bmemcacheotel modules when a process runs several of them.
+//
+// Parameters:
+//   - name: The name of the cache.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithName(name string) Option {
+	return &withName{name: name}
+}
+
+type withName struct {
+	name string
+}
+
+// Apply sets the name of the cache.
+func (w *withName) Apply(o *option) {
+	o.Name = w.name
+}
+
+// WithMaxTTL caps how long any entry may live.
+//
+// Every expiration is clamped to at most max from the time it is set: durations passed to
+// SetWithExp and the like are reduced to max, absolute times passed to SetWithExpireAt,
+// ExpireAt and Import are brought forward accordingly, and entries stored without expiration
+// (e.g. with Set) expire after max as well.
+//
+// Parameters:
+//   - max: The maximum time an entry may live. A value of 0 or less disables the cap.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithMaxTTL(max time.Duration) Option {
+	return &withMaxTTL{max: max}
+}
+
+type withMaxTTL struct {
+	max time.Duration
+}
+
+// Apply sets the maximum time an entry may live.
+func (w *withMaxTTL) Apply(o *option) {
+	o.MaxTTL = w.max
+}
+
+// WithPrefixLimit caps the number of entries whose keys start with the given prefix, e.g. to
+// keep the tenants sharing a cache through a per-tenant key prefix from evicting each other.
+//
+// Once the partition of the prefix is full, a write of a new key within it is handled
+// according to the policy set with WithCapacity, but only ever evicts entries of the same
+// partition: RejectNew, the default, rejects it like a full cache does. Expired entries are
+// reclaimed first. Keys matching several registered prefixes count against the longest one.
+//
+// Keys matching no registered prefix share the budget of the whole cache, as set with
+// WithCapacity or WithMaxEntriesReject, which partitions count against too: when the whole
+// cache is full, the entry evicted may belong to any partition.
+//
+// The option can be given several times to register several prefixes, and like WithCapacity,
+// it is reapplied by Reset.
+//
+// Parameters:
+//   - prefix: The key parts the keys of the partition start with.
+//   - maxEntries: The maximum number of entries of the partition. Zero or negative values
+//     leave the partition unlimited.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithPrefixLimit(prefix []string, maxEntries int) Option {
+	return &withPrefixLimit{prefix: append([]string(nil), prefix...), maxEntries: maxEntries}
+}
+
+type withPrefixLimit struct {
+	prefix     []string
+	maxEntries int
+}
+
+// Apply registers the limit of the prefix.
+func (w *withPrefixLimit) Apply(o *option) {
+	if w.maxEntries > 0 {
+		o.PrefixLimits = append(o.PrefixLimits, newPrefixLimit(w.prefix, w.maxEntries))
+	}
+}
+
+// WithTimeToIdle expires entries that have not been read for the given duration, e.g. to
+// reclaim idle sessions before the end of their maximum lifetime.
+//
+// Every entry gets an idle deadline, set to d after it is stored and pushed back to d after
+// each read that finds it unexpired (Get, GetOrSet, ...). Reads that do not update the access
+// metadata, such as Peek, leave it untouched. The expiration set with SetWithExp and the like
+// remains a hard cap: an entry expires as soon as either deadline passes, so TTL and ExpiresAt
+// report the earliest of them.
+//
+// WithEagerExpiration only schedules the hard expiration. Idle entries are removed like other
+// expired entries by lazy deletion on Get and by the auto-cleanup.
+//
+// Parameters:
+//   - d: How long an entry lives without being read. A value of 0 or less disables it.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithTimeToIdle(d time.Duration) Option {
+	return &withTimeToIdle{d: d}
+}
+
+type withTimeToIdle struct {
+	d time.Duration
+}
+
+// Apply sets how long an entry lives without being read.
+func (w *withTimeToIdle) Apply(o *option) {
+	o.TimeToIdle = 0
+	if w.d > 0 {
+		o.TimeToIdle = w.d
+	}
+}
+
+// WithMinTTL sets a floor on the durations entries are stored with.
+//
+// Positive durations passed to SetWithExp and the like that are shorter than min are raised
+// to min. A zero duration still means no expiration, and absolute times passed to
+// SetWithExpireAt, ExpireAt and Import are left untouched.
+//
+// When combined with WithMaxTTL, min must not exceed max, otherwise New panics.
+//
+// Parameters:
+//   - min: The minimum duration. A value of 0 or less disables the floor.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithMinTTL(min time.Duration) Option {
+	return &withMinTTL{min: min}
+}
+
+type withMinTTL struct {
+	min time.Duration
+}
+
+// Apply sets the minimum duration entries are stored with.
+func (w *withMinTTL) Apply(o *option) {
+	o.MinTTL = w.min
+}
+
+// WithKeyStats enables the tracking of per-entry access statistics reported by KeyStats.
+//
+// Every entry records when it was stored, and every lookup finding it unexpired updates its
+// hit count and last access time. This costs an allocation per write and two atomic operations
+// per hit, so it is disabled by default.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithKeyStats() Option {
+	return &withKeyStats{}
+}
+
+type withKeyStats struct{}
+
+// Apply enables per-entry access statistics.
+func (w *withKeyStats) Apply(o *option) {
+	o.KeyStats = true
+}
+
+// WithMetricsCallback sets a callback invoked after each operation on a single key, for
+// pushing metrics to any sink without extra dependencies.
+//
+// The callback receives the name of the method (e.g. "Get", "SetWithExp" or "Delete"), whether
+// the operation succeeded and how long it took in nanoseconds. For lookups, success means an
+// unexpired item was found, or any item for GetStale; for writes, that the data was stored, or
+// every item of the batch for SetManyWithExp; for Update, Delete and the expiration updates,
+// that an unexpired item was there to modify; and for DeleteMany, that every item was there to
+// remove. Each call reports a single operation, even if it is implemented on top of another.
+// The callback runs outside the cache lock, on the calling goroutine, so it should be cheap.
+// When it is not configured, the cost of the instrumentation is a nil check per operation.
+//
+// Parameters:
+//   - fn: The function to call with the name, outcome and duration of each operation.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithMetricsCallback(fn func(op string, hit bool, durationNs int64)) Option {
+	return &withMetricsCallback{fn: fn}
+}
+
+type withMetricsCallback struct {
+	fn func(op string, hit bool, durationNs int64)
+}
+
+// Apply sets the metrics callback.
+func (w *withMetricsCallback) Apply(o *option) {
+	o.MetricsCallback = w.fn
+}
+
+// WithPanicOnOverwrite is a debugging aid that reports writes clobbering an existing entry.
+//
+// Whenever a write of the Set family (Set, TrySet, SetWithExp, SetWithExpireAt, ...) replaces an
+// entry that has not expired yet, handler is called with the key parts, after the write has
+// completed and outside the cache lock. A nil handler panics instead, which surfaces accidental
+// key collisions immediately in tests. Writes meant to modify existing entries, such as Update
+// and Import, are never reported.
+//
+// Parameters:
+//   - handler: The function to call with the key parts of each overwritten entry, or nil to
+//     panic.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithPanicOnOverwrite(handler func(keys []string)) Option {
+	return &withPanicOnOverwrite{handler: handler}
+}
+
+type withPanicOnOverwrite struct {
+	handler func(keys []string)
+}
+
+// Apply sets the overwrite handler.
+func (w *withPanicOnOverwrite) Apply(o *option) {
+	o.OnOverwrite = w.handler
+	if o.OnOverwrite == nil {
+		o.OnOverwrite = func(keys []string) {
+			panic(fmt.Sprintf("bmemcache: overwrite of unexpired key %q", keys))
+		}
+	}
+}
+
+// WithOnMiss sets a callback invoked whenever a lookup misses, to log or sample cold reads.
+//
+// The callback fires when Get, GetTimeout or GetAndRefresh finds no unexpired entry, as well
+// as on the misses of the lookups that Gets, GetsFromPrefix and the GetOrSet family make
+// through Get. It receives the key parts and the reason of the miss: ErrNotFound if there is
+// no entry, or ErrExpired if it has expired. Rejected keys are not misses. The callback runs
+// outside the cache lock, so it may safely call back into the cache.
+//
+// Parameters:
+//   - fn: The function to call with the key parts and reason of each miss.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithOnMiss(fn func(keys []string, reason error)) Option {
+	return &withOnMiss{fn: fn}
+}
+
+type withOnMiss struct {
+	fn func(keys []string, reason error)
+}
+
+// Apply sets the miss callback.
+func (w *withOnMiss) Apply(o *option) {
+	o.OnMiss = w.fn
+}
+
+// WithObserver sets a single callback reporting the operations on the cache, as an
+// alternative to configuring WithOnSet, WithOnMiss, WithOnExpire and friends separately.
+//
+// The callback receives an Observation for each stored item (OpSet), Get hit (OpGet) or miss
+// (OpMiss), item removed by Delete (OpDelete), expiration (OpExpire) or eviction (OpEvict), and
+// for Clear (OpClear). OpSet and OpExpire fire in the same circumstances as WithOnSet and
+// WithOnExpire, and OpGet and OpMiss for Get and the lookups made through it, as with
+// WithOnMiss. See Observation for the fields populated for each operation. The callback runs
+// outside the cache lock, so it may safely call back into the cache, and can be combined with
+// the other callbacks.
+//
+// The type parameter must match the type parameter of the cache, otherwise New panics.
+//
+// Parameters:
+//   - fn: The function to call with each observation.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithObserver[T any](fn func(ev Observation[T])) Option {
+	return &withObserver[T]{fn: fn}
+}
+
+type withObserver[T any] struct {
+	fn func(ev Observation[T])
+}
+
+// Apply sets the observer.
+func (w *withObserver[T]) Apply(o *option) {
+	if w.fn != nil {
+		o.Observer = w.fn
+	}
+}
+
+// WithOnExpire sets a callback invoked when an entry is removed because its TTL lapsed.
+//
+// The callback fires when Get encounters an expired entry and when the background auto-cleanup
+// or eager expiration removes one. It receives the key parts and the last-known value of the
+// entry. It is not an eviction hook: explicit removals through Delete or Clear never trigger
+// it. The callback runs outside the cache lock, so it may safely call back into the cache.
+//
+// The type parameter must match the type parameter of the cache, otherwise New panics.
+//
+// Parameters:
+//   - fn: The function to call with the key parts and value of each expired entry.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithOnExpire[T any](fn func(keys []string, value T)) Option {
+	return &withOnExpire[T]{fn: fn}
+}
+
+type withOnExpire[T any] struct {
+	fn func(keys []string, value T)
+}
+
+// Apply sets the expiration callback.
+func (w *withOnExpire[T]) Apply(o *option) {
+	if w.fn != nil {
+		o.OnExpire = w.fn
+	}
+}
+
+// WithOnSet sets a callback invoked after each successful write of the Set family.
+//
+// The callback fires once a write through Set, TrySet, SetWithExp, TrySetWithExp,
+// SetWithExpireAt, GetSet, SwapWithExp or SetIfNewer has been stored, as well as when the
+// GetOrSet family stores the value returned by its loader. Bulk writes (Import, ReplaceAll)
+// and modifications of existing entries (Update and the expiration updates) do not trigger it,
+// nor do rejected writes. It receives the key parts, the stored value and its effective TTL,
+// after WithMinTTL and WithMaxTTL have been applied: 0 if the value does not expire, or a
+// negative duration if it was stored already expired. The callback runs outside the cache lock,
+// so it may safely call back into the cache, e.g. to mirror writes to another cache.
+//
+// The type parameter must match the type parameter of the cache, otherwise New panics.
+//
+// Parameters:
+//   - fn: The function to call with the key parts, value and TTL of each stored entry.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithOnSet[T any](fn func(keys []string, value T, ttl time.Duration)) Option {
+	return &withOnSet[T]{fn: fn}
+}
+
+type withOnSet[T any] struct {
+	fn func(keys []string, value T, ttl time.Duration)
+}
+
+// Apply sets the write callback.
+func (w *withOnSet[T]) Apply(o *option) {
+	if w.fn != nil {
+		o.OnSet = w.fn
+	}
+}
+
+// WithLoaderTimeout bounds how long the GetOrSet family waits for its loader, so that a hung
+// loader does not block every coalesced caller forever.
+//
+// When a load takes longer than the timeout, every caller waiting on it receives an error
+// wrapping context.DeadlineExceeded, and the next call for the same keys starts a new load.
+// The result of the timed out loader is never stored, so that it cannot overwrite the result
+// of a newer load. GetOrSetCtx also cancels the context of its loader at the timeout; the
+// loaders of GetOrSet and GetOrSetWithExp take no context, so they keep running in the
+// background until they return.
+//
+// Parameters:
+//   - timeout: The maximum duration to wait for a loader. Zero means no limit.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithLoaderTimeout(timeout time.Duration) Option {
+	return &withLoaderTimeout{timeout: timeout}
+}
+
+type withLoaderTimeout struct {
+	timeout time.Duration
+}
+
+// Apply sets the loader timeout.
+func (w *withLoaderTimeout) Apply(o *option) {
+	o.LoaderTimeout = w.timeout
+}
+
+// WithLoaderConcurrency caps the number of background reloads of WithRefreshAhead running at
+// once, so that a burst of reads of entries about to expire does not overwhelm the source
+// they are loaded from.
+//
+// Reloads beyond the limit are skipped rather than queued: the entry keeps its current value,
+// and the next read within the lead tries again. Entries that are not read again expire as
+// usual, and are then loaded by the caller, e.g. through GetOrSet.
+//
+// Parameters:
+//   - n: The maximum number of concurrent reloads. Zero or less means no limit.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithLoaderConcurrency(n int) Option {
+	return &withLoaderConcurrency{n: n}
+}
+
+type withLoaderConcurrency struct {
+	n int
+}
+
+// Apply sets the maximum number of concurrent reloads.
+func (w *withLoaderConcurrency) Apply(o *option) {
+	o.LoaderConcurrency = w.n
+}
+
+// WithRefreshAhead reloads entries in the background shortly before they expire, so that
+// frequently read entries never miss.
+//
+// When Get finds an unexpired entry expiring within lead, it returns the current value right
+// away and calls fn in a new goroutine to reload it. At most one reload per key is in flight at
+// a time. The reloaded value is stored with the returned duration, as SetWithExp would, keeping
+// the access statistics of the entry; it is discarded if fn fails, or if the entry has been
+// deleted or replaced in the meantime. Entries that are not read within lead expire as usual.
+//
+// The type parameter must match the type parameter of the cache, otherwise New panics.
+//
+// Parameters:
+//   - lead: How long before its expiration a read entry is reloaded.
+//   - fn: The function loading the value of the given key parts and the duration after which it
+//     expires.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithRefreshAhead[T any](lead time.Duration, fn func(keys []string) (T, time.Duration, error)) Option {
+	return &withRefreshAhead[T]{lead: lead, fn: fn}
+}
+
+type withRefreshAhead[T any] struct {
+	lead time.Duration
+	fn   func(keys []string) (T, time.Duration, error)
+}
+
+// Apply sets the refresh-ahead options.
+func (w *withRefreshAhead[T]) Apply(o *option) {
+	if w.fn != nil {
+		o.RefreshLead = w.lead
+		o.RefreshFunc = w.fn
+	}
+}
+
+// WithValueTTLFunc derives the expiration of the values stored without an explicit duration
+// from the values themselves.
+//
+// Set, TrySet and GetOrSet call fn with the value being stored and use the returned duration,
+// where zero means no expiration, as SetWithExp would. A negative duration, such as the time
+// left before a validity date that has already passed, stores the value already expired.
+// Methods taking an explicit duration or time, such as SetWithExp and SetWithExpireAt, keep
+// using it. WithMinTTL and WithMaxTTL apply to the returned duration as well.
+//
+// The type parameter must match the type parameter of the cache, otherwise New panics.
+//
+// Parameters:
+//   - fn: The function returning the duration after which the given value expires.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithValueTTLFunc[T any](fn func(T) time.Duration) Option {
+	return &withValueTTLFunc[T]{fn: fn}
+}
+
+type withValueTTLFunc[T any] struct {
+	fn func(T) time.Duration
+}
+
+// Apply sets the function deriving the expiration from values.
+func (w *withValueTTLFunc[T]) Apply(o *option) {
+	if w.fn != nil {
+		o.ValueTTLFunc = w.fn
+	}
+}
+
+// WithSizeOf sets a function used to measure the size of cached values, reported by Size.
+//
+// fn is called once for every value stored, with the value before it is encoded when
+// WithValueCodec is configured. The unit is up to the caller, e.g. bytes or weights.
+//
+// The type parameter must match the type parameter of the cache, otherwise New panics.
+//
+// Parameters:
+//   - fn: The function returning the size of the given value.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithSizeOf[T any](fn func(T) int64) Option {
+	return &withSizeOf[T]{fn: fn}
+}
+
+type withSizeOf[T any] struct {
+	fn func(T) int64
+}
+
+// Apply sets the size function.
+func (w *withSizeOf[T]) Apply(o *option) {
+	if w.fn != nil {
+		o.SizeOf = w.fn
+	}
+}
+
+// WithMaxValueSize rejects values larger than the given size, e.g. to keep a single
+// pathological value from exhausting the memory budget of the cache.
+//
+// Values are measured with the function set by WithSizeOf, which is required: New panics if it
+// is not configured. A write of a larger value leaves the cache untouched: TrySet and
+// TrySetWithExp return ErrTooLarge, while Set and SetWithExp silently discard the data.
+//
+// Parameters:
+//   - bytes: The maximum size of a value, in the unit of WithSizeOf. Zero or negative values
+//     mean unlimited.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithMaxValueSize(bytes int64) Option {
+	return &withMaxValueSize{bytes: bytes}
+}
+
+type withMaxValueSize struct {
+	bytes int64
+}
+
+// Apply sets the maximum size of a value.
+func (w *withMaxValueSize) Apply(o *option) {
+	o.MaxValueSize = 0
+	if w.bytes > 0 {
+		o.MaxValueSize = w.bytes
+	}
+}
+
+// WithIndex maintains a secondary index of the cached values by a field extracted from them,
+// e.g. users keyed by ID indexed by email, for use with LookupByIndex. It can be given several
+// times with different names to maintain several indexes.
+//
+// fn is called once for every value stored, outside the cache lock, and must always return the
+// same field for the same value. The index is updated along with the storage, under the same
+// lock, by every write and removal, including deletions, expirations and evictions, so it never
+// disagrees with the stored entries. It costs, per item and per index, the extracted field,
+// stored in the entry, and a set membership in the index.
+//
+// The type parameter must match the type parameter of the cache, otherwise New panics.
+//
+// Parameters:
+//   - name: The name of the index, to be passed to LookupByIndex.
+//   - fn: The function returning the indexed field of the given value.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithIndex[T any](name string, fn func(T) string) Option {
+	return &withIndex[T]{name: name, fn: fn}
+}
+
+type withIndex[T any] struct {
+	name string
+	fn   func(T) string
+}
+
+// Apply registers the index.
+func (w *withIndex[T]) Apply(o *option) {
+	if w.fn == nil {
+		return
+	}
+	if o.Indexes == nil {
+		o.Indexes = make(map[string]any)
+	}
+	o.Indexes[w.name] = w.fn
+}
+
+// WithCopyOnGet sets a function used to clone cached values before they are returned.
+//
+// By default, reads return the cached value itself, so callers mutating a returned pointer,
+// slice or map also mutate the cached value seen by every future reader. With this option,
+// Get, GetAndRefresh, Gets and GetsFromPrefix return the result of fn instead, isolating
+// the cached original. fn runs after the cache lock has been released, on every read, so
+// it should be cheap.
+//
+// The type parameter must match the type parameter of the cache, otherwise New panics.
+//
+// Parameters:
+//   - fn: The function returning a copy of the given value.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithCopyOnGet[T any](fn func(T) T) Option {
+	return &withCopyOnGet[T]{fn: fn}
+}
+
+type withCopyOnGet[T any] struct {
+	fn func(T) T
+}
+
+// Apply sets the copy function.
+func (w *withCopyOnGet[T]) Apply(o *option) {
+	if w.fn != nil {
+		o.CopyOnGet = w.fn
+	}
+}
+
+// WithValueCodec stores values in encoded form, trading CPU for memory.
+//
+// Caching millions of heap-heavy values keeps every one of them alive for the garbage
+// collector to scan. With this option, values are encoded into a []byte when stored and
+// decoded on every read, which makes each entry a single pointer-free allocation.
+//
+// Encoding errors are returned by TrySet and TrySetWithExp (Set and SetWithExp discard the
+// data), and decoding errors are returned by Get. Aggregate reads such as Gets skip values
+// that cannot be decoded. Since every read decodes a fresh value, WithCopyOnGet is not needed
+// together with this option.
+//
+// The type parameter must match the type parameter of the cache, otherwise New panics.
+//
+// Parameters:
+//   - enc: The function encoding a value into bytes.
+//   - dec: The function decoding bytes produced by enc back into a value.
+//
+// Returns:
+//   - An Option to be passed to the New() function.
+func WithValueCodec[T any](enc func(T) ([]byte, error), dec func([]byte) (T, error)) Option {
+	return &withValueCodec[T]{enc: enc, dec: dec}
+}
+
+type withValueCodec[T any] struct {
+	enc func(T) ([]byte, error)
+	dec func([]byte) (T, error)
+}
+
+// Apply sets the value codec.
+func (w *withValueCodec[T]) Apply(o *option) {
+	if w.enc != nil && w.dec != nil {
+		o.ValueEncoder = w.enc
+		o.ValueDecoder = w.dec
+	}
+}
@@ -0,0 +1,57 @@
+package bmemcache
+
+import "strings"
+
+// prefixLimit caps the number of entries whose keys start with a prefix, as set with
+// WithPrefixLimit.
+type prefixLimit struct {
+	// prefix is the serialized prefix without its closing bracket, so that serialized keys
+	// starting with the prefix parts start with it.
+	prefix     string
+	parts      int
+	maxEntries int
+}
+
+// newPrefixLimit returns a limit of maxEntries for the keys starting with the given parts.
+func newPrefixLimit(parts []string, maxEntries int) prefixLimit {
+	key := serializeKey(parts)
+	return prefixLimit{prefix: key[:len(key)-1], parts: len(parts), maxEntries: maxEntries}
+}
+
+// matches reports whether the given serialized key starts with the prefix, part by part.
+// Since the serialized prefix ends with the closing quote of its last part, comparing the
+// serialized forms never matches a key whose part merely starts with that last part.
+func (l prefixLimit) matches(key string) bool {
+	return strings.HasPrefix(key, l.prefix)
+}
+
+// partition returns the index of the most specific prefix limit matching the given
+// serialized key, or -1 if there is none. Limits are sorted from the longest prefix.
+func (s *settings[T]) partition(key string) int {
+	for i, limit := range s.prefixLimits {
+		if limit.matches(key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// countLocked adjusts the number of entries counted against the prefix limit matching the
+// given serialized key, if any. It must be called with the write lock held.
+func (c *bmemCache[T]) countLocked(key string, delta int) {
+	if i := c.settings().partition(key); i >= 0 {
+		c.partitionCounts[i] += delta
+	}
+}
+
+// recountLocked counts the stored entries against the current prefix limits from scratch. It
+// must be called with the write lock held.
+func (c *bmemCache[T]) recountLocked() {
+	c.partitionCounts = make([]int, len(c.settings().prefixLimits))
+	if len(c.partitionCounts) == 0 {
+		return
+	}
+	for key := range c.items {
+		c.countLocked(key, 1)
+	}
+}
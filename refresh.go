@@ -0,0 +1,62 @@
+package bmemcache
+
+import "time"
+
+// dueForRefresh reports whether the entry read by a hit is due for a refresh ahead of its
+// expiration, as configured with WithRefreshAhead.
+func (c *bmemCache[T]) dueForRefresh(entry *cacheEntry[T]) bool {
+	return c.refresh != nil && !entry.Exp.IsZero() && time.Until(entry.Exp) <= c.refreshLead
+}
+
+// refreshAhead reloads the entry stored under the given serialized key in the background,
+// unless a reload of the key is already in flight or WithLoaderConcurrency reloads are. The
+// reloaded value only replaces the entry if it is still stored once the reload completes, so
+// that a value deleted or written in the meantime is not overridden.
+func (c *bmemCache[T]) refreshAhead(key string, keys []string, entry *cacheEntry[T]) {
+	c.mu.Lock()
+	if _, ok := c.refreshing[key]; ok {
+		c.mu.Unlock()
+		return
+	}
+	if c.refreshSlots != nil {
+		select {
+		case c.refreshSlots <- struct{}{}:
+		default:
+			// Skipped rather than queued: a later read within the lead retries it.
+			c.mu.Unlock()
+			return
+		}
+	}
+	if c.refreshing == nil {
+		c.refreshing = make(map[string]struct{})
+	}
+	c.refreshing[key] = struct{}{}
+	c.mu.Unlock()
+
+	keys = append([]string(nil), keys...)
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.refreshing, key)
+			c.mu.Unlock()
+			if c.refreshSlots != nil {
+				<-c.refreshSlots
+			}
+		}()
+		data, duration, err := c.refresh(keys)
+		if err != nil {
+			return
+		}
+		updated, err := c.newEntry(data, c.expiration(duration))
+		if err != nil {
+			return
+		}
+		updated.Stats = entry.Stats
+		updated.Created = entry.Created
+		c.mu.Lock()
+		if !c.frozen && c.items[key] == entry {
+			c.putLocked(key, updated)
+		}
+		c.mu.Unlock()
+	}()
+}
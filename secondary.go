@@ -0,0 +1,119 @@
+package bmemcache
+
+import "sort"
+
+// secondaryIndex maps the field values extracted from the cached data by a WithIndex function
+// to the serialized keys of the entries holding them.
+type secondaryIndex[T any] struct {
+	name    string
+	extract func(T) string
+	keys    map[string]map[string]struct{} // guarded by the cache lock
+}
+
+// newSecondaryIndexes returns the indexes configured with WithIndex, sorted by name so that
+// the field values stored in each entry line up with them.
+//
+// It panics if an extractor does not match the type parameter of the cache.
+func newSecondaryIndexes[T any](extractors map[string]any) []*secondaryIndex[T] {
+	indexes := make([]*secondaryIndex[T], 0, len(extractors))
+	for name, fn := range extractors {
+		indexes = append(indexes, &secondaryIndex[T]{
+			name:    name,
+			extract: typedOption[func(T) string](fn, "WithIndex"),
+			keys:    make(map[string]map[string]struct{}),
+		})
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i].name < indexes[j].name })
+	return indexes
+}
+
+// secondaryIndex returns the index registered under the given name, or nil if there is none.
+func (c *bmemCache[T]) secondaryIndex(name string) *secondaryIndex[T] {
+	for _, index := range c.secondaries {
+		if index.name == name {
+			return index
+		}
+	}
+	return nil
+}
+
+// extractFields returns the field values of data for each index, or nil without indexes.
+func (c *bmemCache[T]) extractFields(data T) []string {
+	if len(c.secondaries) == 0 {
+		return nil
+	}
+	fields := make([]string, len(c.secondaries))
+	for i, index := range c.secondaries {
+		fields[i] = index.extract(data)
+	}
+	return fields
+}
+
+// indexLocked adds the entry stored under the given serialized key to the indexes. It must be
+// called with the write lock held.
+func (c *bmemCache[T]) indexLocked(key string, entry *cacheEntry[T]) {
+	for i, index := range c.secondaries {
+		keys, ok := index.keys[entry.Fields[i]]
+		if !ok {
+			keys = make(map[string]struct{})
+			index.keys[entry.Fields[i]] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// unindexLocked removes the entry stored under the given serialized key from the indexes. It
+// must be called with the write lock held.
+func (c *bmemCache[T]) unindexLocked(key string, entry *cacheEntry[T]) {
+	for i, index := range c.secondaries {
+		keys := index.keys[entry.Fields[i]]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(index.keys, entry.Fields[i])
+		}
+	}
+}
+
+// reindexLocked rebuilds the indexes from the stored entries. It must be called with the write
+// lock held.
+func (c *bmemCache[T]) reindexLocked() {
+	if len(c.secondaries) == 0 {
+		return
+	}
+	for _, index := range c.secondaries {
+		index.keys = make(map[string]map[string]struct{})
+	}
+	for key, entry := range c.items {
+		c.indexLocked(key, entry)
+	}
+}
+
+func (c *bmemCache[T]) LookupByIndex(name, fieldValue string) ([]T, error) {
+	index := c.secondaryIndex(name)
+	if index == nil {
+		return nil, ErrUnknownIndex
+	}
+	type match struct {
+		key   string
+		entry *cacheEntry[T]
+	}
+	c.mu.RLock()
+	matches := make([]match, 0, len(index.keys[fieldValue]))
+	for key := range index.keys[fieldValue] {
+		if entry := c.items[key]; !entry.isExpired() {
+			matches = append(matches, match{key: key, entry: entry})
+		}
+	}
+	c.mu.RUnlock()
+	sort.Slice(matches, func(i, j int) bool { return matches[i].key < matches[j].key })
+	values := make([]T, 0, len(matches))
+	for _, m := range matches {
+		if data, err := c.read(m.key, deserializeKey(m.key), m.entry); err == nil {
+			values = append(values, data)
+		}
+	}
+	if len(values) == 0 {
+		return nil, ErrNotFound
+	}
+	return values, nil
+}
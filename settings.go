@@ -0,0 +1,69 @@
+package bmemcache
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// settings holds the options that Reset can change on a live cache. A settings value is never
+// modified once published: Reset replaces it as a whole, so each read observes a consistent set.
+type settings[T any] struct {
+	cleanupEveryNWrites int
+	maxEntries          int
+	overflowPolicy      OverflowPolicy
+	prefixLimits        []prefixLimit // sorted from the longest prefix
+	maxValueSize        int64
+	minTTL              time.Duration
+	maxTTL              time.Duration
+	timeToIdle          time.Duration
+	lazyDeleteOnGet     bool
+	expiredRetention    time.Duration
+	maxKeyParts         int
+	disallowEmptyKeys   bool
+	keyValidator        func(parts []string) error
+	valueTTLFunc        func(T) time.Duration
+	loaderTimeout       time.Duration
+}
+
+// newSettings returns the settings configured by the given options.
+//
+// It panics if the options are inconsistent.
+func newSettings[T any](o *option) *settings[T] {
+	if o.MinTTL > 0 && o.MaxTTL > 0 && o.MinTTL > o.MaxTTL {
+		panic(fmt.Sprintf("bmemcache: WithMinTTL: %v exceeds WithMaxTTL: %v", o.MinTTL, o.MaxTTL))
+	}
+	prefixLimits := append([]prefixLimit(nil), o.PrefixLimits...)
+	sort.SliceStable(prefixLimits, func(i, j int) bool { return prefixLimits[i].parts > prefixLimits[j].parts })
+	return &settings[T]{
+		cleanupEveryNWrites: o.CleanupEveryNWrites,
+		maxEntries:          o.MaxEntries,
+		overflowPolicy:      o.OverflowPolicy,
+		prefixLimits:        prefixLimits,
+		maxValueSize:        o.MaxValueSize,
+		minTTL:              o.MinTTL,
+		maxTTL:              o.MaxTTL,
+		timeToIdle:          o.TimeToIdle,
+		lazyDeleteOnGet:     !o.DisableLazyDeleteOnGet,
+		expiredRetention:    o.ExpiredRetention,
+		maxKeyParts:         o.MaxKeyParts,
+		disallowEmptyKeys:   o.DisallowEmptyKeys,
+		keyValidator:        o.KeyValidator,
+		valueTTLFunc:        typedOption[func(T) time.Duration](o.ValueTTLFunc, "WithValueTTLFunc"),
+		loaderTimeout:       o.LoaderTimeout,
+	}
+}
+
+// checkSettings panics if the given settings rely on options fixed at the creation of the cache
+// that it was not created with.
+func (c *bmemCache[T]) checkSettings(s *settings[T]) {
+	if s.maxValueSize > 0 && c.sizeOf == nil {
+		panic("bmemcache: WithMaxValueSize requires WithSizeOf")
+	}
+}
+
+// settings returns the current settings of the cache. Reset may replace them at any time, so
+// callers needing several of them must read them from a single call.
+func (c *bmemCache[T]) settings() *settings[T] {
+	return c.conf.Load().(*settings[T])
+}
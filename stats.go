@@ -0,0 +1,31 @@
+package bmemcache
+
+import "time"
+
+// Stats holds the usage counters of a cache.
+type Stats struct {
+	// Hits is the number of key lookups that found an unexpired item, by any method reading
+	// items, including Peek and the lookups made by Gets, GetFirst and LookupByIndex.
+	Hits int64
+	// Misses is the number of lookups that found no item or an expired one.
+	Misses int64
+	// Expirations is the number of items removed because their TTL lapsed.
+	Expirations int64
+	// Evictions is the number of live items removed by EvictFraction or by a WithCapacity policy.
+	Evictions int64
+}
+
+// KeyStat holds the access statistics of a single cache entry.
+type KeyStat struct {
+	// Hits is the number of lookups that found the entry unexpired, by any method but Peek. It
+	// is only tracked when WithKeyStats is configured.
+	Hits int64
+	// LastAccess is the time of the last lookup counted in Hits, or the zero time if there was
+	// none. It is only tracked when WithKeyStats is configured.
+	LastAccess time.Time
+	// Created is the time at which the entry was stored. Updates through Update and changes of
+	// its expiration preserve it.
+	Created time.Time
+	// TTL is the remaining time before the entry expires, or -1 if it does not expire.
+	TTL time.Duration
+}
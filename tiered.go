@@ -0,0 +1,579 @@
+package bmemcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"sync/atomic"
+	"time"
+)
+
+// NewTiered combines two caches into a two-tier cache: a small, fast L1 in front of a larger L2.
+//
+// Reads try L1 first and fall back to L2, promoting L2 hits into L1 with their remaining TTL.
+// Writes go to both tiers, L2 first, and removals (Delete, Clear) as well as Close fan out to
+// both tiers. L2 is treated as the authoritative tier: aggregate reads such as Gets, Keys and
+// Len are served from L2 alone, while Stats sums the counters of both tiers.
+//
+// Each tier keeps its own TTL for a given key and applies its own options (such as entry
+// limits), so an entry may be dropped from L1 while remaining in L2. When an L1 write fails,
+// the key is removed from L1 so that it never shadows a newer value in L2. Likewise, a value
+// promoted from L2 is removed from L1 again if L2 was written concurrently, since it may be
+// older than the value written.
+//
+// Parameters:
+//   - l1: The first-level cache, consulted first on reads.
+//   - l2: The second-level cache, consulted on L1 misses.
+//
+// Returns:
+//   - A BMemCache instance backed by both tiers.
+func NewTiered[T any](l1, l2 BMemCache[T]) BMemCache[T] {
+	return &tieredCache[T]{l1: l1, l2: l2}
+}
+
+type tieredCache[T any] struct {
+	l1     BMemCache[T]
+	l2     BMemCache[T]
+	writes int64 // incremented after each write to L2 and before the matching L1 write
+}
+
+func (c *tieredCache[T]) Set(data T, keys ...string) {
+	_ = c.TrySet(data, keys...)
+}
+
+func (c *tieredCache[T]) TrySet(data T, keys ...string) error {
+	if err := c.l2.TrySet(data, keys...); err != nil {
+		return err
+	}
+	c.written()
+	c.writeL1(c.l1.TrySet(data, keys...), keys)
+	return nil
+}
+
+func (c *tieredCache[T]) SetWithExp(data T, duration time.Duration, keys ...string) {
+	_ = c.TrySetWithExp(data, duration, keys...)
+}
+
+func (c *tieredCache[T]) SetAndKey(data T, keys ...string) string {
+	c.Set(data, keys...)
+	return serializeKey(keys)
+}
+
+func (c *tieredCache[T]) SetWithExpAndKey(data T, duration time.Duration, keys ...string) string {
+	c.SetWithExp(data, duration, keys...)
+	return serializeKey(keys)
+}
+
+func (c *tieredCache[T]) SetManyWithExp(entries []EntryWithExp[T]) {
+	c.l2.SetManyWithExp(entries)
+	c.written()
+	// Like SetWithExpireAt, the keys are removed from L1 first, so that the entries L1 rejects
+	// are left absent.
+	keyGroups := make([][]string, len(entries))
+	for i, e := range entries {
+		keyGroups[i] = e.Keys
+	}
+	c.l1.DeleteMany(keyGroups)
+	c.l1.SetManyWithExp(entries)
+}
+
+func (c *tieredCache[T]) LoadFrom(ctx context.Context, ch <-chan EntryWithExp[T]) error {
+	return loadFrom(ctx, ch, c.SetManyWithExp)
+}
+
+func (c *tieredCache[T]) TrySetWithExp(data T, duration time.Duration, keys ...string) error {
+	if err := c.l2.TrySetWithExp(data, duration, keys...); err != nil {
+		return err
+	}
+	c.written()
+	c.writeL1(c.l1.TrySetWithExp(data, duration, keys...), keys)
+	return nil
+}
+
+func (c *tieredCache[T]) SetWithExpireAt(data T, t time.Time, keys ...string) {
+	c.l2.SetWithExpireAt(data, t, keys...)
+	c.written()
+	// The L1 write reports no error, so the key is removed from L1 first: if L1 rejects the
+	// write, the key is left absent rather than holding its previous value.
+	_ = c.l1.Delete(keys...)
+	c.l1.SetWithExpireAt(data, t, keys...)
+}
+
+func (c *tieredCache[T]) Get(keys ...string) (T, error) {
+	if data, err := c.l1.Get(keys...); err == nil {
+		return data, nil
+	}
+	gen := c.generation()
+	data, err := c.l2.Get(keys...)
+	if err != nil {
+		return data, err
+	}
+	c.promote(gen, data, keys)
+	return data, nil
+}
+
+func (c *tieredCache[T]) GetFirst(keyGroups ...[]string) (T, []string, error) {
+	// L1 may have dropped a preferred key that L2 still holds, so each key is resolved across
+	// both tiers before falling back to the next one.
+	for _, keys := range keyGroups {
+		data, err := c.Get(keys...)
+		if err == nil {
+			return data, keys, nil
+		}
+		if !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrExpired) {
+			return generateEmptyData[T](), nil, err
+		}
+	}
+	return generateEmptyData[T](), nil, newCacheError(firstKeys(keyGroups), ErrNotFound)
+}
+
+func (c *tieredCache[T]) GetTimeout(timeout time.Duration, keys ...string) (T, error) {
+	deadline := time.Now().Add(timeout)
+	if data, err := c.l1.GetTimeout(timeout, keys...); err == nil {
+		return data, nil
+	}
+	// The value is not promoted, since writing to L1 could wait for its lock.
+	return c.l2.GetTimeout(time.Until(deadline), keys...)
+}
+
+func (c *tieredCache[T]) GetOrDefault(def T, keys ...string) T {
+	data, err := c.Get(keys...)
+	if err != nil {
+		return def
+	}
+	return data
+}
+
+func (c *tieredCache[T]) TryGet(keys ...string) (T, bool) {
+	data, err := c.Get(keys...)
+	if err != nil {
+		return generateEmptyData[T](), false
+	}
+	return data, true
+}
+
+func (c *tieredCache[T]) Peek(keys ...string) (T, error) {
+	if data, err := c.l1.Peek(keys...); err == nil {
+		return data, nil
+	}
+	return c.l2.Peek(keys...)
+}
+
+func (c *tieredCache[T]) GetStale(keys ...string) (T, bool, error) {
+	if data, err := c.l1.Get(keys...); err == nil {
+		return data, false, nil
+	}
+	return c.l2.GetStale(keys...)
+}
+
+func (c *tieredCache[T]) GetAndRefresh(duration time.Duration, keys ...string) (T, error) {
+	data1, err1 := c.l1.GetAndRefresh(duration, keys...)
+	gen := c.generation()
+	data2, err2 := c.l2.GetAndRefresh(duration, keys...)
+	if err1 == nil {
+		return data1, nil
+	}
+	if err2 == nil {
+		c.writeL1(c.l1.TrySetWithExp(data2, duration, keys...), keys)
+		c.settleL1(gen, keys)
+	}
+	return data2, err2
+}
+
+func (c *tieredCache[T]) GetSet(data T, keys ...string) (T, bool) {
+	old, existed := c.l2.GetSet(data, keys...)
+	c.written()
+	c.writeL1(c.l1.TrySet(data, keys...), keys)
+	return old, existed
+}
+
+func (c *tieredCache[T]) SwapWithExp(data T, duration time.Duration, keys ...string) (T, bool) {
+	old, existed := c.l2.SwapWithExp(data, duration, keys...)
+	c.written()
+	c.writeL1(c.l1.TrySetWithExp(data, duration, keys...), keys)
+	return old, existed
+}
+
+func (c *tieredCache[T]) SetIfNewer(data T, version int64, keys ...string) bool {
+	if !c.l2.SetIfNewer(data, version, keys...) {
+		return false
+	}
+	c.written()
+	// L1 may have dropped the item, so its version cannot be trusted: overwrite it.
+	c.writeL1(c.l1.TrySet(data, keys...), keys)
+	return true
+}
+
+func (c *tieredCache[T]) GetOrSet(loader func() (T, error), keys ...string) (T, error) {
+	// GetOrSet is forwarded as is, so that L2 applies its own expiration to loaded values,
+	// e.g. with WithValueTTLFunc.
+	return c.getOrSet(keys, func() (T, error) { return c.l2.GetOrSet(loader, keys...) })
+}
+
+func (c *tieredCache[T]) GetOrSetWithExp(loader func() (T, error), duration time.Duration, keys ...string) (T, error) {
+	return c.getOrSet(keys, func() (T, error) { return c.l2.GetOrSetWithExp(loader, duration, keys...) })
+}
+
+func (c *tieredCache[T]) GetOrSetCtx(loader func(ctx context.Context) (T, error), keys ...string) (T, error) {
+	return c.getOrSet(keys, func() (T, error) { return c.l2.GetOrSetCtx(loader, keys...) })
+}
+
+// getOrSet serves the keys from L1, or else from load, which reads or loads them through L2.
+func (c *tieredCache[T]) getOrSet(keys []string, load func() (T, error)) (T, error) {
+	if data, err := c.l1.Get(keys...); err == nil {
+		return data, nil
+	}
+	gen := c.generation()
+	// L2 coalesces the loads, so that concurrent L1 misses still run loader once.
+	data, err := load()
+	if err != nil {
+		return data, err
+	}
+	c.promote(gen, data, keys)
+	return data, nil
+}
+
+func (c *tieredCache[T]) WaitGet(ctx context.Context, keys ...string) (T, error) {
+	if data, err := c.l1.Get(keys...); err == nil {
+		return data, nil
+	}
+	gen := c.generation()
+	// Writes always reach L2, so waiting on it alone is enough.
+	data, err := c.l2.WaitGet(ctx, keys...)
+	if err != nil {
+		return data, err
+	}
+	c.promote(gen, data, keys)
+	return data, nil
+}
+
+func (c *tieredCache[T]) Gets() ([]T, error) {
+	return c.l2.Gets()
+}
+
+func (c *tieredCache[T]) GetsMap() map[string]T {
+	return c.l2.GetsMap()
+}
+
+func (c *tieredCache[T]) Filter(pred func(keys []string, value T) bool) []T {
+	return c.l2.Filter(pred)
+}
+
+func (c *tieredCache[T]) FilterKeys(pred func(keys []string, value T) bool) [][]string {
+	return c.l2.FilterKeys(pred)
+}
+
+func (c *tieredCache[T]) RangeUnlocked(fn func(keys []string, value T) bool) {
+	c.l2.RangeUnlocked(fn)
+}
+
+func (c *tieredCache[T]) All() iter.Seq2[[]string, T] {
+	return c.l2.All()
+}
+
+func (c *tieredCache[T]) AllKeys() iter.Seq[[]string] {
+	return c.l2.AllKeys()
+}
+
+func (c *tieredCache[T]) GetsFromPrefix(keys ...string) ([]T, error) {
+	return c.l2.GetsFromPrefix(keys...)
+}
+
+func (c *tieredCache[T]) GetsFromPrefixPage(limit int, cursor string, keys ...string) ([]T, string, error) {
+	return c.l2.GetsFromPrefixPage(limit, cursor, keys...)
+}
+
+func (c *tieredCache[T]) EntriesFromPrefix(keys ...string) ([]PrefixEntry[T], error) {
+	return c.l2.EntriesFromPrefix(keys...)
+}
+
+func (c *tieredCache[T]) LookupByIndex(name, fieldValue string) ([]T, error) {
+	return c.l2.LookupByIndex(name, fieldValue)
+}
+
+func (c *tieredCache[T]) Update(fn func(old T) T, keys ...string) error {
+	// fn is applied to L2 only, since it may not be idempotent. L1 is invalidated so that
+	// the next read promotes the updated value.
+	err := c.l2.Update(fn, keys...)
+	c.written()
+	_ = c.l1.Delete(keys...)
+	return err
+}
+
+func (c *tieredCache[T]) TransformAll(fn func(keys []string, old T) T) {
+	// Like Update, fn is applied to L2 only and L1 is invalidated.
+	c.l2.TransformAll(fn)
+	c.written()
+	c.l1.Clear()
+}
+
+func (c *tieredCache[T]) Delete(keys ...string) error {
+	err2 := c.l2.Delete(keys...)
+	c.written()
+	err1 := c.l1.Delete(keys...)
+	if err1 == nil {
+		return nil
+	}
+	return err2
+}
+
+func (c *tieredCache[T]) DeleteMany(keyGroups [][]string) int {
+	n := c.l2.DeleteMany(keyGroups)
+	c.written()
+	c.l1.DeleteMany(keyGroups)
+	return n
+}
+
+func (c *tieredCache[T]) Keys() [][]string {
+	return c.l2.Keys()
+}
+
+func (c *tieredCache[T]) KeyStrings() []string {
+	return c.l2.KeyStrings()
+}
+
+func (c *tieredCache[T]) KeysSorted() [][]string {
+	return c.l2.KeysSorted()
+}
+
+func (c *tieredCache[T]) KeysFromPrefix(keys ...string) [][]string {
+	return c.l2.KeysFromPrefix(keys...)
+}
+
+func (c *tieredCache[T]) KeysFromSuffix(keys ...string) [][]string {
+	return c.l2.KeysFromSuffix(keys...)
+}
+
+func (c *tieredCache[T]) KeysPage(limit int, cursor string) ([][]string, string) {
+	return c.l2.KeysPage(limit, cursor)
+}
+
+func (c *tieredCache[T]) TouchPrefix(duration time.Duration, keys ...string) int {
+	n := c.l2.TouchPrefix(duration, keys...)
+	c.written()
+	c.l1.TouchPrefix(duration, keys...)
+	return n
+}
+
+func (c *tieredCache[T]) ExpireAt(t time.Time, keys ...string) error {
+	err := c.l2.ExpireAt(t, keys...)
+	c.written()
+	_ = c.l1.ExpireAt(t, keys...)
+	return err
+}
+
+func (c *tieredCache[T]) UpdateExp(duration time.Duration, keys ...string) error {
+	err := c.l2.UpdateExp(duration, keys...)
+	c.written()
+	_ = c.l1.UpdateExp(duration, keys...)
+	return err
+}
+
+func (c *tieredCache[T]) Export() []KeyValueExp[T] {
+	return c.l2.Export()
+}
+
+func (c *tieredCache[T]) Import(kvs []KeyValueExp[T], overwrite bool) {
+	c.l2.Import(kvs, overwrite)
+	c.written()
+	if !overwrite {
+		// L2 kept the items it already held, which L1 either mirrors or lacks, so L1 is left
+		// to warm up from L2 on reads rather than import an item that L2 skipped.
+		return
+	}
+	// Like SetWithExpireAt, the keys are removed from L1 first, so that the items L1 rejects
+	// are left absent.
+	keyGroups := make([][]string, len(kvs))
+	for i, kv := range kvs {
+		keyGroups[i] = kv.Keys
+	}
+	c.l1.DeleteMany(keyGroups)
+	c.l1.Import(kvs, true)
+}
+
+func (c *tieredCache[T]) Merge(other BMemCache[T], overwrite bool) {
+	c.Import(other.Export(), overwrite)
+}
+
+func (c *tieredCache[T]) ReplaceAll(kvs []KeyValueExp[T]) {
+	c.l2.ReplaceAll(kvs)
+	c.written()
+	// The whole content of L1 is replaced too, so the items it rejects are left absent.
+	c.l1.ReplaceAll(kvs)
+}
+
+func (c *tieredCache[T]) Replay(r io.Reader) error {
+	// L1 is left to warm up from L2 on reads.
+	err := c.l2.Replay(r)
+	c.written()
+	return err
+}
+
+func (c *tieredCache[T]) IsExist(keys ...string) bool {
+	return c.l1.IsExist(keys...) || c.l2.IsExist(keys...)
+}
+
+func (c *tieredCache[T]) IsValid(keys ...string) bool {
+	return c.l1.IsValid(keys...) || c.l2.IsValid(keys...)
+}
+
+func (c *tieredCache[T]) IsExpired(keys ...string) (bool, error) {
+	return c.l2.IsExpired(keys...)
+}
+
+func (c *tieredCache[T]) TTL(keys ...string) (time.Duration, error) {
+	return c.l2.TTL(keys...)
+}
+
+func (c *tieredCache[T]) Age(keys ...string) (time.Duration, error) {
+	return c.l2.Age(keys...)
+}
+
+func (c *tieredCache[T]) ExpiresAt(keys ...string) (time.Time, bool, error) {
+	return c.l2.ExpiresAt(keys...)
+}
+
+func (c *tieredCache[T]) Remaining(keys ...string) time.Duration {
+	return c.l2.Remaining(keys...)
+}
+
+func (c *tieredCache[T]) Len() int {
+	return c.l2.Len()
+}
+
+func (c *tieredCache[T]) ExpiredCount() int {
+	return c.l2.ExpiredCount()
+}
+
+func (c *tieredCache[T]) TTLHistogram(buckets []time.Duration) map[time.Duration]int {
+	return c.l2.TTLHistogram(buckets)
+}
+
+func (c *tieredCache[T]) Size() int64 {
+	return c.l2.Size()
+}
+
+func (c *tieredCache[T]) Stats() Stats {
+	s1, s2 := c.l1.Stats(), c.l2.Stats()
+	return Stats{
+		Hits:        s1.Hits + s2.Hits,
+		Misses:      s1.Misses + s2.Misses,
+		Expirations: s1.Expirations + s2.Expirations,
+		Evictions:   s1.Evictions + s2.Evictions,
+	}
+}
+
+func (c *tieredCache[T]) ResetStats() Stats {
+	s1, s2 := c.l1.ResetStats(), c.l2.ResetStats()
+	return Stats{
+		Hits:        s1.Hits + s2.Hits,
+		Misses:      s1.Misses + s2.Misses,
+		Expirations: s1.Expirations + s2.Expirations,
+		Evictions:   s1.Evictions + s2.Evictions,
+	}
+}
+
+func (c *tieredCache[T]) EvictFraction(f float64) int {
+	c.l1.EvictFraction(f)
+	return c.l2.EvictFraction(f)
+}
+
+func (c *tieredCache[T]) KeyStats(keys ...string) (KeyStat, error) {
+	return c.l2.KeyStats(keys...)
+}
+
+func (c *tieredCache[T]) Healthy() (bool, error) {
+	if ok, err := c.l1.Healthy(); !ok {
+		return false, err
+	}
+	return c.l2.Healthy()
+}
+
+func (c *tieredCache[T]) Name() string {
+	return c.l2.Name()
+}
+
+func (c *tieredCache[T]) String() string {
+	return fmt.Sprintf("tiered(l1=%s, l2=%s)", c.l1, c.l2)
+}
+
+func (c *tieredCache[T]) Drain() []EntryWithExp[T] {
+	entries := c.l2.Drain()
+	c.written()
+	c.l1.Clear()
+	return entries
+}
+
+func (c *tieredCache[T]) Clear() {
+	c.l2.Clear()
+	c.written()
+	c.l1.Clear()
+}
+
+func (c *tieredCache[T]) Compact() {
+	c.l1.Compact()
+	c.l2.Compact()
+}
+
+func (c *tieredCache[T]) Reset(options ...Option) {
+	c.l2.Reset(options...)
+	c.written()
+	c.l1.Reset(options...)
+}
+
+func (c *tieredCache[T]) Freeze() {
+	c.l1.Freeze()
+	c.l2.Freeze()
+}
+
+func (c *tieredCache[T]) Close() {
+	c.l1.Close()
+	c.l2.Close()
+}
+
+// promote copies a value read from L2 into L1, keeping the remaining TTL it has in L2. gen is
+// the generation loaded before reading the value.
+func (c *tieredCache[T]) promote(gen int64, data T, keys []string) {
+	ttl, err := c.l2.TTL(keys...)
+	switch {
+	case err != nil:
+		// The entry expired or vanished from L2 since it was read.
+		return
+	case ttl < 0:
+		c.writeL1(c.l1.TrySet(data, keys...), keys)
+	default:
+		c.writeL1(c.l1.TrySetWithExp(data, ttl, keys...), keys)
+	}
+	c.settleL1(gen, keys)
+}
+
+// generation returns the number of writes to L2 so far, to be loaded before reading a value
+// from L2 that is then copied into L1.
+func (c *tieredCache[T]) generation() int64 {
+	return atomic.LoadInt64(&c.writes)
+}
+
+// written records a write to L2. Writers call it between their L2 and L1 writes, so that a
+// value read from L2 before the L2 write and copied into L1 after the L1 write is caught by
+// settleL1.
+func (c *tieredCache[T]) written() {
+	atomic.AddInt64(&c.writes, 1)
+}
+
+// settleL1 removes the key from L1 if L2 was written since gen was loaded: the value just
+// copied into L1 may then be older than the one written, so L1 is left to warm up again.
+func (c *tieredCache[T]) settleL1(gen int64, keys []string) {
+	if c.generation() != gen {
+		_ = c.l1.Delete(keys...)
+	}
+}
+
+// writeL1 removes the key from L1 when writing to it failed, so that a stale L1 value never
+// shadows the value just written to L2.
+func (c *tieredCache[T]) writeL1(err error, keys []string) {
+	if err != nil {
+		_ = c.l1.Delete(keys...)
+	}
+}
@@ -0,0 +1,196 @@
+package bmemcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestTieredWriteThrough verifies that writes and removals reach both tiers.
+func TestTieredWriteThrough(t *testing.T) {
+	l1, l2 := New[string](), New[string]()
+	cache := NewTiered(l1, l2)
+	defer cache.Close()
+
+	cache.SetWithExp("value", time.Minute, "key")
+	if !l1.IsValid("key") || !l2.IsValid("key") {
+		t.Error("expected the value to be written to both tiers")
+	}
+	if err := cache.Delete("key"); err != nil {
+		t.Errorf("unexpected error on delete: %v", err)
+	}
+	if l1.IsExist("key") || l2.IsExist("key") {
+		t.Error("expected the value to be deleted from both tiers")
+	}
+
+	cache.Set("value", "key")
+	cache.Clear()
+	if l1.Len() != 0 || l2.Len() != 0 {
+		t.Error("expected both tiers to be cleared")
+	}
+}
+
+// TestTieredPromotion verifies that L2 hits are promoted into L1 with their remaining TTL.
+func TestTieredPromotion(t *testing.T) {
+	l1, l2 := New[string](), New[string]()
+	cache := NewTiered(l1, l2)
+	defer cache.Close()
+
+	l2.SetWithExp("value", time.Minute, "key")
+	value, err := cache.Get("key")
+	if err != nil || value != "value" {
+		t.Fatalf("unexpected get result: %v, %v", value, err)
+	}
+	if ttl, err := l1.TTL("key"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the value to be promoted with its remaining TTL, got: %v, %v", ttl, err)
+	}
+
+	// L1 is consulted first.
+	l1.Set("l1 value", "key")
+	if value, _ := cache.Get("key"); value != "l1 value" {
+		t.Errorf("expected the L1 value, got: %s", value)
+	}
+
+	if _, err := cache.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+// TestTieredL1Failure verifies that a failed L1 write never leaves a stale value shadowing L2.
+func TestTieredL1Failure(t *testing.T) {
+	l1, l2 := New[string](WithMaxEntriesReject(1)), New[string]()
+	cache := NewTiered(l1, l2)
+	defer cache.Close()
+
+	cache.Set("value", "a")
+	if err := cache.TrySet("value", "c"); err != nil {
+		t.Fatalf("expected the write to succeed in L2, got: %v", err)
+	}
+	if l1.IsExist("c") {
+		t.Error("expected the key to be absent from the full L1")
+	}
+	if value, _ := cache.Get("c"); value != "value" {
+		t.Errorf("expected the L2 value, got: %s", value)
+	}
+}
+
+// TestTieredL1FailureBulk verifies that the writes reporting no error never leave a stale L1
+// value shadowing L2 either.
+func TestTieredL1FailureBulk(t *testing.T) {
+	l1, l2 := NewString(WithMaxValueSize(3)), New[string]()
+	cache := NewTiered(l1, l2)
+	defer cache.Close()
+
+	for name, write := range map[string]func(){
+		"SetWithExpireAt": func() { cache.SetWithExpireAt("newer", time.Now().Add(time.Minute), "key") },
+		"Import":          func() { cache.Import([]KeyValueExp[string]{{Keys: []string{"key"}, Value: "newer"}}, true) },
+		"SetManyWithExp":  func() { cache.SetManyWithExp([]EntryWithExp[string]{{Keys: []string{"key"}, Data: "newer"}}) },
+		"LoadFrom": func() {
+			ch := make(chan EntryWithExp[string], 1)
+			ch <- EntryWithExp[string]{Keys: []string{"key"}, Data: "newer"}
+			close(ch)
+			_ = cache.LoadFrom(context.Background(), ch)
+		},
+		"ReplaceAll": func() { cache.ReplaceAll([]KeyValueExp[string]{{Keys: []string{"key"}, Value: "newer"}}) },
+	} {
+		cache.Set("old", "key")
+		if !l1.IsExist("key") {
+			t.Fatalf("%s: expected the old value to be stored in L1", name)
+		}
+		write()
+		if value, _ := cache.Get("key"); value != "newer" {
+			t.Errorf("%s: expected the L2 value, got: %s", name, value)
+		}
+	}
+}
+
+// TestTieredUpdate verifies that Update applies the function once and invalidates L1.
+func TestTieredUpdate(t *testing.T) {
+	l1, l2 := New[int](), New[int]()
+	cache := NewTiered(l1, l2)
+	defer cache.Close()
+
+	cache.Set(1, "counter")
+	if err := cache.Update(func(old int) int { return old + 1 }, "counter"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if l1.IsExist("counter") {
+		t.Error("expected L1 to be invalidated")
+	}
+	if value, _ := cache.Get("counter"); value != 2 {
+		t.Errorf("expected 2, got: %d", value)
+	}
+}
+
+// TestTieredGetOrSet verifies that GetOrSet lets L2 apply its own expiration to loaded values.
+func TestTieredGetOrSet(t *testing.T) {
+	l1, l2 := New[string](), New[string](WithValueTTLFunc(func(string) time.Duration { return time.Minute }))
+	cache := NewTiered(l1, l2)
+	defer cache.Close()
+
+	value, err := cache.GetOrSet(func() (string, error) { return "loaded", nil }, "key")
+	if err != nil || value != "loaded" {
+		t.Fatalf("unexpected GetOrSet result: %v, %v", value, err)
+	}
+	if ttl, err := l2.TTL("key"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the TTL derived by L2, got: %v, %v", ttl, err)
+	}
+	if ttl, err := l1.TTL("key"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the value to be promoted with its TTL, got: %v, %v", ttl, err)
+	}
+}
+
+// TestTieredGetOrSetCtx verifies that GetOrSetCtx loads through L2 and promotes the value to L1.
+func TestTieredGetOrSetCtx(t *testing.T) {
+	l1, l2 := New[string](), New[string]()
+	cache := NewTiered(l1, l2)
+	defer cache.Close()
+
+	value, err := cache.GetOrSetCtx(func(context.Context) (string, error) { return "loaded", nil }, "key")
+	if err != nil || value != "loaded" {
+		t.Fatalf("unexpected GetOrSetCtx result: %v, %v", value, err)
+	}
+	if value, err := l2.Get("key"); err != nil || value != "loaded" {
+		t.Errorf("expected the value to be stored in L2, got: %v, %v", value, err)
+	}
+	if value, err := l1.Get("key"); err != nil || value != "loaded" {
+		t.Errorf("expected the value to be promoted to L1, got: %v, %v", value, err)
+	}
+}
+
+// racingTier runs a hook once on the first TTL call, which promote makes right after reading
+// a value from L2.
+type racingTier[T any] struct {
+	BMemCache[T]
+	hook func()
+}
+
+func (c *racingTier[T]) TTL(keys ...string) (time.Duration, error) {
+	if hook := c.hook; hook != nil {
+		c.hook = nil
+		hook()
+	}
+	return c.BMemCache.TTL(keys...)
+}
+
+// TestTieredPromotionRace verifies that a value promoted from L2 never shadows a value written
+// while it was being promoted.
+func TestTieredPromotionRace(t *testing.T) {
+	l1 := New[string]()
+	l2 := &racingTier[string]{BMemCache: New[string]()}
+	cache := NewTiered[string](l1, l2)
+	defer cache.Close()
+
+	l2.Set("old", "key")
+	l2.hook = func() { cache.Set("newer", "key") }
+	if value, _ := cache.Get("key"); value != "old" {
+		t.Errorf("expected the value read before the write, got: %s", value)
+	}
+	if value, _ := cache.Get("key"); value != "newer" {
+		t.Errorf("expected the newer value, got: %s", value)
+	}
+	if value, _ := l1.Get("key"); value == "old" {
+		t.Error("expected the promoted value not to remain in L1")
+	}
+}
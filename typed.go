@@ -0,0 +1,50 @@
+package bmemcache
+
+// NewBytes creates a cache of byte slices whose Size reports the total number of bytes stored.
+//
+// It is a thin wrapper over New prewiring WithSizeOf with the length of the slices. The given
+// options are applied afterwards, so they can override it.
+//
+// Parameters:
+//   - options: A variadic list of Option values to configure the cache.
+//
+// Returns:
+//   - A BMemCache instance for byte slices.
+func NewBytes(options ...Option) BMemCache[[]byte] {
+	return New[[]byte](append([]Option{WithSizeOf(func(b []byte) int64 { return int64(len(b)) })}, options...)...)
+}
+
+// NewString creates a cache of strings whose Size reports the total number of bytes stored.
+//
+// It is a thin wrapper over New prewiring WithSizeOf with the length of the strings. The given
+// options are applied afterwards, so they can override it.
+//
+// Parameters:
+//   - options: A variadic list of Option values to configure the cache.
+//
+// Returns:
+//   - A BMemCache instance for strings.
+func NewString(options ...Option) BMemCache[string] {
+	return New[string](append([]Option{WithSizeOf(func(s string) int64 { return int64(len(s)) })}, options...)...)
+}
+
+// GetAs retrieves the cached data associated with the provided keys and converts it with conv.
+//
+// It is a thin wrapper over Get for callers needing a projection of the cached values, such
+// as a single field of a struct. conv is only called on a hit.
+//
+// Parameters:
+//   - c: The cache to read from.
+//   - conv: The function converting the cached data.
+//   - keys: A variadic list of strings used to generate the cache key.
+//
+// Returns:
+//   - The converted data, or the zero value of U if Get fails.
+//   - The error returned by Get, if any.
+func GetAs[T, U any](c BMemCache[T], conv func(T) U, keys ...string) (U, error) {
+	data, err := c.Get(keys...)
+	if err != nil {
+		return generateEmptyData[U](), err
+	}
+	return conv(data), nil
+}
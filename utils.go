@@ -1,7 +1,9 @@
 package bmemcache
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 )
 
 // generateEmptyData returns the zero value for a given type T.
@@ -48,3 +50,114 @@ func deserializeKey(s string) []string {
 	_ = json.Unmarshal([]byte(s), &keys)
 	return keys
 }
+
+// encodeCursor converts a serialized cache key into an opaque paging cursor.
+//
+// Parameters:
+//   - key: The serialized key of the last key returned.
+//
+// Returns:
+//   - A URL-safe cursor string.
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodeCursor converts a paging cursor back into the serialized key it was created from.
+//
+// Parameters:
+//   - cursor: A cursor created by encodeCursor, or an empty string.
+//
+// Returns:
+//   - The serialized key, or an empty string for an empty cursor.
+//   - false if the cursor is malformed, true otherwise.
+func decodeCursor(cursor string) (string, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// hasKeyPrefix reports whether the key parts begin with the given prefix parts.
+//
+// Parameters:
+//   - keys: The key parts to check.
+//   - prefix: The prefix parts to match. An empty prefix matches any key.
+//
+// Returns:
+//   - true if every prefix part equals the key part at the same position, false otherwise.
+func hasKeyPrefix(keys, prefix []string) bool {
+	if len(keys) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if keys[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// typedOption converts a generic option value stored in option into the concrete type
+// expected by a BMemCache[T].
+//
+// Generic options (such as WithOnExpire) are stored untyped because option is shared by
+// every cache type. A mismatch between the option's type parameter and the cache's type
+// parameter is a programming error, so it panics instead of being silently ignored.
+//
+// Parameters:
+//   - v: The untyped option value, or nil if the option was not provided.
+//   - name: The name of the option constructor, used in the panic message.
+//
+// Returns:
+//   - The typed option value, or the zero value of F if v is nil.
+func typedOption[F any](v any, name string) F {
+	if v == nil {
+		return generateEmptyData[F]()
+	}
+	f, ok := v.(F)
+	if !ok {
+		panic(fmt.Sprintf("bmemcache: %s: got %T, want %T", name, v, f))
+	}
+	return f
+}
+
+// hasKeySuffix reports whether the key parts end with the given suffix parts.
+//
+// Parameters:
+//   - keys: The key parts to check.
+//   - suffix: The suffix parts to match. An empty suffix matches any key.
+//
+// Returns:
+//   - true if every suffix part equals the key part at the same position from the end, false
+//     otherwise.
+func hasKeySuffix(keys, suffix []string) bool {
+	if len(keys) < len(suffix) {
+		return false
+	}
+	offset := len(keys) - len(suffix)
+	for i := range suffix {
+		if keys[offset+i] != suffix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// lessKey reports whether the key parts a sort before b: parts are compared one by one as
+// strings, and a key sorts before the keys it is a prefix of.
+//
+// Parameters:
+//   - a: The key parts to compare.
+//   - b: The key parts to compare against.
+//
+// Returns:
+//   - true if a sorts before b, false otherwise.
+func lessKey(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,66 @@
+package bmemcache
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+func (c *bmemCache[T]) WaitGet(ctx context.Context, keys ...string) (T, error) {
+	if err := c.validateKeys(keys); err != nil {
+		return generateEmptyData[T](), err
+	}
+	key := serializeKey(keys)
+	for {
+		// The write lock makes the lookup and the registration atomic, so that a write cannot
+		// slip in between and be missed.
+		c.mu.Lock()
+		if entry, ok := c.items[key]; ok && !entry.isExpired() {
+			c.mu.Unlock()
+			c.recordHit(entry)
+			return c.value(entry)
+		}
+		ch := make(chan struct{})
+		if c.waiters == nil {
+			c.waiters = make(map[string][]chan struct{})
+		}
+		c.waiters[key] = append(c.waiters[key], ch)
+		c.mu.Unlock()
+
+		select {
+		case <-ch:
+			// Look the key up again: the entry may already have been replaced or removed.
+		case <-ctx.Done():
+			c.mu.Lock()
+			c.removeWaiterLocked(key, ch)
+			c.mu.Unlock()
+			atomic.AddInt64(&c.misses, 1)
+			return generateEmptyData[T](), ctx.Err()
+		}
+	}
+}
+
+// signalWaitersLocked wakes up the WaitGet calls waiting for the given serialized key. It must
+// be called with the write lock held.
+func (c *bmemCache[T]) signalWaitersLocked(key string) {
+	for _, ch := range c.waiters[key] {
+		close(ch)
+	}
+	delete(c.waiters, key)
+}
+
+// removeWaiterLocked unregisters a WaitGet call that stopped waiting for the given serialized
+// key. It must be called with the write lock held.
+func (c *bmemCache[T]) removeWaiterLocked(key string, ch chan struct{}) {
+	waiters := c.waiters[key]
+	for i, waiter := range waiters {
+		if waiter == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(c.waiters, key)
+	} else {
+		c.waiters[key] = waiters
+	}
+}
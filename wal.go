@@ -0,0 +1,125 @@
+package bmemcache
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Operations recorded in the write-ahead log.
+const (
+	walSet   = "set"
+	walDel   = "del"
+	walClear = "clear"
+)
+
+// walRecord is a line of the write-ahead log written by WithWriteThrough.
+type walRecord struct {
+	Op        string          `json:"op"`
+	Keys      []string        `json:"keys,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	ExpiresAt *time.Time      `json:"exp,omitempty"`
+}
+
+// walOp is a storage change waiting to be written to the write-ahead log. Entries are
+// immutable, so they are only encoded when the log is flushed.
+type walOp[T any] struct {
+	op    string
+	key   string
+	entry *cacheEntry[T]
+}
+
+// logLocked queues a storage change for the write-ahead log, if configured. It must be called
+// with the write lock held.
+func (c *bmemCache[T]) logLocked(op, key string, entry *cacheEntry[T]) {
+	if c.walWriter != nil {
+		c.walPending = append(c.walPending, walOp[T]{op: op, key: key, entry: entry})
+	}
+}
+
+// writeThrough flushes the write-ahead log on every interval, until the cache is closed.
+func (c *bmemCache[T]) writeThrough(interval time.Duration) {
+	defer close(c.walDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	w := bufio.NewWriter(c.walWriter)
+	for {
+		select {
+		case <-ticker.C:
+			c.flushLog(w)
+		case <-c.doneChan:
+			c.flushLog(w)
+			return
+		}
+	}
+}
+
+// flushLog writes the queued storage changes to w. Values that cannot be encoded to JSON and
+// write errors are dropped, since there is no caller to report them to.
+func (c *bmemCache[T]) flushLog(w *bufio.Writer) {
+	c.mu.Lock()
+	pending := c.walPending
+	c.walPending = nil
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	enc := json.NewEncoder(w)
+	for _, p := range pending {
+		record := walRecord{Op: p.op, Keys: deserializeKey(p.key)}
+		if p.op == walSet {
+			data, err := c.value(p.entry)
+			if err != nil {
+				continue
+			}
+			if record.Value, err = json.Marshal(data); err != nil {
+				continue
+			}
+			if !p.entry.Exp.IsZero() {
+				exp := p.entry.Exp
+				record.ExpiresAt = &exp
+			}
+		}
+		_ = enc.Encode(record)
+	}
+	_ = w.Flush()
+}
+
+func (c *bmemCache[T]) Replay(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var record walRecord
+		if err := dec.Decode(&record); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("bmemcache: replay record %d: %w", line, err)
+		}
+		switch record.Op {
+		case walSet:
+			var data T
+			if err := json.Unmarshal(record.Value, &data); err != nil {
+				return fmt.Errorf("bmemcache: replay record %d: %w", line, err)
+			}
+			var exp time.Time
+			if record.ExpiresAt != nil {
+				exp = *record.ExpiresAt
+			}
+			if exp.IsZero() || exp.After(time.Now()) {
+				_ = c.trySetWithExpireAt(data, exp, record.Keys)
+			}
+		case walDel:
+			key := serializeKey(record.Keys)
+			c.mu.Lock()
+			if !c.frozen {
+				c.removeLocked(key)
+			}
+			c.mu.Unlock()
+		case walClear:
+			c.Clear()
+		default:
+			return fmt.Errorf("bmemcache: replay record %d: unknown operation %q", line, record.Op)
+		}
+	}
+}